@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/config"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/http"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/http/usecases"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/region"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// command. subcommand of the binary, run parses its own flags from args
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, logger *zap.Logger, args []string) error
+}
+
+var commands = []command{
+	{"scrape", "scrape waze periodically and write the matched traffic to the storage", runScrape},
+	{"serve", "scrape waze periodically and serve the latest & stored traffic over http", runServe},
+	{"replay", "rebuild the outputs from the raw waze response archive", runReplay},
+	{"export", "convert the traffic csv files into partitioned parquet or the wide traffic csv", runExport},
+	{"inspect-osm", "print statistics of the road network parsed from the osm pbf file", runInspectOSM},
+}
+
+// closeStorage. flush the buffered rows & finalize the open files (parquet) before exit
+func closeStorage(storage scraper.Storage, logger *zap.Logger) {
+	if err := storage.Close(); err != nil {
+		logger.Error("failed to close storage", zap.Error(err))
+	}
+}
+
+// scrapeRegions. run the scrape loop of every region concurrently, each region writes into its own storage & archive.
+// returns when ctx is cancelled, after every storage is closed
+func scrapeRegions(ctx context.Context, cfg *config.Config, registry *region.Registry, logger *zap.Logger) error {
+	regions := registry.GetRegions()
+	storages := make([]scraper.Storage, len(regions))
+	archives := make([]*scraper.RawArchive, len(regions))
+	// open every output before starting the scrape loops
+	for i, r := range regions {
+		storage, err := newStorage(cfg, r.GetOutput(), logger)
+		if err != nil {
+			return err
+		}
+		defer closeStorage(storage, logger)
+		storages[i] = storage
+		archives[i], err = newArchive(cfg, r.GetOutput())
+		if err != nil {
+			return err
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, r := range regions {
+		g.Go(func() error {
+			return r.GetScraper().ScrapePeriodically(gctx, storages[i], archives[i])
+		})
+	}
+	return g.Wait()
+}
+
+func runScrape(ctx context.Context, logger *zap.Logger, args []string) error {
+	opts := newOptions("scrape")
+	opts.addOSMFlags()
+	opts.addOutputFlags()
+	opts.addScraperFlags()
+	opts.addStorageFlags()
+	opts.addArchiveFlags("directory of the raw waze response archive, empty to disable archiving")
+	regionFlag := opts.addRegionFlags()
+	cfg, err := opts.load(args)
+	if err != nil {
+		return err
+	}
+
+	registry, err := region.NewRegistry(cfg, regionNames(*regionFlag), logger)
+	if err != nil {
+		return err
+	}
+	if err := scrapeRegions(ctx, cfg, registry, logger); err != nil {
+		return err
+	}
+	logger.Info("waze traffic scraper stopped")
+	return nil
+}
+
+func runServe(ctx context.Context, logger *zap.Logger, args []string) error {
+	opts := newOptions("serve")
+	opts.addOSMFlags()
+	opts.addOutputFlags()
+	opts.addScraperFlags()
+	opts.addStorageFlags()
+	opts.addArchiveFlags("directory of the raw waze response archive, empty to disable archiving")
+	opts.addFlag("port", "server.port", "api port")
+	regionFlag := opts.addRegionFlags()
+	cfg, err := opts.load(args)
+	if err != nil {
+		return err
+	}
+
+	registry, err := region.NewRegistry(cfg, regionNames(*regionFlag), logger)
+	if err != nil {
+		return err
+	}
+
+	// the history endpoints read the outputs the scrape loop writes
+	histories := make(map[string]*scraper.History)
+	for _, r := range registry.GetRegions() {
+		reader, err := newHistoryReader(cfg, r.GetOutput())
+		if err != nil {
+			return err
+		}
+		history := r.GetScraper().NewHistory(reader)
+		defer history.Close()
+		histories[r.GetName()] = history
+	}
+
+	// scrape in the background and serve the latest snapshot of every region, the api never sends requests to waze
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return scrapeRegions(gctx, cfg, registry, logger)
+	})
+	g.Go(func() error {
+		_, err := http.NewServer(logger).Use(gctx, logger, cfg.Server, usecases.NewTrafficService(logger, registry, histories,
+			cfg.Server.TileCacheSize, cfg.Server.StreamBuffer))
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	logger.Info("waze traffic scraper & API stopped")
+	return nil
+}
+
+func runReplay(ctx context.Context, logger *zap.Logger, args []string) error {
+	opts := newOptions("replay")
+	opts.addOSMFlags()
+	opts.addOutputFlags()
+	opts.addStorageFlags()
+	opts.addArchiveFlags("directory of the raw waze response archive")
+	replayOutput := opts.fs.String("replayOut", "", "output file name of the replay (default <output>_replay), "+
+		"only with a single region")
+	regionFlag := opts.addRegionFlags()
+	cfg, err := opts.load(args)
+	if err != nil {
+		return err
+	}
+	if cfg.Storage.ArchiveDir == "" {
+		return errors.New("replay needs the raw archive directory (-archive)")
+	}
+
+	registry, err := region.NewRegistry(cfg, regionNames(*regionFlag), logger)
+	if err != nil {
+		return err
+	}
+	if *replayOutput != "" && len(registry.GetRegions()) > 1 {
+		return errors.New("-replayOut needs a single region (-region)")
+	}
+
+	for _, r := range registry.GetRegions() {
+		archive, err := newArchive(cfg, r.GetOutput())
+		if err != nil {
+			return err
+		}
+		// don't overwrite the outputs of the scraper
+		storageName := *replayOutput
+		if storageName == "" {
+			storageName = r.GetOutput() + "_replay"
+		}
+		storage, err := newStorage(cfg, storageName, logger)
+		if err != nil {
+			return err
+		}
+
+		// rebuild the outputs from the archived raw responses instead of scraping waze
+		err = r.GetScraper().Replay(ctx, archive, storage)
+		closeStorage(storage, logger)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to replay region %s: %s", r.GetName(), err.Error()))
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, logger *zap.Logger, args []string) error {
+	opts := newOptions("export")
+	opts.addOutputFlags()
+	opts.addPartitionFlags()
+	opts.addOSMFlags()
+	to := opts.fs.String("to", "parquet", "export format: parquet (from the wide traffic csv & metadata csv) or wide "+
+		"(from the long traffic csv into waze_traffic_wide_<output>.csv, needs the osm file for the free flow speeds)")
+	overwrite := opts.fs.Bool("overwrite", false, "replace an existing waze_traffic_wide_<output>.csv")
+	regionFlag := opts.addRegionFlags()
+	cfg, err := opts.load(args)
+	if err != nil {
+		return err
+	}
+	if *to != "parquet" && *to != "wide" {
+		return errors.New(fmt.Sprintf("unknown export format %q, expected parquet or wide", *to))
+	}
+	regionConfigs, err := region.SelectRegions(cfg, regionNames(*regionFlag))
+	if err != nil {
+		return err
+	}
+	if *to == "wide" && !*overwrite {
+		for _, regionConfig := range regionConfigs {
+			widePath := dataPath(cfg, "waze_traffic_wide_%s.csv", regionConfig.Output)
+			if _, err := os.Stat(widePath); err == nil {
+				return errors.New(fmt.Sprintf("%s already exists, pass -overwrite to replace it", widePath))
+			}
+		}
+	}
+
+	for _, regionConfig := range regionConfigs {
+		output := regionConfig.Output
+		switch *to {
+		case "parquet":
+			// offline export of the existing traffic & metadata csv files into partitioned parquet, doesn't need the osm file
+			partitionInterval, err := scraper.ParsePartitionInterval(cfg.Storage.Partition)
+			if err != nil {
+				return err
+			}
+			dir := dataPath(cfg, "parquet/%s", output)
+			err = scraper.ExportCSVToParquet(dataPath(cfg, "waze_traffic_%s.csv", output),
+				dataPath(cfg, "waze_metadata_%s.csv", output), dir, partitionInterval)
+			if err != nil {
+				return err
+			}
+			logger.Info("exported traffic csv to parquet", zap.String("region", regionConfig.Name), zap.String("dir", dir))
+		case "wide":
+			// convert the long format traffic time series into the wide matrix (one column per osm way & direction). the
+			// legacy waze_traffic_<output>.csv (one column per osm way) is never touched
+			widePath := dataPath(cfg, "waze_traffic_wide_%s.csv", output)
+			network := region.LoadRoadNetwork(regionConfig.OSMFile, cfg, logger)
+			err := scraper.ConvertTrafficCSVToWide(dataPath(cfg, "waze_traffic_long_%s.csv", output), widePath,
+				network.GetWaySpeed())
+			if err != nil {
+				return err
+			}
+			logger.Info("converted long traffic csv to wide traffic csv", zap.String("region", regionConfig.Name),
+				zap.String("file", widePath))
+		}
+	}
+	return nil
+}
+
+func runInspectOSM(ctx context.Context, logger *zap.Logger, args []string) error {
+	opts := newOptions("inspect-osm")
+	opts.addOSMFlags()
+	wayId := opts.fs.Int64("way", 0, "print the edges of this osm way")
+	cfg, err := opts.load(args)
+	if err != nil {
+		return err
+	}
+
+	network := region.LoadRoadNetwork(cfg.Index.OSMFile, cfg, logger)
+	graph := network.GetOsmParser().GetGraph()
+
+	highwayCount := make(map[string]int)
+	totalLength := 0.0
+	minLon, minLat, maxLon, maxLat := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, edge := range network.GetEdges() {
+		highwayCount[edge.GetHighwayTypeString()]++
+		totalLength += edge.GetLength()
+		for _, coord := range edge.GetGeometry() {
+			lon, lat := coord.GetLonLat()
+			minLon, minLat = min(minLon, lon), min(minLat, lat)
+			maxLon, maxLat = max(maxLon, lon), max(maxLat, lat)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "osm file\t%s\n", cfg.Index.OSMFile)
+	fmt.Fprintf(w, "nodes\t%d\n", graph.NumberOfNodes())
+	fmt.Fprintf(w, "edges\t%d\n", graph.NumberOfEdges())
+	fmt.Fprintf(w, "ways\t%d\n", len(network.GetOsmParser().GetWayMap()))
+	fmt.Fprintf(w, "total edge length (km)\t%.2f\n", totalLength)
+	fmt.Fprintf(w, "bounding box (min lon, min lat, max lon, max lat)\t%.6f, %.6f, %.6f, %.6f\n",
+		minLon, minLat, maxLon, maxLat)
+
+	highwayTypes := make([]string, 0, len(highwayCount))
+	for highwayType := range highwayCount {
+		highwayTypes = append(highwayTypes, highwayType)
+	}
+	sort.Slice(highwayTypes, func(i, j int) bool {
+		return highwayCount[highwayTypes[i]] > highwayCount[highwayTypes[j]]
+	})
+	fmt.Fprintln(w, "\nhighway\tedges")
+	for _, highwayType := range highwayTypes {
+		fmt.Fprintf(w, "%s\t%d\n", highwayType, highwayCount[highwayType])
+	}
+
+	if *wayId != 0 {
+		fmt.Fprintf(w, "\nedges of osm way %d\n", *wayId)
+		fmt.Fprintln(w, "edge\tfrom\tto\tdirection\tbidirectional\thighway\tspeed\tlength (m)\tway offset (m)")
+		for _, edge := range network.GetEdges() {
+			if edge.GetOsmWayId() != *wayId {
+				continue
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%t\t%s\t%.1f\t%.1f\t%.1f\n", edge.GetEdgeId(), edge.GetFromNodeId(),
+				edge.GetToNodeId(), edge.GetDirection().String(), edge.IsBidirectional(), edge.GetHighwayTypeString(),
+				edge.GetSpeed(), edge.GetLength()*1000, edge.GetWayOffset()*1000)
+		}
+	}
+	return w.Flush()
+}
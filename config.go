@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/config"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
+	"go.uber.org/zap"
+)
+
+// options. flag set of a subcommand, every subcommand only registers the flags it uses. the flags override the
+// config file & env vars, see config.Load
+type options struct {
+	fs         *flag.FlagSet
+	configFile string
+	flagKeys   map[string]string // flag name -> config key
+}
+
+func newOptions(name string) *options {
+	o := &options{
+		fs:       flag.NewFlagSet(name, flag.ExitOnError),
+		flagKeys: make(map[string]string),
+	}
+	o.fs.StringVar(&o.configFile, "config", "", "path to the yaml/toml/json config file")
+	return o
+}
+
+// addFlag. flag overriding the config key
+func (o *options) addFlag(name, key, usage string) {
+	o.fs.String(name, fmt.Sprint(config.Default(key)), fmt.Sprintf("%s (config %s)", usage, key))
+	o.flagKeys[name] = key
+}
+
+// addRegionFlags. -region selects the regions (comma separated names) of the command, every region by default
+func (o *options) addRegionFlags() *string {
+	return o.fs.String("region", "", "comma separated region names (default every region)")
+}
+
+// regionNames. names of the -region flag
+func regionNames(flagValue string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(flagValue, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (o *options) addOutputFlags() {
+	o.addFlag("out", "storage.output", "traffic output file name, when no regions are configured")
+}
+
+func (o *options) addOSMFlags() {
+	o.addFlag("osm", "index.osm_file", "path to osm pbf file, when no regions are configured")
+}
+
+func (o *options) addScraperFlags() {
+	o.addFlag("bLon", "scraper.bounding_box.min_lon", "traffic bounding box: bottom longitude")
+	o.addFlag("bLat", "scraper.bounding_box.min_lat", "traffic bounding box: bottom latitude")
+	o.addFlag("tLon", "scraper.bounding_box.max_lon", "traffic bounding box: top longitude")
+	o.addFlag("tLat", "scraper.bounding_box.max_lat", "traffic bounding box: top latitude")
+	o.addFlag("tile", "scraper.tile_size", "maximum side length (in degrees) of each waze georss request tile")
+	o.addFlag("concurrency", "scraper.concurrency", "maximum number of concurrent waze georss requests")
+}
+
+func (o *options) addStorageFlags() {
+	o.addFlag("storage", "storage.backend", "storage backend of the scraped traffic: csv, sqlite or parquet")
+	o.addPartitionFlags()
+}
+
+func (o *options) addPartitionFlags() {
+	o.addFlag("partition", "storage.partition", "parquet partition interval: day or hour")
+}
+
+func (o *options) addArchiveFlags(usage string) {
+	o.addFlag("archive", "storage.archive_dir", usage)
+}
+
+// load. parse the flags and load the config, only the flags set on the command line override the config
+func (o *options) load(args []string) (*config.Config, error) {
+	o.fs.Parse(args)
+	overrides := make(map[string]any)
+	o.fs.Visit(func(f *flag.Flag) {
+		if key, ok := o.flagKeys[f.Name]; ok {
+			overrides[key] = f.Value.String()
+		}
+	})
+	return config.Load(o.configFile, overrides)
+}
+
+// dataPath. path of the output file inside the data directory
+func dataPath(cfg *config.Config, format string, a ...any) string {
+	return filepath.Join(cfg.Storage.DataDir, fmt.Sprintf(format, a...))
+}
+
+// newStorage. storage backend of the scraped traffic, output files are named after name
+func newStorage(cfg *config.Config, name string, logger *zap.Logger) (scraper.Storage, error) {
+	partitionInterval, err := scraper.ParsePartitionInterval(cfg.Storage.Partition)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Storage.Backend {
+	case "csv":
+		return scraper.NewCSVStorage(dataPath(cfg, "waze_traffic_long_%s.csv", name),
+			dataPath(cfg, "waze_metadata_%s.csv", name), dataPath(cfg, "waze_alerts_%s.csv", name),
+			dataPath(cfg, "waze_alerts_%s.jsonl", name), dataPath(cfg, "waze_irregularities_%s.jsonl", name),
+			dataPath(cfg, "waze_way_ranges_%s.csv", name), dataPath(cfg, "waze_diagnostics_%s.csv", name))
+	case "sqlite":
+		return scraper.NewSQLiteStorage(dataPath(cfg, "waze_traffic_%s.db", name))
+	case "parquet":
+		return scraper.NewParquetStorage(dataPath(cfg, "parquet/%s", name), partitionInterval, logger)
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown storage backend %q, expected csv, sqlite or parquet", cfg.Storage.Backend))
+	}
+}
+
+// newHistoryReader. reads the outputs of the storage backend named after name, see newStorage
+func newHistoryReader(cfg *config.Config, name string) (scraper.HistoryReader, error) {
+	switch cfg.Storage.Backend {
+	case "csv":
+		return scraper.NewCSVHistoryReader(dataPath(cfg, "waze_traffic_long_%s.csv", name),
+			dataPath(cfg, "waze_diagnostics_%s.csv", name)), nil
+	case "sqlite":
+		return scraper.NewSQLiteHistoryReader(dataPath(cfg, "waze_traffic_%s.db", name))
+	case "parquet":
+		return scraper.NewParquetHistoryReader(dataPath(cfg, "parquet/%s", name)), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown storage backend %q, expected csv, sqlite or parquet", cfg.Storage.Backend))
+	}
+}
+
+// newArchive. raw waze response archive of the region output, nil if archiving is disabled
+func newArchive(cfg *config.Config, output string) (*scraper.RawArchive, error) {
+	if cfg.Storage.ArchiveDir == "" {
+		return nil, nil
+	}
+	return scraper.NewRawArchive(cfg.Storage.ArchiveDir, output)
+}
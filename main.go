@@ -2,70 +2,73 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
-	"time"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/logger"
-	"github.com/lintang-b-s/waze-traffic-scraper/pkg/osmparser"
-	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
-	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
-)
-
-var (
-	bbBottomLon    = flag.Float64("bLon", 110.132, "traffic bounding box: bottom longitude")
-	bbBottomLat    = flag.Float64("bLat", -8.2618, "traffic bounding box: bottom latitude")
-	bbTopLon       = flag.Float64("tLon", 110.9221, "traffic bounding box: top longitude")
-	bbTopLat       = flag.Float64("tLat", -6.888, "traffic bounding box: top latitude")
-	osmFile        = flag.String("osm", "./data/diy_solo_semarang.osm.pbf", "path to osm pbf file")
-	outputFileName = flag.String("out", "diy_solo_semarang", "traffic output file name")
+	"go.uber.org/zap"
 )
 
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+	// without a subcommand the flags are passed to scrape, like the binary before the subcommands
+	name := "scrape"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		if name != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+
 	logger, err := logger.New()
 	if err != nil {
 		panic(err)
 	}
-	osmParser := osmparser.NewOSMParserV2()
-	wazeUrl := fmt.Sprintf(`https://www.waze.com/live-map/api/georss?top=%.4f&bottom=%.4f&&left=%.4f&&right=%.4f&&env=row&types=traffic`, *bbTopLat, *bbBottomLat, *bbBottomLon, *bbTopLon)
-
-	arcs, waySpeed := osmParser.Parse(*osmFile, logger)
-	rt := spatialindex.NewRtree()
-	rt.Build(arcs, 0.03, logger)
-
-	// --scraper--
-	scp := scraper.NewScraper(4000*time.Millisecond, 3*time.Millisecond, 81*time.Millisecond, 20*time.Second,
-		10*time.Millisecond, 2, wazeUrl, 5, rt, logger, waySpeed, osmParser.GetStreetIdMap(), osmParser.GetWayMap())
-	err = scp.ScrapePeriodically(fmt.Sprintf("./data/waze_traffic_%s.csv", *outputFileName),
-		fmt.Sprintf("./data/waze_metadata_%s.csv", *outputFileName))
+	ctx, cleanup, err := NewContext()
 	if err != nil {
 		panic(err)
 	}
+	defer cleanup()
 
-	// --server--
-	// scp := scraper.NewScraper(4000*time.Millisecond, 3*time.Millisecond, 81*time.Millisecond, 20*time.Second,
-	// 	10*time.Millisecond, 2, wazeUrl, 5, rt, logger, waySpeed, osmParser.GetStreetIdMap(), osmParser.GetWayMap())
-	// api := http.NewServer(logger)
-	// trafficService := usecases.NewTrafficService(logger, scp)
-	// ctx, cleanup, err := NewContext()
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// api.Use(ctx,
-	// 	logger, false, trafficService)
-
-	// signal := http.GracefulShutdown()
-
-	// logger.Info("Navigatorx Routing Engine Server Stopped", zap.String("signal", signal.String()))
-	// cleanup()
+	if err := cmd.run(ctx, logger, args); err != nil {
+		logger.Error(fmt.Sprintf("%s failed", cmd.name), zap.Error(err))
+		cleanup()
+		os.Exit(1)
+	}
+}
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> -h' for the flags of a command\n", os.Args[0])
 }
 
+// NewContext. context cancelled on SIGINT, SIGQUIT or SIGTERM, a second signal kills the process
 func NewContext() (context.Context, func(), error) {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	go func() {
+		// restore the default signal behavior once the shutdown started
+		<-ctx.Done()
+		stop()
+	}()
 	cb := func() {
-		cancel()
+		stop()
 	}
 
 	return ctx, cb, nil
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/mapmatching"
+	"github.com/spf13/viper"
+)
+
+// Config. settings of the scraper, the map matcher, the spatial index, the storage and the api server. loaded from a
+// yaml/toml/json file and overridden by WAZE_ prefixed env vars, e.g. WAZE_SCRAPER_PERIOD=30s overrides scraper.period
+type Config struct {
+	Scraper ScraperConfig  `mapstructure:"scraper"`
+	Matcher MatcherConfig  `mapstructure:"matcher"`
+	Index   IndexConfig    `mapstructure:"index"`
+	Storage StorageConfig  `mapstructure:"storage"`
+	Server  ServerConfig   `mapstructure:"server"`
+	Regions []RegionConfig `mapstructure:"regions" validate:"unique=Name,dive"`
+}
+
+type ScraperConfig struct {
+	URL              string               `mapstructure:"url" validate:"required,url"`
+	BoundingBox      BoundingBoxConfig    `mapstructure:"bounding_box"`
+	TileSize         float64              `mapstructure:"tile_size" validate:"gt=0"`   // maximum side length (in degrees) of each request tile
+	Concurrency      int                  `mapstructure:"concurrency" validate:"gt=0"` // maximum number of concurrent requests
+	Period           time.Duration        `mapstructure:"period" validate:"gt=0"`
+	MaxJitter        time.Duration        `mapstructure:"max_jitter" validate:"gt=0"` // random delay added to the period & the retry backoff
+	RequestTimeout   time.Duration        `mapstructure:"request_timeout" validate:"gt=0"`
+	InitialTimeout   time.Duration        `mapstructure:"initial_timeout" validate:"gt=0"` // first retry backoff
+	MaxTimeout       time.Duration        `mapstructure:"max_timeout" validate:"gtefield=InitialTimeout"`
+	BackoffFactor    float64              `mapstructure:"backoff_factor" validate:"gte=1"`
+	RetryCount       int                  `mapstructure:"retry_count" validate:"gte=0"`
+	AlertSnapRadiusM float64              `mapstructure:"alert_snap_radius_m" validate:"gt=0"`
+	CircuitBreaker   CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	Diagnostics      DiagnosticsConfig    `mapstructure:"diagnostics"`
+	StaleData        string               `mapstructure:"stale_data" validate:"oneof=skip flag"` // repeated (frozen) waze responses
+}
+
+type BoundingBoxConfig struct {
+	MinLon float64 `mapstructure:"min_lon" validate:"gte=-180,lte=180"`
+	MinLat float64 `mapstructure:"min_lat" validate:"gte=-90,lte=90"`
+	MaxLon float64 `mapstructure:"max_lon" validate:"gte=-180,lte=180,gtfield=MinLon"`
+	MaxLat float64 `mapstructure:"max_lat" validate:"gte=-90,lte=90,gtfield=MinLat"`
+}
+
+type CircuitBreakerConfig struct {
+	Threshold   int           `mapstructure:"threshold" validate:"gt=0"` // consecutive failed scrapes before the breaker opens
+	Cooldown    time.Duration `mapstructure:"cooldown" validate:"gt=0"`
+	MaxCooldown time.Duration `mapstructure:"max_cooldown" validate:"gtefield=Cooldown"`
+}
+
+// DiagnosticsConfig. a scrape diagnostic (jams, affected ways, unmatched ratio, snap distance, data age) more than
+// deviation_factor times above or below the median of the last history_size scrapes is logged as a warning
+type DiagnosticsConfig struct {
+	HistorySize     int     `mapstructure:"history_size" validate:"gt=0"`
+	MinHistory      int     `mapstructure:"min_history" validate:"gt=0,ltefield=HistorySize"` // scrapes before comparing
+	DeviationFactor float64 `mapstructure:"deviation_factor" validate:"gt=1"`
+}
+
+type MatcherConfig struct {
+	SearchRadiusM float64 `mapstructure:"search_radius_m" validate:"gt=0"`
+	SigmaZ        float64 `mapstructure:"sigma_z" validate:"gt=0"` // standard deviation of the gps noise (m)
+	Beta          float64 `mapstructure:"beta" validate:"gt=0"`    // expected difference between route distance & great circle distance (m)
+}
+
+type IndexConfig struct {
+	OSMFile       string  `mapstructure:"osm_file" validate:"required"`
+	RtreeBufferKm float64 `mapstructure:"rtree_buffer_km" validate:"gte=0"`
+}
+
+type StorageConfig struct {
+	Backend    string `mapstructure:"backend" validate:"oneof=csv sqlite parquet"`
+	Partition  string `mapstructure:"partition" validate:"oneof=day hour"` // parquet partition interval
+	DataDir    string `mapstructure:"data_dir" validate:"required"`
+	Output     string `mapstructure:"output" validate:"required"` // output file name
+	ArchiveDir string `mapstructure:"archive_dir"`                // raw waze response archive, empty to disable archiving
+}
+
+// RegionConfig. area scraped by its own scrape loop, regions with the same osm file share the parsed road network
+type RegionConfig struct {
+	Name        string            `mapstructure:"name" validate:"required,excludesall=/?#% "` // used in the api routes
+	BoundingBox BoundingBoxConfig `mapstructure:"bounding_box"`
+	OSMFile     string            `mapstructure:"osm_file" validate:"required"`
+	Output      string            `mapstructure:"output" validate:"required"` // output file name
+	Period      time.Duration     `mapstructure:"period" validate:"gte=0"`    // scrape period, 0 uses scraper.period
+}
+
+type ServerConfig struct {
+	Port              int           `mapstructure:"port" validate:"gt=0,lte=65535"`
+	Timeout           time.Duration `mapstructure:"timeout" validate:"gt=0"`
+	ShutdownTimeout   time.Duration `mapstructure:"shutdown_timeout" validate:"gt=0"`
+	ReadTimeout       time.Duration `mapstructure:"read_timeout" validate:"gt=0"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout" validate:"gt=0"` // added to timeout
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout" validate:"gt=0"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout" validate:"gt=0"`
+	RateLimit         bool          `mapstructure:"rate_limit"`
+	TileCacheSize     int           `mapstructure:"tile_cache_size" validate:"gt=0"` // cached vector tiles per region
+	StreamBuffer      int           `mapstructure:"stream_buffer" validate:"gt=0"`   // diffs queued per stream subscriber
+}
+
+// GetAlertSnapRadius. in km
+func (c ScraperConfig) GetAlertSnapRadius() float64 {
+	return c.AlertSnapRadiusM / 1000
+}
+
+// GetSearchRadius. in km
+func (c MatcherConfig) GetSearchRadius() float64 {
+	return c.SearchRadiusM / 1000
+}
+
+// GetRegions. the configured regions, without regions one region is built from scraper.bounding_box, index.osm_file
+// and storage.output
+func (c *Config) GetRegions() []RegionConfig {
+	if len(c.Regions) > 0 {
+		return c.Regions
+	}
+	return []RegionConfig{{
+		Name:        c.Storage.Output,
+		BoundingBox: c.Scraper.BoundingBox,
+		OSMFile:     c.Index.OSMFile,
+		Output:      c.Storage.Output,
+	}}
+}
+
+// GetRegionScraperConfig. scraper settings with the bounding box & period of the region
+func (c *Config) GetRegionScraperConfig(region RegionConfig) ScraperConfig {
+	scraperConfig := c.Scraper
+	scraperConfig.BoundingBox = region.BoundingBox
+	if region.Period > 0 {
+		scraperConfig.Period = region.Period
+	}
+	return scraperConfig
+}
+
+var defaults = map[string]any{
+	"scraper.url":                          "https://www.waze.com/live-map/api/georss",
+	"scraper.bounding_box.min_lon":         110.132,
+	"scraper.bounding_box.min_lat":         -8.2618,
+	"scraper.bounding_box.max_lon":         110.9221,
+	"scraper.bounding_box.max_lat":         -6.888,
+	"scraper.tile_size":                    0.25,
+	"scraper.concurrency":                  4,
+	"scraper.period":                       "20s",
+	"scraper.max_jitter":                   "10ms",
+	"scraper.request_timeout":              "4s",
+	"scraper.initial_timeout":              "3ms",
+	"scraper.max_timeout":                  "81ms",
+	"scraper.backoff_factor":               2.0,
+	"scraper.retry_count":                  5,
+	"scraper.alert_snap_radius_m":          50.0,
+	"scraper.circuit_breaker.threshold":    5,
+	"scraper.circuit_breaker.cooldown":     "5m",
+	"scraper.circuit_breaker.max_cooldown": "1h",
+	"scraper.diagnostics.history_size":     30,
+	"scraper.diagnostics.min_history":      10,
+	"scraper.diagnostics.deviation_factor": 3.0,
+	"scraper.stale_data":                   "skip",
+	"matcher.search_radius_m":              mapmatching.DEFAULT_SEARCH_RADIUS * 1000,
+	"matcher.sigma_z":                      mapmatching.DEFAULT_SIGMA_Z,
+	"matcher.beta":                         mapmatching.DEFAULT_BETA,
+	"index.osm_file":                       "./data/diy_solo_semarang.osm.pbf",
+	"index.rtree_buffer_km":                0.03,
+	"storage.backend":                      "csv",
+	"storage.partition":                    "day",
+	"storage.data_dir":                     "./data",
+	"storage.output":                       "diy_solo_semarang",
+	"storage.archive_dir":                  "./data/raw",
+	"server.port":                          6064,
+	"server.timeout":                       "1000s",
+	"server.shutdown_timeout":              "10s",
+	"server.read_timeout":                  "10s",
+	"server.write_timeout":                 "10s",
+	"server.idle_timeout":                  "30s",
+	"server.read_header_timeout":           "2s",
+	"server.rate_limit":                    false,
+	"server.tile_cache_size":               4096,
+	"server.stream_buffer":                 16,
+}
+
+// Default. default value of the config key (e.g. "scraper.period")
+func Default(key string) any {
+	return defaults[key]
+}
+
+// Load. defaults < config file (if path is not empty) < WAZE_ env vars < overrides (e.g. command line flags), the
+// result is validated
+func Load(path string, overrides map[string]any) (*Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	v.SetEnvPrefix("WAZE")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to read config file %s: %s", path, err.Error()))
+		}
+	}
+	for key, value := range overrides {
+		v.Set(key, value)
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse config: %s", err.Error()))
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate. every invalid field is reported with its config key
+func (c *Config) Validate() error {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return strings.SplitN(field.Tag.Get("mapstructure"), ",", 2)[0]
+	})
+
+	err := validate.Struct(c)
+	if err == nil {
+		return nil
+	}
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+	errs := make([]error, 0, len(validationErrs))
+	for _, e := range validationErrs {
+		// namespace is Config.scraper.period, the config key is scraper.period
+		key := strings.SplitN(e.Namespace(), ".", 2)[1]
+		if e.Kind() != reflect.Slice && e.Kind() != reflect.Struct {
+			key = fmt.Sprintf("%s=%v", key, e.Value())
+		}
+		rule := e.Tag()
+		if e.Param() != "" {
+			rule = fmt.Sprintf("%s=%s", e.Tag(), e.Param())
+		}
+		errs = append(errs, errors.New(fmt.Sprintf("invalid config %s: must satisfy %s", key, rule)))
+	}
+	return errors.Join(errs...)
+}
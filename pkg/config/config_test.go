@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 20*time.Second, cfg.Scraper.Period)
+	assert.Equal(t, 0.05, cfg.Scraper.GetAlertSnapRadius())
+	assert.InDelta(t, 0.025, cfg.Matcher.GetSearchRadius(), 1e-9)
+	assert.Equal(t, "csv", cfg.Storage.Backend)
+	assert.Equal(t, 6064, cfg.Server.Port)
+}
+
+func TestLoadFileEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(yamlPath, []byte(`
+scraper:
+  period: 30s
+  concurrency: 8
+storage:
+  backend: sqlite
+server:
+  port: 8080
+`), 0644)
+	assert.Nil(t, err)
+
+	t.Setenv("WAZE_SCRAPER_CONCURRENCY", "2")
+	cfg, err := Load(yamlPath, map[string]any{"server.port": "9090"})
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Second, cfg.Scraper.Period)
+	assert.Equal(t, 2, cfg.Scraper.Concurrency) // env overrides the file
+	assert.Equal(t, "sqlite", cfg.Storage.Backend)
+	assert.Equal(t, 9090, cfg.Server.Port) // overrides (flags) override the file
+	assert.Equal(t, 4*time.Second, cfg.Scraper.RequestTimeout)
+
+	tomlPath := filepath.Join(dir, "config.toml")
+	err = os.WriteFile(tomlPath, []byte(`
+[storage]
+backend = "parquet"
+partition = "hour"
+`), 0644)
+	assert.Nil(t, err)
+	cfg, err = Load(tomlPath, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "parquet", cfg.Storage.Backend)
+	assert.Equal(t, "hour", cfg.Storage.Partition)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, err := Load("", map[string]any{
+		"storage.backend":              "mysql",
+		"scraper.bounding_box.max_lat": -9.0,
+		"scraper.max_timeout":          "1ms",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "storage.backend")
+	assert.Contains(t, err.Error(), "scraper.bounding_box.max_lat")
+	assert.Contains(t, err.Error(), "scraper.max_timeout")
+}
+
+func TestLoadRegions(t *testing.T) {
+	cfg, err := Load("", nil)
+	assert.Nil(t, err)
+	regions := cfg.GetRegions()
+	assert.Equal(t, 1, len(regions))
+	assert.Equal(t, cfg.Storage.Output, regions[0].Name)
+	assert.Equal(t, cfg.Scraper.BoundingBox, regions[0].BoundingBox)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	err = os.WriteFile(path, []byte(`
+regions:
+  - name: yogyakarta
+    osm_file: diy.osm.pbf
+    output: yogyakarta
+    bounding_box: { min_lon: 110.25, min_lat: -7.95, max_lon: 110.5, max_lat: -7.65 }
+  - name: semarang
+    osm_file: diy.osm.pbf
+    output: semarang
+    period: 30s
+    bounding_box: { min_lon: 110.3, min_lat: -7.1, max_lon: 110.5, max_lat: -6.9 }
+`), 0644)
+	assert.Nil(t, err)
+	cfg, err = Load(path, nil)
+	assert.Nil(t, err)
+	regions = cfg.GetRegions()
+	assert.Equal(t, 2, len(regions))
+	assert.Equal(t, 20*time.Second, cfg.GetRegionScraperConfig(regions[0]).Period)
+	semarang := cfg.GetRegionScraperConfig(regions[1])
+	assert.Equal(t, 30*time.Second, semarang.Period)
+	assert.Equal(t, -7.1, semarang.BoundingBox.MinLat)
+
+	err = os.WriteFile(path, []byte(`
+regions:
+  - name: semarang
+    osm_file: diy.osm.pbf
+    output: semarang
+    bounding_box: { min_lon: 110.3, min_lat: -7.1, max_lon: 110.5, max_lat: -6.9 }
+  - name: semarang
+    osm_file: diy.osm.pbf
+    output: semarang_2
+    bounding_box: { min_lon: 110.3, min_lat: -7.1, max_lon: 110.5, max_lat: -6.9 }
+`), 0644)
+	assert.Nil(t, err)
+	_, err = Load(path, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid config regions: must satisfy unique=Name")
+
+	err = os.WriteFile(path, []byte(`
+regions:
+  - name: semarang
+    osm_file: diy.osm.pbf
+    output: semarang
+    bounding_box: { min_lon: 110.5, min_lat: -7.1, max_lon: 110.3, max_lat: -6.9 }
+`), 0644)
+	assert.Nil(t, err)
+	_, err = Load(path, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "regions[0].bounding_box.max_lon")
+}
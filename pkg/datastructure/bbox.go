@@ -0,0 +1,29 @@
+package datastructure
+
+type BoundingBox struct {
+	minLon, minLat float64
+	maxLon, maxLat float64
+}
+
+func NewBoundingBox(minLon, minLat, maxLon, maxLat float64) BoundingBox {
+	return BoundingBox{
+		minLon: minLon,
+		minLat: minLat,
+		maxLon: maxLon,
+		maxLat: maxLat,
+	}
+}
+
+// GetMin returns the bottom-left corner (lon, lat) of the bounding box
+func (bb BoundingBox) GetMin() (float64, float64) {
+	return bb.minLon, bb.minLat
+}
+
+// GetMax returns the top-right corner (lon, lat) of the bounding box
+func (bb BoundingBox) GetMax() (float64, float64) {
+	return bb.maxLon, bb.maxLat
+}
+
+func (bb BoundingBox) Contains(lon, lat float64) bool {
+	return lon >= bb.minLon && lon <= bb.maxLon && lat >= bb.minLat && lat <= bb.maxLat
+}
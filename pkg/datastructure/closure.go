@@ -0,0 +1,79 @@
+package datastructure
+
+import "time"
+
+// RoadClosure. osm way (in one travel direction) that is blocked by a waze jam with a block type
+type RoadClosure struct {
+	jamUUID     int64
+	way         Way
+	direction   Direction
+	blockType   string
+	description string
+	street      string
+	city        string
+	startTime   time.Time
+	expiration  time.Time
+	lastUpdate  time.Time
+}
+
+func NewRoadClosure(jamUUID int64, way Way, direction Direction, blockType, description, street, city string,
+	startTime, expiration, lastUpdate time.Time) RoadClosure {
+	return RoadClosure{
+		jamUUID:     jamUUID,
+		way:         way,
+		direction:   direction,
+		blockType:   blockType,
+		description: description,
+		street:      street,
+		city:        city,
+		startTime:   startTime,
+		expiration:  expiration,
+		lastUpdate:  lastUpdate,
+	}
+}
+
+func (rc RoadClosure) GetJamUUID() int64 {
+	return rc.jamUUID
+}
+
+func (rc RoadClosure) GetWay() Way {
+	return rc.way
+}
+
+func (rc RoadClosure) GetDirection() Direction {
+	return rc.direction
+}
+
+func (rc RoadClosure) GetBlockType() string {
+	return rc.blockType
+}
+
+func (rc RoadClosure) GetDescription() string {
+	return rc.description
+}
+
+func (rc RoadClosure) GetStreet() string {
+	return rc.street
+}
+
+func (rc RoadClosure) GetCity() string {
+	return rc.city
+}
+
+func (rc RoadClosure) GetStartTime() time.Time {
+	return rc.startTime
+}
+
+// GetExpiration. zero time if waze doesn't provide the block expiration
+func (rc RoadClosure) GetExpiration() time.Time {
+	return rc.expiration
+}
+
+func (rc RoadClosure) GetLastUpdate() time.Time {
+	return rc.lastUpdate
+}
+
+// IsExpired. closure without expiration never expires
+func (rc RoadClosure) IsExpired(now time.Time) bool {
+	return !rc.expiration.IsZero() && now.After(rc.expiration)
+}
@@ -0,0 +1,23 @@
+package datastructure
+
+// Direction. travel direction relative to the order of the osm way nodes
+type Direction int
+
+const (
+	FORWARD Direction = iota
+	BACKWARD
+)
+
+func (d Direction) String() string {
+	if d == BACKWARD {
+		return "backward"
+	}
+	return "forward"
+}
+
+func (d Direction) Reverse() Direction {
+	if d == BACKWARD {
+		return FORWARD
+	}
+	return BACKWARD
+}
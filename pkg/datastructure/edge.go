@@ -6,11 +6,17 @@ type Edge struct {
 	fromLat, fromLon float64
 	toLat, toLon     float64
 	edgeId           uint32
+	fromNodeId       uint32
+	toNodeId         uint32
 	bidirectional    bool
+	direction        Direction // travel direction from the from node to the to node, relative to the osm way node order
 	osmWayId         int64
 	highwayType      int
 	speed            float64
 	street           int
+	length           float64      // in km
+	geometry         []Coordinate // all osm nodes of the edge, from the from node to the to node
+	wayOffset        float64      // in km, distance along the osm way from the first node of the way to the edge (in the osm way node order)
 }
 
 func (e *Edge) GetFromLonLat() (float64, float64) {
@@ -18,7 +24,66 @@ func (e *Edge) GetFromLonLat() (float64, float64) {
 }
 
 func (e *Edge) GetToLonLat() (float64, float64) {
-	return e.fromLon, e.fromLat
+	return e.toLon, e.toLat
+}
+
+func (e *Edge) GetEdgeId() uint32 {
+	return e.edgeId
+}
+
+func (e *Edge) GetFromNodeId() uint32 {
+	return e.fromNodeId
+}
+
+func (e *Edge) GetToNodeId() uint32 {
+	return e.toNodeId
+}
+
+// GetLength. edge length in km
+func (e *Edge) GetLength() float64 {
+	return e.length
+}
+
+// GetGeometry. all osm node coordinates of the edge, from the from node to the to node
+func (e *Edge) GetGeometry() []Coordinate {
+	return e.geometry
+}
+
+// GetPolyline. edge geometry as a list of {lon, lat}
+func (e *Edge) GetPolyline() [][2]float64 {
+	if len(e.geometry) == 0 {
+		return [][2]float64{{e.fromLon, e.fromLat}, {e.toLon, e.toLat}}
+	}
+	polyline := make([][2]float64, len(e.geometry))
+	for i, coord := range e.geometry {
+		polyline[i][0], polyline[i][1] = coord.GetLonLat()
+	}
+	return polyline
+}
+
+// GetWayOffset. distance (km) along the osm way from the first node of the way to the edge, in the osm way node order
+// (for a backward edge this is the offset of its to node)
+func (e *Edge) GetWayOffset() float64 {
+	return e.wayOffset
+}
+
+// WayPosition. distance (km) along the osm way from the first node of the way to the point at fraction of the edge,
+// fraction is measured in the travel direction
+func (e *Edge) WayPosition(fraction float64, travelDirection Direction) float64 {
+	if travelDirection == BACKWARD {
+		fraction = 1 - fraction
+	}
+	return e.wayOffset + fraction*e.length
+}
+
+// IsBidirectional. bidirectional edge can also be traversed from the to node to the from node
+func (e *Edge) IsBidirectional() bool {
+	return e.bidirectional
+}
+
+// GetDirection. travel direction (relative to the osm way node order) when traversing the edge from the from node to the to node
+func (e *Edge) GetDirection() Direction {
+	return e.direction
 }
 
 func (e *Edge) GetOsmWayId() int64 {
@@ -41,8 +106,9 @@ func (e *Edge) GetStreet() int {
 	return e.street
 }
 
-func NewEdge(fromLat, fromLon, toLat, toLon float64, edgeId uint32, bidirectional bool, osmWayId int64, highwayType string,
-	speed float64, street int) Edge {
+func NewEdge(fromLat, fromLon, toLat, toLon float64, edgeId, fromNodeId, toNodeId uint32, bidirectional bool,
+	direction Direction, osmWayId int64, highwayType string, speed float64, street int, length float64,
+	geometry []Coordinate, wayOffset float64) Edge {
 	var highwayTypeInt = INVALID_HIGHWAY_TYPE
 	switch highwayType {
 	case "motorway":
@@ -73,10 +139,16 @@ func NewEdge(fromLat, fromLon, toLat, toLon float64, edgeId uint32, bidirectiona
 		toLat:         toLat,
 		toLon:         toLon,
 		edgeId:        edgeId,
+		fromNodeId:    fromNodeId,
+		toNodeId:      toNodeId,
 		bidirectional: bidirectional,
+		direction:     direction,
 		osmWayId:      osmWayId,
 		highwayType:   highwayTypeInt,
 		speed:         speed,
 		street:        street,
+		length:        length,
+		geometry:      geometry,
+		wayOffset:     wayOffset,
 	}
 }
@@ -0,0 +1,81 @@
+package datastructure
+
+// Arc. traversal of an edge, a reversed arc traverses a bidirectional edge from its to node to its from node
+type Arc struct {
+	edgeId   uint32
+	reversed bool
+}
+
+func NewArc(edgeId uint32, reversed bool) Arc {
+	return Arc{edgeId: edgeId, reversed: reversed}
+}
+
+func (a Arc) GetEdgeId() uint32 {
+	return a.edgeId
+}
+
+func (a Arc) IsReversed() bool {
+	return a.reversed
+}
+
+// Graph. directed road graph, node ids are the dense node ids assigned by the osm parser
+type Graph struct {
+	edges   []Edge
+	outArcs [][]Arc // node id -> arcs leaving the node
+}
+
+func NewGraph(edges []Edge, numberOfNodes int) *Graph {
+	outArcs := make([][]Arc, numberOfNodes)
+	for _, edge := range edges {
+		from, to := edge.GetFromNodeId(), edge.GetToNodeId()
+		for int(max(from, to)) >= len(outArcs) {
+			outArcs = append(outArcs, nil)
+		}
+		outArcs[from] = append(outArcs[from], NewArc(edge.GetEdgeId(), false))
+		if edge.IsBidirectional() {
+			outArcs[to] = append(outArcs[to], NewArc(edge.GetEdgeId(), true))
+		}
+	}
+	return &Graph{
+		edges:   edges,
+		outArcs: outArcs,
+	}
+}
+
+func (g *Graph) GetEdge(edgeId uint32) Edge {
+	return g.edges[edgeId]
+}
+
+// GetOutgoingArcs. arcs that can be traversed from nodeId
+func (g *Graph) GetOutgoingArcs(nodeId uint32) []Arc {
+	if int(nodeId) >= len(g.outArcs) {
+		return nil
+	}
+	return g.outArcs[nodeId]
+}
+
+// GetArcHead. node reached after traversing the arc
+func (g *Graph) GetArcHead(arc Arc) uint32 {
+	edge := g.edges[arc.GetEdgeId()]
+	if arc.IsReversed() {
+		return edge.GetFromNodeId()
+	}
+	return edge.GetToNodeId()
+}
+
+// GetArcTail. node where the arc starts
+func (g *Graph) GetArcTail(arc Arc) uint32 {
+	edge := g.edges[arc.GetEdgeId()]
+	if arc.IsReversed() {
+		return edge.GetToNodeId()
+	}
+	return edge.GetFromNodeId()
+}
+
+func (g *Graph) NumberOfNodes() int {
+	return len(g.outArcs)
+}
+
+func (g *Graph) NumberOfEdges() int {
+	return len(g.edges)
+}
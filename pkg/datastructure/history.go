@@ -0,0 +1,78 @@
+package datastructure
+
+import "time"
+
+// SpeedObservation. speed of an osm way (in one travel direction) at one stored scrape
+type SpeedObservation struct {
+	timestamp time.Time
+	speed     float64 // jam speed, or the free flow speed if the way was not jammed. 0 for a missing scrape
+	jammed    bool
+	source    string // waze, stale (repeated waze response) or missing (failed scrape)
+}
+
+func NewSpeedObservation(timestamp time.Time, speed float64, jammed bool, source string) SpeedObservation {
+	return SpeedObservation{
+		timestamp: timestamp,
+		speed:     speed,
+		jammed:    jammed,
+		source:    source,
+	}
+}
+
+func (o SpeedObservation) GetTimestamp() time.Time {
+	return o.timestamp
+}
+
+func (o SpeedObservation) GetSpeed() float64 {
+	return o.speed
+}
+
+func (o SpeedObservation) IsJammed() bool {
+	return o.jammed
+}
+
+func (o SpeedObservation) GetSource() string {
+	return o.source
+}
+
+// HourOfWeekStats. speed statistics (km/h) of an osm way in one hour of the week, hour of week 0 is monday 00:00-01:00
+type HourOfWeekStats struct {
+	hourOfWeek int
+	count      int
+	mean       float64
+	p15        float64
+	p85        float64
+}
+
+func NewHourOfWeekStats(hourOfWeek, count int, mean, p15, p85 float64) HourOfWeekStats {
+	return HourOfWeekStats{
+		hourOfWeek: hourOfWeek,
+		count:      count,
+		mean:       mean,
+		p15:        p15,
+		p85:        p85,
+	}
+}
+
+func (s HourOfWeekStats) GetHourOfWeek() int {
+	return s.hourOfWeek
+}
+
+// GetCount. number of scrapes in the hour of week
+func (s HourOfWeekStats) GetCount() int {
+	return s.count
+}
+
+func (s HourOfWeekStats) GetMean() float64 {
+	return s.mean
+}
+
+// GetP15. 15th percentile speed
+func (s HourOfWeekStats) GetP15() float64 {
+	return s.p15
+}
+
+// GetP85. 85th percentile speed
+func (s HourOfWeekStats) GetP85() float64 {
+	return s.p85
+}
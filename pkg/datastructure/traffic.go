@@ -1,21 +1,105 @@
 package datastructure
 
+import "time"
+
 type WayTraffic struct {
-	way   Way
-	speed float64
+	way           Way
+	direction     Direction
+	speed         float64
+	freeFlowSpeed float64 // 0 if unknown
+	street        string  // waze street name
+	city          string  // waze city
+	osmStreet     string  // osm street name of the way
+	ranges        []WayRange
 }
 
-func NewWayTraffic(way Way, speed float64) WayTraffic {
+func NewWayTraffic(way Way, direction Direction, speed, freeFlowSpeed float64, street, city, osmStreet string,
+	ranges []WayRange) WayTraffic {
 	return WayTraffic{
-		way:   way,
-		speed: speed,
+		way:           way,
+		direction:     direction,
+		speed:         speed,
+		freeFlowSpeed: freeFlowSpeed,
+		street:        street,
+		city:          city,
+		osmStreet:     osmStreet,
+		ranges:        ranges,
 	}
 }
 
-func (wt WayTraffic) GetWay() Way{
+func (wt WayTraffic) GetWay() Way {
 	return wt.way
 }
 
+// GetDirection. travel direction (relative to the osm way node order) of the traffic
+func (wt WayTraffic) GetDirection() Direction {
+	return wt.direction
+}
+
 func (wt WayTraffic) GetSpeed() float64 {
 	return wt.speed
-}
\ No newline at end of file
+}
+
+// GetFreeFlowSpeed. default speed of the osm way, false if unknown
+func (wt WayTraffic) GetFreeFlowSpeed() (float64, bool) {
+	return wt.freeFlowSpeed, wt.freeFlowSpeed > 0
+}
+
+// GetCongestionRatio. 1 - speed / free flow speed, clamped to [0, 1]. false if the free flow speed is unknown
+func (wt WayTraffic) GetCongestionRatio() (float64, bool) {
+	if wt.freeFlowSpeed <= 0 {
+		return 0, false
+	}
+	return min(max(1-wt.speed/wt.freeFlowSpeed, 0), 1), true
+}
+
+func (wt WayTraffic) GetStreet() string {
+	return wt.street
+}
+
+func (wt WayTraffic) GetCity() string {
+	return wt.city
+}
+
+func (wt WayTraffic) GetOsmStreet() string {
+	return wt.osmStreet
+}
+
+// GetRanges. consecutive affected and unaffected parts of the way, covering the whole way
+func (wt WayTraffic) GetRanges() []WayRange {
+	return wt.ranges
+}
+
+// TrafficSnapshot. matched traffic of one scrape
+type TrafficSnapshot struct {
+	timestamp time.Time
+	sequence  uint64 // number of the snapshot in the scrape loop, 0 if the snapshot was not published
+	traffic   []WayTraffic
+}
+
+func NewTrafficSnapshot(timestamp time.Time, traffic []WayTraffic) TrafficSnapshot {
+	return TrafficSnapshot{
+		timestamp: timestamp,
+		traffic:   traffic,
+	}
+}
+
+// GetTimestamp. time of the scrape
+func (ts TrafficSnapshot) GetTimestamp() time.Time {
+	return ts.timestamp
+}
+
+func (ts TrafficSnapshot) GetTraffic() []WayTraffic {
+	return ts.traffic
+}
+
+// WithSequence. copy of the snapshot with the publish sequence number, the sequence identifies the snapshot even if two
+// scrapes have the same timestamp
+func (ts TrafficSnapshot) WithSequence(sequence uint64) TrafficSnapshot {
+	ts.sequence = sequence
+	return ts
+}
+
+func (ts TrafficSnapshot) GetSequence() uint64 {
+	return ts.sequence
+}
@@ -0,0 +1,135 @@
+package datastructure
+
+import (
+	"slices"
+	"time"
+)
+
+// TrafficDiff. change between two consecutive traffic snapshots of a region, applying the diff to the previous snapshot
+// (and closures) gives the current one
+type TrafficDiff struct {
+	timestamp       time.Time
+	sequence        uint64
+	changed         []WayTraffic  // newly jammed ways & jammed ways whose speed (or ranges) changed
+	cleared         []WayTraffic  // ways that are no longer jammed, with their last traffic
+	closuresAdded   []RoadClosure // new & updated closures
+	closuresCleared []RoadClosure // closures that are no longer active
+}
+
+type wayDirection struct {
+	wayId     int64
+	direction Direction
+}
+
+type closureKey struct {
+	jamUUID int64
+	way     wayDirection
+}
+
+func newClosureKey(closure RoadClosure) closureKey {
+	return closureKey{
+		jamUUID: closure.GetJamUUID(),
+		way:     wayDirection{wayId: closure.GetWay().GetID(), direction: closure.GetDirection()},
+	}
+}
+
+// NewTrafficDiff. diff from prev to curr, prevClosures & currClosures are the active closures at the time of prev and
+// curr
+func NewTrafficDiff(prev, curr TrafficSnapshot, prevClosures, currClosures []RoadClosure) TrafficDiff {
+	diff := TrafficDiff{
+		timestamp:       curr.GetTimestamp(),
+		sequence:        curr.GetSequence(),
+		changed:         make([]WayTraffic, 0),
+		cleared:         make([]WayTraffic, 0),
+		closuresAdded:   make([]RoadClosure, 0),
+		closuresCleared: make([]RoadClosure, 0),
+	}
+
+	prevTraffic := make(map[wayDirection]WayTraffic, len(prev.GetTraffic()))
+	for _, wt := range prev.GetTraffic() {
+		prevTraffic[wayDirection{wayId: wt.GetWay().GetID(), direction: wt.GetDirection()}] = wt
+	}
+	for _, wt := range curr.GetTraffic() {
+		key := wayDirection{wayId: wt.GetWay().GetID(), direction: wt.GetDirection()}
+		old, ok := prevTraffic[key]
+		delete(prevTraffic, key)
+		if ok && old.GetSpeed() == wt.GetSpeed() && slices.Equal(old.GetRanges(), wt.GetRanges()) {
+			continue
+		}
+		diff.changed = append(diff.changed, wt)
+	}
+	// keep the snapshot order of the cleared ways
+	for _, wt := range prev.GetTraffic() {
+		if _, ok := prevTraffic[wayDirection{wayId: wt.GetWay().GetID(), direction: wt.GetDirection()}]; ok {
+			diff.cleared = append(diff.cleared, wt)
+		}
+	}
+
+	prevClosureSet := make(map[closureKey]RoadClosure, len(prevClosures))
+	for _, closure := range prevClosures {
+		prevClosureSet[newClosureKey(closure)] = closure
+	}
+	currClosureSet := make(map[closureKey]struct{}, len(currClosures))
+	for _, closure := range currClosures {
+		key := newClosureKey(closure)
+		currClosureSet[key] = struct{}{}
+		if old, ok := prevClosureSet[key]; ok && old.GetLastUpdate().Equal(closure.GetLastUpdate()) {
+			continue
+		}
+		diff.closuresAdded = append(diff.closuresAdded, closure)
+	}
+	for _, closure := range prevClosures {
+		if _, ok := currClosureSet[newClosureKey(closure)]; !ok {
+			diff.closuresCleared = append(diff.closuresCleared, closure)
+		}
+	}
+	return diff
+}
+
+// Filter. diff restricted to the osm ways for which keep is true
+func (d TrafficDiff) Filter(keep func(wayId int64) bool) TrafficDiff {
+	filterTraffic := func(traffic []WayTraffic) []WayTraffic {
+		return slices.DeleteFunc(slices.Clone(traffic), func(wt WayTraffic) bool { return !keep(wt.GetWay().GetID()) })
+	}
+	filterClosures := func(closures []RoadClosure) []RoadClosure {
+		return slices.DeleteFunc(slices.Clone(closures), func(rc RoadClosure) bool { return !keep(rc.GetWay().GetID()) })
+	}
+	return TrafficDiff{
+		timestamp:       d.timestamp,
+		sequence:        d.sequence,
+		changed:         filterTraffic(d.changed),
+		cleared:         filterTraffic(d.cleared),
+		closuresAdded:   filterClosures(d.closuresAdded),
+		closuresCleared: filterClosures(d.closuresCleared),
+	}
+}
+
+// GetTimestamp. time of the scrape of the current snapshot
+func (d TrafficDiff) GetTimestamp() time.Time {
+	return d.timestamp
+}
+
+// GetSequence. sequence of the current snapshot
+func (d TrafficDiff) GetSequence() uint64 {
+	return d.sequence
+}
+
+func (d TrafficDiff) GetChanged() []WayTraffic {
+	return d.changed
+}
+
+func (d TrafficDiff) GetCleared() []WayTraffic {
+	return d.cleared
+}
+
+func (d TrafficDiff) GetClosuresAdded() []RoadClosure {
+	return d.closuresAdded
+}
+
+func (d TrafficDiff) GetClosuresCleared() []RoadClosure {
+	return d.closuresCleared
+}
+
+func (d TrafficDiff) IsEmpty() bool {
+	return len(d.changed) == 0 && len(d.cleared) == 0 && len(d.closuresAdded) == 0 && len(d.closuresCleared) == 0
+}
@@ -0,0 +1,66 @@
+package datastructure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func diffWayIds(traffic []WayTraffic) []int64 {
+	ids := make([]int64, 0, len(traffic))
+	for _, wt := range traffic {
+		ids = append(ids, wt.GetWay().GetID())
+	}
+	return ids
+}
+
+func TestNewTrafficDiff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	wayTraffic := func(id int64, direction Direction, speed float64) WayTraffic {
+		return NewWayTraffic(NewWay(id, nil), direction, speed, 50, "", "", "", nil)
+	}
+	closure := func(jamUUID, wayId int64, lastUpdate time.Time) RoadClosure {
+		return NewRoadClosure(jamUUID, NewWay(wayId, nil), FORWARD, "ROAD_CLOSED", "", "", "", time.Time{}, time.Time{},
+			lastUpdate)
+	}
+
+	prev := NewTrafficSnapshot(start, []WayTraffic{
+		wayTraffic(1, FORWARD, 10),
+		wayTraffic(2, FORWARD, 20),
+		wayTraffic(3, FORWARD, 30),
+	}).WithSequence(1)
+	curr := NewTrafficSnapshot(start.Add(time.Minute), []WayTraffic{
+		wayTraffic(1, FORWARD, 10),  // unchanged
+		wayTraffic(2, FORWARD, 25),  // speed changed
+		wayTraffic(2, BACKWARD, 20), // new jam in the other direction
+		wayTraffic(4, FORWARD, 15),  // new jam
+	}).WithSequence(2)
+	prevClosures := []RoadClosure{closure(100, 5, start), closure(101, 6, start), closure(102, 7, start)}
+	currClosures := []RoadClosure{closure(100, 5, start), closure(101, 6, start.Add(time.Minute)), closure(103, 8, start)}
+
+	diff := NewTrafficDiff(prev, curr, prevClosures, currClosures)
+	assert.Equal(t, curr.GetTimestamp(), diff.GetTimestamp())
+	assert.Equal(t, uint64(2), diff.GetSequence())
+	assert.Equal(t, []int64{2, 2, 4}, diffWayIds(diff.GetChanged()))
+	assert.Equal(t, BACKWARD, diff.GetChanged()[1].GetDirection())
+	assert.Equal(t, []int64{3}, diffWayIds(diff.GetCleared()))
+	// updated & new closures
+	assert.Equal(t, []RoadClosure{currClosures[1], currClosures[2]}, diff.GetClosuresAdded())
+	assert.Equal(t, []RoadClosure{prevClosures[2]}, diff.GetClosuresCleared())
+
+	// from an empty state every way & closure is new
+	full := NewTrafficDiff(TrafficSnapshot{}, curr, nil, currClosures)
+	assert.Equal(t, []int64{1, 2, 2, 4}, diffWayIds(full.GetChanged()))
+	assert.Empty(t, full.GetCleared())
+	assert.Equal(t, currClosures, full.GetClosuresAdded())
+
+	filtered := diff.Filter(func(wayId int64) bool { return wayId == 3 || wayId == 7 })
+	assert.Empty(t, filtered.GetChanged())
+	assert.Equal(t, []int64{3}, diffWayIds(filtered.GetCleared()))
+	assert.Empty(t, filtered.GetClosuresAdded())
+	assert.Equal(t, []RoadClosure{prevClosures[2]}, filtered.GetClosuresCleared())
+	assert.Equal(t, []int64{2, 2, 4}, diffWayIds(diff.GetChanged()), "filter must not modify the diff")
+
+	assert.True(t, NewTrafficDiff(curr, curr, currClosures, currClosures).IsEmpty())
+}
@@ -0,0 +1,79 @@
+package datastructure
+
+// TrafficFilter. subset of the traffic of a snapshot, an empty filter field doesn't filter
+type TrafficFilter struct {
+	boundingBox    BoundingBox
+	hasBoundingBox bool
+	wayIds         map[int64]struct{}
+	highways       map[string]struct{}
+	minCongestion  float64 // minimum congestion ratio, 1 - speed / free flow speed
+}
+
+func NewTrafficFilter() TrafficFilter {
+	return TrafficFilter{}
+}
+
+// WithBoundingBox. only the ways with an edge inside the bounding box
+func (f TrafficFilter) WithBoundingBox(bb BoundingBox) TrafficFilter {
+	f.boundingBox, f.hasBoundingBox = bb, true
+	return f
+}
+
+// WithWayIds. only the osm ways in wayIds
+func (f TrafficFilter) WithWayIds(wayIds []int64) TrafficFilter {
+	if len(wayIds) == 0 {
+		return f
+	}
+	f.wayIds = make(map[int64]struct{}, len(wayIds))
+	for _, id := range wayIds {
+		f.wayIds[id] = struct{}{}
+	}
+	return f
+}
+
+// WithHighways. only the ways of the highway classes (e.g. primary, see Edge.GetHighwayTypeString)
+func (f TrafficFilter) WithHighways(highways []string) TrafficFilter {
+	if len(highways) == 0 {
+		return f
+	}
+	f.highways = make(map[string]struct{}, len(highways))
+	for _, highway := range highways {
+		f.highways[highway] = struct{}{}
+	}
+	return f
+}
+
+// WithMinCongestion. only the traffic with congestion ratio (1 - speed / free flow speed) >= minCongestion
+func (f TrafficFilter) WithMinCongestion(minCongestion float64) TrafficFilter {
+	f.minCongestion = minCongestion
+	return f
+}
+
+func (f TrafficFilter) GetBoundingBox() (BoundingBox, bool) {
+	return f.boundingBox, f.hasBoundingBox
+}
+
+func (f TrafficFilter) HasWayId(wayId int64) bool {
+	if f.wayIds == nil {
+		return true
+	}
+	_, ok := f.wayIds[wayId]
+	return ok
+}
+
+func (f TrafficFilter) HasHighway(highway string) bool {
+	if f.highways == nil {
+		return true
+	}
+	_, ok := f.highways[highway]
+	return ok
+}
+
+func (f TrafficFilter) GetMinCongestion() float64 {
+	return f.minCongestion
+}
+
+// IsEmpty. true if the filter keeps every traffic
+func (f TrafficFilter) IsEmpty() bool {
+	return !f.hasBoundingBox && f.wayIds == nil && f.highways == nil && f.minCongestion <= 0
+}
@@ -0,0 +1,87 @@
+package datastructure
+
+import "sort"
+
+// WayRange. part of an osm way between two offsets (m) measured along the way from the first node of the way
+// (in the osm way node order) and its speed
+type WayRange struct {
+	start    float64
+	end      float64
+	speed    float64
+	affected bool // covered by a waze jam
+}
+
+func NewWayRange(start, end, speed float64, affected bool) WayRange {
+	return WayRange{
+		start:    start,
+		end:      end,
+		speed:    speed,
+		affected: affected,
+	}
+}
+
+// GetStart. offset (m) of the start of the range from the first node of the way
+func (wr WayRange) GetStart() float64 {
+	return wr.start
+}
+
+// GetEnd. offset (m) of the end of the range from the first node of the way
+func (wr WayRange) GetEnd() float64 {
+	return wr.end
+}
+
+func (wr WayRange) GetSpeed() float64 {
+	return wr.speed
+}
+
+// IsAffected. true if the range is covered by a waze jam
+func (wr WayRange) IsAffected() bool {
+	return wr.affected
+}
+
+// SplitWayRanges. split the whole way [0, wayLength] (m) into consecutive affected and unaffected sub ranges.
+// where affected ranges overlap the slowest speed is used, unaffected ranges get freeFlowSpeed
+func SplitWayRanges(affected []WayRange, wayLength, freeFlowSpeed float64) []WayRange {
+	breakpoints := []float64{0, wayLength}
+	for _, wr := range affected {
+		breakpoints = append(breakpoints, clamp(wr.start, 0, wayLength), clamp(wr.end, 0, wayLength))
+	}
+	sort.Float64s(breakpoints)
+
+	result := make([]WayRange, 0, len(breakpoints))
+	for i := 0; i < len(breakpoints)-1; i++ {
+		start, end := breakpoints[i], breakpoints[i+1]
+		if end-start <= 0 {
+			continue
+		}
+
+		mid := (start + end) / 2
+		current := NewWayRange(start, end, freeFlowSpeed, false)
+		for _, wr := range affected {
+			if wr.start > mid || wr.end < mid {
+				continue
+			}
+			if !current.affected || wr.speed < current.speed {
+				current.speed = wr.speed
+			}
+			current.affected = true
+		}
+
+		if n := len(result); n > 0 && result[n-1].affected == current.affected && result[n-1].speed == current.speed {
+			result[n-1].end = end
+			continue
+		}
+		result = append(result, current)
+	}
+	return result
+}
+
+func clamp(x, lo, hi float64) float64 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
@@ -0,0 +1,26 @@
+package datastructure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitWayRanges(t *testing.T) {
+	ranges := SplitWayRanges([]WayRange{
+		NewWayRange(200, 400, 20, true),
+		NewWayRange(300, 600, 10, true),
+		NewWayRange(2900, 3500, 15, true), // beyond the end of the way
+	}, 3000, 50)
+
+	expected := []WayRange{
+		NewWayRange(0, 200, 50, false),
+		NewWayRange(200, 300, 20, true),
+		NewWayRange(300, 600, 10, true),
+		NewWayRange(600, 2900, 50, false),
+		NewWayRange(2900, 3000, 15, true),
+	}
+	assert.Equal(t, expected, ranges)
+
+	assert.Equal(t, []WayRange{NewWayRange(0, 3000, 50, false)}, SplitWayRanges(nil, 3000, 50))
+}
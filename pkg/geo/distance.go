@@ -92,3 +92,121 @@ func MidPoint(longOne, latOne, longTwo, latTwo float64) (float64, float64) {
 func normalizeLongitude(long float64) float64 {
 	return math.Mod((long+540), 360) - 180.0
 }
+
+// InitialBearing returns the initial bearing (in degrees, 0-360) of the great circle path from point one to point two
+// https://www.movable-type.co.uk/scripts/latlong.html
+func InitialBearing(longOne, latOne, longTwo, latTwo float64) float64 {
+	latOne = degreeToRadians(latOne)
+	longOne = degreeToRadians(longOne)
+	latTwo = degreeToRadians(latTwo)
+	longTwo = degreeToRadians(longTwo)
+
+	y := math.Sin(longTwo-longOne) * math.Cos(latTwo)
+	x := math.Cos(latOne)*math.Sin(latTwo) - math.Sin(latOne)*math.Cos(latTwo)*math.Cos(longTwo-longOne)
+	return math.Mod(radToDeg(math.Atan2(y, x))+360, 360)
+}
+
+// BearingDifference returns the smallest angle (in degrees, 0-180) between two bearings
+func BearingDifference(bearingOne, bearingTwo float64) float64 {
+	diff := math.Abs(math.Mod(bearingOne-bearingTwo, 360))
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return diff
+}
+
+// ProjectPointToSegment projects the point (long, lat) onto the segment from point one to point two, using an
+// equirectangular approximation around the query point (good enough for road segments).
+// returns the distance (in km) from the query point to its projection, the fraction (0-1) of the projection along the
+// segment and the projected point.
+func ProjectPointToSegment(long, lat, longOne, latOne, longTwo, latTwo float64) (float64, float64, float64, float64) {
+	cosLat := math.Cos(degreeToRadians(lat))
+
+	// local planar coordinates (in degree of latitude) with the query point as origin
+	ax, ay := (longOne-long)*cosLat, latOne-lat
+	bx, by := (longTwo-long)*cosLat, latTwo-lat
+
+	dx, dy := bx-ax, by-ay
+	segLenSq := dx*dx + dy*dy
+	fraction := 0.0
+	if segLenSq > 0 {
+		fraction = -(ax*dx + ay*dy) / segLenSq
+		fraction = math.Max(0, math.Min(1, fraction))
+	}
+
+	projLong := longOne + fraction*(longTwo-longOne)
+	projLat := latOne + fraction*(latTwo-latOne)
+	return CalculateHaversineDistance(long, lat, projLong, projLat), fraction, projLong, projLat
+}
+
+// PointToPolyline returns the perpendicular (cross track) distance (in km) from the point (long, lat) to the polyline
+// (list of {long, lat}), the fraction (0-1, by length) of the projection along the polyline, the projected point and
+// the index of the polyline segment that contains the projection.
+func PointToPolyline(long, lat float64, polyline [][2]float64) (float64, float64, float64, float64, int) {
+	if len(polyline) == 1 {
+		return CalculateHaversineDistance(long, lat, polyline[0][0], polyline[0][1]), 0, polyline[0][0], polyline[0][1], 0
+	}
+
+	bestDist := math.MaxFloat64
+	bestSegment := 0
+	bestSegmentFraction := 0.0
+	bestLong, bestLat := 0.0, 0.0
+	totalLength := 0.0
+	lengthBefore := 0.0
+	bestLengthBefore := 0.0
+	for i := 0; i < len(polyline)-1; i++ {
+		dist, fraction, projLong, projLat := ProjectPointToSegment(long, lat, polyline[i][0], polyline[i][1],
+			polyline[i+1][0], polyline[i+1][1])
+		segmentLength := CalculateHaversineDistance(polyline[i][0], polyline[i][1], polyline[i+1][0], polyline[i+1][1])
+		if dist < bestDist {
+			bestDist = dist
+			bestSegment = i
+			bestSegmentFraction = fraction
+			bestLong, bestLat = projLong, projLat
+			bestLengthBefore = lengthBefore
+		}
+		lengthBefore += segmentLength
+		totalLength += segmentLength
+	}
+
+	fraction := 0.0
+	if totalLength > 0 {
+		bestSegmentLength := CalculateHaversineDistance(polyline[bestSegment][0], polyline[bestSegment][1],
+			polyline[bestSegment+1][0], polyline[bestSegment+1][1])
+		fraction = (bestLengthBefore + bestSegmentFraction*bestSegmentLength) / totalLength
+	}
+	return bestDist, fraction, bestLong, bestLat, bestSegment
+}
+
+// PolylineLength returns the length (in km) of the polyline (list of {long, lat})
+func PolylineLength(polyline [][2]float64) float64 {
+	length := 0.0
+	for i := 0; i < len(polyline)-1; i++ {
+		length += CalculateHaversineDistance(polyline[i][0], polyline[i][1], polyline[i+1][0], polyline[i+1][1])
+	}
+	return length
+}
+
+// SubPolyline returns the part of the polyline (list of {long, lat}) between the start and end distance (in km) from its
+// first point, the cut points are interpolated linearly on their segments
+func SubPolyline(polyline [][2]float64, start, end float64) [][2]float64 {
+	sub := make([][2]float64, 0)
+	lengthBefore := 0.0
+	for i := 0; i < len(polyline)-1; i++ {
+		segmentLength := CalculateHaversineDistance(polyline[i][0], polyline[i][1], polyline[i+1][0], polyline[i+1][1])
+		lengthAfter := lengthBefore + segmentLength
+		if lengthAfter > start && lengthBefore < end && segmentLength > 0 {
+			interpolate := func(dist float64) [2]float64 {
+				fraction := min(max((dist-lengthBefore)/segmentLength, 0), 1)
+				return [2]float64{polyline[i][0] + fraction*(polyline[i+1][0]-polyline[i][0]),
+					polyline[i][1] + fraction*(polyline[i+1][1]-polyline[i][1])}
+			}
+			if len(sub) == 0 {
+				sub = append(sub, interpolate(start))
+			}
+			sub = append(sub, interpolate(end))
+		}
+		lengthBefore = lengthAfter
+	}
+	return sub
+}
@@ -0,0 +1,58 @@
+package geo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectPointToSegment(t *testing.T) {
+	// ~111 meters north of the middle of a west-east segment
+	dist, fraction, projLong, projLat := ProjectPointToSegment(110.365, -7.779, 110.36, -7.78, 110.37, -7.78)
+	assert.InDelta(t, 0.1112, dist, 0.001)
+	assert.InDelta(t, 0.5, fraction, 1e-6)
+	assert.InDelta(t, 110.365, projLong, 1e-9)
+	assert.InDelta(t, -7.78, projLat, 1e-9)
+
+	// beyond the end of the segment, the projection is clamped to the segment end
+	dist, fraction, _, _ = ProjectPointToSegment(110.38, -7.78, 110.36, -7.78, 110.37, -7.78)
+	assert.Equal(t, 1.0, fraction)
+	assert.InDelta(t, CalculateHaversineDistance(110.38, -7.78, 110.37, -7.78), dist, 1e-9)
+}
+
+func TestPointToPolyline(t *testing.T) {
+	// L shaped polyline, first segment west-east, second segment south-north with the same length
+	polyline := [][2]float64{{110.36, -7.78}, {110.37, -7.78}, {110.37, -7.77}}
+
+	// near the middle of the second segment
+	dist, fraction, projLong, projLat, segment := PointToPolyline(110.3701, -7.775, polyline)
+	assert.Equal(t, 1, segment)
+	assert.InDelta(t, 0.011, dist, 0.001)
+	assert.InDelta(t, 110.37, projLong, 1e-9)
+	assert.InDelta(t, -7.775, projLat, 1e-6)
+
+	length := PolylineLength(polyline)
+	firstSegmentLength := CalculateHaversineDistance(110.36, -7.78, 110.37, -7.78)
+	assert.InDelta(t, (firstSegmentLength+CalculateHaversineDistance(110.37, -7.78, 110.37, -7.775))/length, fraction, 1e-4)
+
+	// the midpoint of the polyline bounding box is far from the polyline, the cross track distance is not
+	dist, _, _, _, segment = PointToPolyline(110.3650, -7.7801, polyline)
+	assert.Equal(t, 0, segment)
+	assert.InDelta(t, 0.011, dist, 0.001)
+}
+
+func TestSubPolyline(t *testing.T) {
+	polyline := [][2]float64{{110.36, -7.78}, {110.37, -7.78}, {110.37, -7.77}}
+	firstSegmentLength := CalculateHaversineDistance(110.36, -7.78, 110.37, -7.78)
+
+	// from the middle of the first segment to the middle of the second one
+	sub := SubPolyline(polyline, firstSegmentLength/2, firstSegmentLength+PolylineLength(polyline[1:])/2)
+	assert.Equal(t, 3, len(sub))
+	assert.InDelta(t, 110.365, sub[0][0], 1e-9)
+	assert.Equal(t, [2]float64{110.37, -7.78}, sub[1])
+	assert.InDelta(t, -7.775, sub[2][1], 1e-9)
+
+	// a range starting at a node doesn't repeat the node, the end is clamped to the polyline
+	sub = SubPolyline(polyline, firstSegmentLength, PolylineLength(polyline)+1)
+	assert.Equal(t, [][2]float64{{110.37, -7.78}, {110.37, -7.77}}, sub)
+}
@@ -1,14 +1,31 @@
 package controllers
 
-import "github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+import (
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/region"
+)
 
 type trafficResponse struct {
-	Traffics []TrafficData `json:"traffics"`
+	Timestamp   time.Time     `json:"timestamp"`
+	PendingFrom *time.Time    `json:"pending_from,omitempty"` // historical traffic only, see waySpeedSeriesResponse
+	Traffics    []TrafficData `json:"traffics"`
 }
 
 type TrafficData struct {
-	Way   Way     `json:"way"`
-	Speed float64 `json:"speed"`
+	Way       Way            `json:"way"`
+	Direction string         `json:"direction"`
+	Speed     float64        `json:"speed"`
+	Ranges    []WayRangeData `json:"ranges"`
+}
+
+// WayRangeData. part of the way, offsets are in meters from the first node of the way
+type WayRangeData struct {
+	StartM   float64 `json:"start_m"`
+	EndM     float64 `json:"end_m"`
+	Speed    float64 `json:"speed"`
+	Affected bool    `json:"affected"`
 }
 
 type Way struct {
@@ -21,31 +38,127 @@ type Coordinate struct {
 	Lon float64 `json:"lon"`
 }
 
-func NewTrafficResponse(traffics []datastructure.WayTraffic) trafficResponse {
-	var response trafficResponse
-
-	for _, wt := range traffics {
-		way := wt.GetWay()
-		wayResp := Way{
-			Id:          way.GetID(),
-			Coordinates: []Coordinate{},
-		}
-		for _, coord := range way.GetCoordinates() {
-			lon, lat := coord.GetLonLat()
-			wayResp.Coordinates = append(wayResp.Coordinates, Coordinate{
-				Lon: lon,
-				Lat: lat,
-			})
-		}
-		response.Traffics = append(response.Traffics, TrafficData{
-			Way:   wayResp,
-			Speed: wt.GetSpeed(),
+func NewWay(way datastructure.Way) Way {
+	wayResp := Way{
+		Id:          way.GetID(),
+		Coordinates: []Coordinate{},
+	}
+	for _, coord := range way.GetCoordinates() {
+		lon, lat := coord.GetLonLat()
+		wayResp.Coordinates = append(wayResp.Coordinates, Coordinate{
+			Lon: lon,
+			Lat: lat,
 		})
 	}
+	return wayResp
+}
+
+func NewTrafficResponse(snapshot datastructure.TrafficSnapshot) trafficResponse {
+	response := trafficResponse{
+		Timestamp: snapshot.GetTimestamp(),
+		Traffics:  make([]TrafficData, 0, len(snapshot.GetTraffic())),
+	}
+
+	for _, wt := range snapshot.GetTraffic() {
+		response.Traffics = append(response.Traffics, newTrafficData(wt))
+	}
 
 	return response
 }
 
+func newTrafficData(wt datastructure.WayTraffic) TrafficData {
+	ranges := make([]WayRangeData, 0, len(wt.GetRanges()))
+	for _, wr := range wt.GetRanges() {
+		ranges = append(ranges, WayRangeData{
+			StartM:   wr.GetStart(),
+			EndM:     wr.GetEnd(),
+			Speed:    wr.GetSpeed(),
+			Affected: wr.IsAffected(),
+		})
+	}
+	return TrafficData{
+		Way:       NewWay(wt.GetWay()),
+		Direction: wt.GetDirection().String(),
+		Speed:     wt.GetSpeed(),
+		Ranges:    ranges,
+	}
+}
+
+type regionResponse struct {
+	Regions []RegionData `json:"regions"`
+}
+
+type RegionData struct {
+	Name        string     `json:"name"`
+	BoundingBox [4]float64 `json:"bbox"` // min lon, min lat, max lon, max lat
+}
+
+func NewRegionResponse(regions []*region.Region) regionResponse {
+	response := regionResponse{
+		Regions: make([]RegionData, 0, len(regions)),
+	}
+	for _, r := range regions {
+		minLon, minLat := r.GetBoundingBox().GetMin()
+		maxLon, maxLat := r.GetBoundingBox().GetMax()
+		response.Regions = append(response.Regions, RegionData{
+			Name:        r.GetName(),
+			BoundingBox: [4]float64{minLon, minLat, maxLon, maxLat},
+		})
+	}
+	return response
+}
+
+type closureResponse struct {
+	Closures []ClosureData `json:"closures"`
+}
+
+type ClosureData struct {
+	JamUUID     int64      `json:"jam_uuid"`
+	Way         Way        `json:"way"`
+	Direction   string     `json:"direction"`
+	BlockType   string     `json:"block_type"`
+	Description string     `json:"description"`
+	Street      string     `json:"street"`
+	City        string     `json:"city"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	Expiration  *time.Time `json:"expiration,omitempty"`
+	LastUpdate  *time.Time `json:"last_update,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func NewClosureResponse(closures []datastructure.RoadClosure) closureResponse {
+	response := closureResponse{
+		Closures: make([]ClosureData, 0, len(closures)),
+	}
+
+	for _, closure := range closures {
+		response.Closures = append(response.Closures, newClosureData(closure))
+	}
+
+	return response
+}
+
+func newClosureData(closure datastructure.RoadClosure) ClosureData {
+	return ClosureData{
+		JamUUID:     closure.GetJamUUID(),
+		Way:         NewWay(closure.GetWay()),
+		Direction:   closure.GetDirection().String(),
+		BlockType:   closure.GetBlockType(),
+		Description: closure.GetDescription(),
+		Street:      closure.GetStreet(),
+		City:        closure.GetCity(),
+		StartTime:   optionalTime(closure.GetStartTime()),
+		Expiration:  optionalTime(closure.GetExpiration()),
+		LastUpdate:  optionalTime(closure.GetLastUpdate()),
+	}
+}
+
 type errorResponse struct {
 	Error struct {
 		Code    string `json:"code"`
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// trafficFilterRequest. query parameters of the traffic endpoints
+type trafficFilterRequest struct {
+	BoundingBox   *boundingBoxRequest `query:"bbox" validate:"omitempty"`
+	WayIds        []int64             `query:"way_ids" validate:"max=1000,dive,gt=0"`
+	Highways      []string            `query:"highway" validate:"dive,oneof=motorway trunk primary secondary tertiary motorway_link trunk_link primary_link secondary_link tertiary_link"`
+	MinCongestion float64             `query:"min_congestion" validate:"gte=0,lte=1"` // 1 - speed / free flow speed
+}
+
+type boundingBoxRequest struct {
+	MinLon float64 `query:"min_lon" validate:"gte=-180,lte=180"`
+	MinLat float64 `query:"min_lat" validate:"gte=-90,lte=90"`
+	MaxLon float64 `query:"max_lon" validate:"gte=-180,lte=180,gtfield=MinLon"`
+	MaxLat float64 `query:"max_lat" validate:"gte=-90,lte=90,gtfield=MinLat"`
+}
+
+// parseTrafficFilter. parse & validate the filter query parameters, list parameters are comma separated
+func (api *wazeAPI) parseTrafficFilter(r *http.Request) (datastructure.TrafficFilter, error) {
+	query := r.URL.Query()
+	req := trafficFilterRequest{}
+
+	if bbox := query.Get("bbox"); bbox != "" {
+		coords, err := parseFloatList(bbox)
+		if err != nil || len(coords) != 4 {
+			return datastructure.TrafficFilter{}, errors.New("bbox must be min_lon,min_lat,max_lon,max_lat")
+		}
+		req.BoundingBox = &boundingBoxRequest{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+	}
+	if wayIds := query.Get("way_ids"); wayIds != "" {
+		for _, s := range splitList(wayIds) {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return datastructure.TrafficFilter{}, errors.New(fmt.Sprintf("invalid osm way id %q in way_ids", s))
+			}
+			req.WayIds = append(req.WayIds, id)
+		}
+	}
+	if highways := query.Get("highway"); highways != "" {
+		req.Highways = splitList(highways)
+	}
+	if minCongestion := query.Get("min_congestion"); minCongestion != "" {
+		ratio, err := strconv.ParseFloat(minCongestion, 64)
+		if err != nil {
+			return datastructure.TrafficFilter{}, errors.New("min_congestion must be a number between 0 and 1")
+		}
+		req.MinCongestion = ratio
+	}
+
+	if err := api.validate.Struct(req); err != nil {
+		return datastructure.TrafficFilter{}, errors.Join(translateError(err, api.trans)...)
+	}
+
+	filter := datastructure.NewTrafficFilter().
+		WithWayIds(req.WayIds).
+		WithHighways(req.Highways).
+		WithMinCongestion(req.MinCongestion)
+	if bb := req.BoundingBox; bb != nil {
+		filter = filter.WithBoundingBox(datastructure.NewBoundingBox(bb.MinLon, bb.MinLat, bb.MaxLon, bb.MaxLat))
+	}
+	return filter, nil
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseFloatList(s string) ([]float64, error) {
+	values := make([]float64, 0)
+	for _, item := range splitList(s) {
+		value, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
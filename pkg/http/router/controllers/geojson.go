@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"go.uber.org/zap"
+)
+
+const (
+	FORMAT_JSON    = "json"
+	FORMAT_GEOJSON = "geojson"
+
+	GEOJSON_CONTENT_TYPE = "application/geo+json"
+)
+
+// responseFormat. format of the response: the format query parameter (json or geojson), otherwise geojson if the
+// Accept header prefers (higher q, then first listed) application/geo+json over application/json
+func responseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case FORMAT_JSON, FORMAT_GEOJSON:
+		return format, nil
+	case "":
+	default:
+		return "", errors.New(fmt.Sprintf("format must be %s or %s", FORMAT_JSON, FORMAT_GEOJSON))
+	}
+
+	format, bestQuality := FORMAT_JSON, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || (mediaType != GEOJSON_CONTENT_TYPE && mediaType != "application/json") {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		if quality > bestQuality {
+			bestQuality = quality
+			format = FORMAT_JSON
+			if mediaType == GEOJSON_CONTENT_TYPE {
+				format = FORMAT_GEOJSON
+			}
+		}
+	}
+	return format, nil
+}
+
+// featureCollection. geojson FeatureCollection (RFC 7946), timestamp is a foreign member with the time of the scrape
+type featureCollection struct {
+	Type        string     `json:"type"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+	PendingFrom *time.Time `json:"pending_from,omitempty"` // historical traffic only, see waySpeedSeriesResponse
+	Features    []feature  `json:"features"`
+}
+
+type feature struct {
+	Type       string   `json:"type"`
+	Geometry   geometry `json:"geometry"`
+	Properties any      `json:"properties"`
+}
+
+type geometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// TrafficProperties. properties of a traffic feature, speeds are in km/h
+type TrafficProperties struct {
+	WayId           int64          `json:"way_id"`
+	Direction       string         `json:"direction"`
+	Speed           float64        `json:"speed"`
+	FreeFlowSpeed   *float64       `json:"free_flow_speed"`
+	CongestionRatio *float64       `json:"congestion_ratio"` // 1 - speed / free flow speed
+	StreetName      string         `json:"street_name"`      // osm street name
+	WazeStreet      string         `json:"waze_street"`
+	WazeCity        string         `json:"waze_city"`
+	Ranges          []WayRangeData `json:"ranges"`
+}
+
+// ClosureProperties. properties of a road closure feature
+type ClosureProperties struct {
+	JamUUID     int64      `json:"jam_uuid"`
+	WayId       int64      `json:"way_id"`
+	Direction   string     `json:"direction"`
+	BlockType   string     `json:"block_type"`
+	Description string     `json:"description"`
+	Street      string     `json:"street"`
+	City        string     `json:"city"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	Expiration  *time.Time `json:"expiration,omitempty"`
+	LastUpdate  *time.Time `json:"last_update,omitempty"`
+}
+
+// wayLineString. LineString of the way in the travel direction, the way coordinates are in the osm way node order
+func wayLineString(way datastructure.Way, direction datastructure.Direction) geometry {
+	coords := way.GetCoordinates()
+	line := make([][2]float64, len(coords))
+	for i, coord := range coords {
+		lon, lat := coord.GetLonLat()
+		if direction == datastructure.BACKWARD {
+			line[len(coords)-1-i] = [2]float64{lon, lat}
+		} else {
+			line[i] = [2]float64{lon, lat}
+		}
+	}
+	return geometry{Type: "LineString", Coordinates: line}
+}
+
+func optionalFloat(value float64, ok bool) *float64 {
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func NewTrafficFeatureCollection(snapshot datastructure.TrafficSnapshot) featureCollection {
+	timestamp := snapshot.GetTimestamp()
+	fc := featureCollection{
+		Type:      "FeatureCollection",
+		Timestamp: &timestamp,
+		Features:  make([]feature, 0, len(snapshot.GetTraffic())),
+	}
+	for _, wt := range snapshot.GetTraffic() {
+		ranges := make([]WayRangeData, 0, len(wt.GetRanges()))
+		for _, wr := range wt.GetRanges() {
+			ranges = append(ranges, WayRangeData{
+				StartM:   wr.GetStart(),
+				EndM:     wr.GetEnd(),
+				Speed:    wr.GetSpeed(),
+				Affected: wr.IsAffected(),
+			})
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:     "Feature",
+			Geometry: wayLineString(wt.GetWay(), wt.GetDirection()),
+			Properties: TrafficProperties{
+				WayId:           wt.GetWay().GetID(),
+				Direction:       wt.GetDirection().String(),
+				Speed:           wt.GetSpeed(),
+				FreeFlowSpeed:   optionalFloat(wt.GetFreeFlowSpeed()),
+				CongestionRatio: optionalFloat(wt.GetCongestionRatio()),
+				StreetName:      wt.GetOsmStreet(),
+				WazeStreet:      wt.GetStreet(),
+				WazeCity:        wt.GetCity(),
+				Ranges:          ranges,
+			},
+		})
+	}
+	return fc
+}
+
+func NewClosureFeatureCollection(closures []datastructure.RoadClosure) featureCollection {
+	fc := featureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]feature, 0, len(closures)),
+	}
+	for _, closure := range closures {
+		fc.Features = append(fc.Features, feature{
+			Type:     "Feature",
+			Geometry: wayLineString(closure.GetWay(), closure.GetDirection()),
+			Properties: ClosureProperties{
+				JamUUID:     closure.GetJamUUID(),
+				WayId:       closure.GetWay().GetID(),
+				Direction:   closure.GetDirection().String(),
+				BlockType:   closure.GetBlockType(),
+				Description: closure.GetDescription(),
+				Street:      closure.GetStreet(),
+				City:        closure.GetCity(),
+				StartTime:   optionalTime(closure.GetStartTime()),
+				Expiration:  optionalTime(closure.GetExpiration()),
+				LastUpdate:  optionalTime(closure.GetLastUpdate()),
+			},
+		})
+	}
+	return fc
+}
+
+// writeGeoJSON. geojson responses are not wrapped in the data envelope, map libraries consume the FeatureCollection
+// directly
+func (api *wazeAPI) writeGeoJSON(w http.ResponseWriter, status int, fc featureCollection, headers http.Header) error {
+	js, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", GEOJSON_CONTENT_TYPE)
+	w.WriteHeader(status)
+	if _, err := w.Write(js); err != nil {
+		api.log.Error("failed to write GeoJSON response", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+const (
+	MAX_HISTORY_RANGE        = 92 * 24 * time.Hour // longest from-to range of a history query, bounds the scanned scrapes
+	DEFAULT_SERIES_RANGE     = 24 * time.Hour
+	DEFAULT_STATS_RANGE      = 28 * 24 * time.Hour
+	DEFAULT_SNAPSHOT_MAX_AGE = 15 * time.Minute // oldest scrape before at returned by the historical traffic
+	MAX_SNAPSHOT_MAX_AGE     = 24 * time.Hour
+)
+
+// historyRangeRequest. query parameters of the way history endpoints, times are RFC3339
+type historyRangeRequest struct {
+	Direction string    `query:"direction" validate:"oneof=forward backward"`
+	From      time.Time `query:"from"`
+	To        time.Time `query:"to" validate:"gtfield=From"`
+}
+
+type historicalTrafficRequest struct {
+	At     time.Time     `query:"at" validate:"required"`
+	MaxAge time.Duration `query:"max_age" validate:"gt=0"`
+}
+
+// waySpeedSeriesResponse. pending_from is set if the scrapes from that time on are stored but not readable yet (the
+// parquet partition that is still being written, readable once the partition rolls over), they are missing from the
+// response
+type waySpeedSeriesResponse struct {
+	WayId        int64                  `json:"way_id"`
+	Direction    string                 `json:"direction"`
+	From         time.Time              `json:"from"`
+	To           time.Time              `json:"to"`
+	PendingFrom  *time.Time             `json:"pending_from,omitempty"`
+	Observations []SpeedObservationData `json:"observations"`
+}
+
+// SpeedObservationData. speed of the way at one scrape, the free flow speed if the way was not jammed
+type SpeedObservationData struct {
+	Timestamp time.Time `json:"timestamp"`
+	Speed     float64   `json:"speed"`
+	Jammed    bool      `json:"jammed"`
+	Source    string    `json:"source"` // waze, stale (repeated waze response) or missing (failed scrape, no speed)
+}
+
+type hourOfWeekStatsResponse struct {
+	WayId       int64                 `json:"way_id"`
+	Direction   string                `json:"direction"`
+	From        time.Time             `json:"from"`
+	To          time.Time             `json:"to"`
+	TimeZone    string                `json:"tz"`
+	PendingFrom *time.Time            `json:"pending_from,omitempty"` // see waySpeedSeriesResponse
+	Hours       []HourOfWeekStatsData `json:"hours"`
+}
+
+// HourOfWeekStatsData. speed statistics (km/h) of one hour of the week, hour_of_week 0 is monday 00:00-01:00
+type HourOfWeekStatsData struct {
+	HourOfWeek int     `json:"hour_of_week"`
+	Weekday    string  `json:"weekday"`
+	Hour       int     `json:"hour"`
+	Count      int     `json:"count"`
+	Mean       float64 `json:"mean"`
+	P15        float64 `json:"p15"`
+	P85        float64 `json:"p85"`
+}
+
+func NewWaySpeedSeriesResponse(wayId int64, req historyRangeRequest,
+	series []datastructure.SpeedObservation) waySpeedSeriesResponse {
+	response := waySpeedSeriesResponse{
+		WayId:        wayId,
+		Direction:    req.Direction,
+		From:         req.From,
+		To:           req.To,
+		Observations: make([]SpeedObservationData, 0, len(series)),
+	}
+	for _, o := range series {
+		response.Observations = append(response.Observations, SpeedObservationData{
+			Timestamp: o.GetTimestamp(),
+			Speed:     o.GetSpeed(),
+			Jammed:    o.IsJammed(),
+			Source:    o.GetSource(),
+		})
+	}
+	return response
+}
+
+func NewHourOfWeekStatsResponse(wayId int64, req historyRangeRequest, loc *time.Location,
+	stats []datastructure.HourOfWeekStats) hourOfWeekStatsResponse {
+	response := hourOfWeekStatsResponse{
+		WayId:     wayId,
+		Direction: req.Direction,
+		From:      req.From,
+		To:        req.To,
+		TimeZone:  loc.String(),
+		Hours:     make([]HourOfWeekStatsData, 0, len(stats)),
+	}
+	for _, s := range stats {
+		response.Hours = append(response.Hours, HourOfWeekStatsData{
+			HourOfWeek: s.GetHourOfWeek(),
+			Weekday:    strings.ToLower(time.Weekday((s.GetHourOfWeek()/24 + 1) % 7).String()),
+			Hour:       s.GetHourOfWeek() % 24,
+			Count:      s.GetCount(),
+			Mean:       s.GetMean(),
+			P15:        s.GetP15(),
+			P85:        s.GetP85(),
+		})
+	}
+	return response
+}
+
+// parseTimeParam. RFC3339 time query parameter, defaultValue if not set
+func parseTimeParam(r *http.Request, name string, defaultValue time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("%s must be an RFC3339 time, e.g. 2024-01-01T07:00:00+07:00", name))
+	}
+	return t, nil
+}
+
+// parseWayId. osm way id path parameter
+func parseWayId(p httprouter.Params) (int64, error) {
+	wayId, err := strconv.ParseInt(p.ByName("way_id"), 10, 64)
+	if err != nil || wayId <= 0 {
+		return 0, errors.New("way_id must be a positive osm way id")
+	}
+	return wayId, nil
+}
+
+// parseHistoryRange. direction (forward by default), from & to query parameters. to defaults to now and from to
+// defaultRange before to
+func (api *wazeAPI) parseHistoryRange(r *http.Request, defaultRange time.Duration) (historyRangeRequest, error) {
+	req := historyRangeRequest{Direction: r.URL.Query().Get("direction")}
+	if req.Direction == "" {
+		req.Direction = datastructure.FORWARD.String()
+	}
+	var err error
+	if req.To, err = parseTimeParam(r, "to", time.Now().UTC().Truncate(time.Second)); err != nil {
+		return historyRangeRequest{}, err
+	}
+	if req.From, err = parseTimeParam(r, "from", req.To.Add(-defaultRange)); err != nil {
+		return historyRangeRequest{}, err
+	}
+
+	if err := api.validate.Struct(req); err != nil {
+		return historyRangeRequest{}, errors.Join(translateError(err, api.trans)...)
+	}
+	if req.To.Sub(req.From) > MAX_HISTORY_RANGE {
+		return historyRangeRequest{}, errors.New(fmt.Sprintf("the time range between from and to must not exceed %d days",
+			int(MAX_HISTORY_RANGE.Hours()/24)))
+	}
+	return req, nil
+}
+
+func (req historyRangeRequest) direction() datastructure.Direction {
+	if req.Direction == datastructure.BACKWARD.String() {
+		return datastructure.BACKWARD
+	}
+	return datastructure.FORWARD
+}
+
+// waySpeedSeries. stored speed of the osm way at every scrape in [from, to) (last 24 hours by default), in the travel
+// direction of the direction query parameter. failed scrapes are gaps with source missing
+func (api *wazeAPI) waySpeedSeries(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	wayId, err := parseWayId(p)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	req, err := api.parseHistoryRange(r, DEFAULT_SERIES_RANGE)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+
+	series, err := api.trafficService.GetWaySpeedSeries(r.Context(), p.ByName("region"), wayId, req.direction(),
+		req.From, req.To)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	pendingFrom, err := api.trafficService.GetHistoryPendingFrom(r.Context(), p.ByName("region"), req.From, req.To)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	response := NewWaySpeedSeriesResponse(wayId, req, series)
+	response.PendingFrom = pendingFrom
+
+	headers := make(http.Header)
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": response}, headers); err != nil {
+		api.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// historicalTraffic. stored traffic of the region at the last scrape in [at - max_age, at] (max_age is a duration,
+// 15m by default), filtered like the latest traffic. the way ranges are not part of the history
+func (api *wazeAPI) historicalTraffic(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	format, err := responseFormat(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	filter, err := api.parseTrafficFilter(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	req := historicalTrafficRequest{MaxAge: DEFAULT_SNAPSHOT_MAX_AGE}
+	if req.At, err = parseTimeParam(r, "at", time.Time{}); err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	if maxAge := r.URL.Query().Get("max_age"); maxAge != "" {
+		if req.MaxAge, err = time.ParseDuration(maxAge); err != nil {
+			api.BadRequestResponse(w, r, errors.New("max_age must be a duration, e.g. 15m"))
+			return
+		}
+	}
+	if err := api.validate.Struct(req); err != nil {
+		api.BadRequestResponse(w, r, errors.Join(translateError(err, api.trans)...))
+		return
+	}
+	if req.MaxAge > MAX_SNAPSHOT_MAX_AGE {
+		api.BadRequestResponse(w, r, errors.New(fmt.Sprintf("max_age must not exceed %s", MAX_SNAPSHOT_MAX_AGE)))
+		return
+	}
+
+	snapshot, err := api.trafficService.GetHistoricalTraffic(r.Context(), p.ByName("region"), req.At, req.MaxAge, filter)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	// a later scrape of the window may be in the partition that is still being written
+	pendingFrom, err := api.trafficService.GetHistoryPendingFrom(r.Context(), p.ByName("region"), snapshot.GetTimestamp(),
+		req.At.Add(time.Second))
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Vary", "Accept")
+
+	if format == FORMAT_GEOJSON {
+		fc := NewTrafficFeatureCollection(snapshot)
+		fc.PendingFrom = pendingFrom
+		if err := api.writeGeoJSON(w, http.StatusOK, fc, headers); err != nil {
+			api.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	response := NewTrafficResponse(snapshot)
+	response.PendingFrom = pendingFrom
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": response}, headers); err != nil {
+		api.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// wayHourOfWeekStats. mean, 15th & 85th percentile speed of the osm way per hour of the week over the scrapes in
+// [from, to) (last 28 days by default). the hours of week are in the tz query parameter time zone (IANA name, UTC by
+// default), failed scrapes & repeated waze responses are left out
+func (api *wazeAPI) wayHourOfWeekStats(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	wayId, err := parseWayId(p)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	req, err := api.parseHistoryRange(r, DEFAULT_STATS_RANGE)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			api.BadRequestResponse(w, r, errors.New(fmt.Sprintf("unknown time zone %q in tz", tz)))
+			return
+		}
+	}
+
+	stats, err := api.trafficService.GetHourOfWeekStats(r.Context(), p.ByName("region"), wayId, req.direction(),
+		req.From, req.To, loc)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	pendingFrom, err := api.trafficService.GetHistoryPendingFrom(r.Context(), p.ByName("region"), req.From, req.To)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	response := NewHourOfWeekStatsResponse(wayId, req, loc, stats)
+	response.PendingFrom = pendingFrom
+
+	headers := make(http.Header)
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": response}, headers); err != nil {
+		api.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"net/http"
 
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
 	"github.com/julienschmidt/httprouter"
 	helper "github.com/lintang-b-s/waze-traffic-scraper/pkg/http/router/routerhelper"
 	"go.uber.org/zap"
@@ -11,33 +13,112 @@ import (
 type wazeAPI struct {
 	trafficService TrafficService
 	log            *zap.Logger
+	validate       *validator.Validate
+	trans          ut.Translator
 }
 
 func New(trafficService TrafficService, log *zap.Logger) *wazeAPI {
+	validate, trans := newValidator()
 	return &wazeAPI{
 		trafficService: trafficService,
 		log:            log,
+		validate:       validate,
+		trans:          trans,
 	}
 }
 
 func (api *wazeAPI) Routes(group *helper.RouteGroup) {
+	// default region
 	group.GET("/traffic", api.traffic)
+	group.GET("/traffic/stream", api.trafficStream)
+	group.GET("/closures", api.closures)
+	group.GET("/tiles/:z/:x/:y", api.tile)
+	group.GET("/history/traffic", api.historicalTraffic)
+	group.GET("/history/ways/:way_id/speeds", api.waySpeedSeries)
+	group.GET("/history/ways/:way_id/hour-of-week", api.wayHourOfWeekStats)
+
+	group.GET("/regions", api.regions)
+	group.GET("/regions/:region/traffic", api.traffic)
+	group.GET("/regions/:region/traffic/stream", api.trafficStream)
+	group.GET("/regions/:region/closures", api.closures)
+	group.GET("/regions/:region/tiles/:z/:x/:y", api.tile)
+	group.GET("/regions/:region/history/traffic", api.historicalTraffic)
+	group.GET("/regions/:region/history/ways/:way_id/speeds", api.waySpeedSeries)
+	group.GET("/regions/:region/history/ways/:way_id/hour-of-week", api.wayHourOfWeekStats)
 }
 
+func (api *wazeAPI) regions(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	headers := make(http.Header)
+
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": NewRegionResponse(api.trafficService.GetRegions(r.Context()))}, headers); err != nil {
+		api.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// traffic. latest traffic of the region, filtered by the query parameters:
+// bbox=min_lon,min_lat,max_lon,max_lat, way_ids=1,2,3, highway=primary,secondary and min_congestion=0.5.
+// returns a geojson FeatureCollection with format=geojson or Accept: application/geo+json
 func (api *wazeAPI) traffic(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	var (
 		err error
 	)
 
-	traffics, err := api.trafficService.GetRealtimeTraffic()
+	format, err := responseFormat(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	filter, err := api.parseTrafficFilter(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+
+	snapshot, err := api.trafficService.GetRealtimeTraffic(r.Context(), p.ByName("region"), filter)
 	if err != nil {
 		api.getStatusCode(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
+	headers.Set("Vary", "Accept")
+
+	if format == FORMAT_GEOJSON {
+		if err := api.writeGeoJSON(w, http.StatusOK, NewTrafficFeatureCollection(snapshot), headers); err != nil {
+			api.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": NewTrafficResponse(snapshot)}, headers); err != nil {
+		api.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (api *wazeAPI) closures(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	format, err := responseFormat(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	closures, err := api.trafficService.GetActiveClosures(r.Context(), p.ByName("region"))
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Vary", "Accept")
+
+	if format == FORMAT_GEOJSON {
+		if err := api.writeGeoJSON(w, http.StatusOK, NewClosureFeatureCollection(closures), headers); err != nil {
+			api.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
 
-	if err := api.writeJSON(w, http.StatusOK, envelope{"data": NewTrafficResponse(traffics)}, headers); err != nil {
+	if err := api.writeJSON(w, http.StatusOK, envelope{"data": NewClosureResponse(closures)}, headers); err != nil {
 		api.ServerErrorResponse(w, r, err)
 		return
 	}
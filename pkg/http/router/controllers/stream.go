@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"go.uber.org/zap"
+)
+
+const (
+	STREAM_PATH_SUFFIX   = "/traffic/stream"
+	STREAM_HEARTBEAT     = 15 * time.Second // comment sent on an idle stream, keeps proxies from closing it
+	STREAM_WRITE_TIMEOUT = 10 * time.Second // a subscriber that doesn't read an event within this is disconnected
+
+	EVENT_DIFF   = "diff"
+	EVENT_LAGGED = "lagged"
+)
+
+// IsStreamRequest. requests of the traffic stream, they are long lived and must not be buffered by the server timeout
+func IsStreamRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, STREAM_PATH_SUFFIX)
+}
+
+type trafficDiffResponse struct {
+	Timestamp       time.Time          `json:"timestamp"`
+	Sequence        uint64             `json:"sequence"`
+	Changed         []TrafficData      `json:"changed"` // newly jammed ways & jammed ways whose speed changed
+	Cleared         []WayDirectionData `json:"cleared"` // ways that are no longer jammed
+	ClosuresAdded   []ClosureData      `json:"closures_added"`
+	ClosuresCleared []ClosureRefData   `json:"closures_cleared"`
+}
+
+type WayDirectionData struct {
+	WayId     int64  `json:"way_id"`
+	Direction string `json:"direction"`
+}
+
+type ClosureRefData struct {
+	JamUUID   int64  `json:"jam_uuid"`
+	WayId     int64  `json:"way_id"`
+	Direction string `json:"direction"`
+}
+
+func NewTrafficDiffResponse(diff datastructure.TrafficDiff) trafficDiffResponse {
+	response := trafficDiffResponse{
+		Timestamp:       diff.GetTimestamp(),
+		Sequence:        diff.GetSequence(),
+		Changed:         make([]TrafficData, 0, len(diff.GetChanged())),
+		Cleared:         make([]WayDirectionData, 0, len(diff.GetCleared())),
+		ClosuresAdded:   make([]ClosureData, 0, len(diff.GetClosuresAdded())),
+		ClosuresCleared: make([]ClosureRefData, 0, len(diff.GetClosuresCleared())),
+	}
+	for _, wt := range diff.GetChanged() {
+		response.Changed = append(response.Changed, newTrafficData(wt))
+	}
+	for _, wt := range diff.GetCleared() {
+		response.Cleared = append(response.Cleared, WayDirectionData{
+			WayId:     wt.GetWay().GetID(),
+			Direction: wt.GetDirection().String(),
+		})
+	}
+	for _, closure := range diff.GetClosuresAdded() {
+		response.ClosuresAdded = append(response.ClosuresAdded, newClosureData(closure))
+	}
+	for _, closure := range diff.GetClosuresCleared() {
+		response.ClosuresCleared = append(response.ClosuresCleared, ClosureRefData{
+			JamUUID:   closure.GetJamUUID(),
+			WayId:     closure.GetWay().GetID(),
+			Direction: closure.GetDirection().String(),
+		})
+	}
+	return response
+}
+
+// trafficStream. server-sent events stream of the traffic diffs of the region, one diff event after every scrape. the
+// first diff is from an empty state to the latest traffic, applying the diffs in order gives the latest traffic &
+// closures. the bbox, way_ids & highway query parameters restrict the diffs to some ways. a client that falls
+// stream_buffer diffs behind gets a lagged event and is disconnected, it has to reconnect (EventSource does so
+// automatically) and starts over from the latest traffic
+func (api *wazeAPI) trafficStream(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	filter, err := api.parseTrafficFilter(r)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+	if filter.GetMinCongestion() > 0 {
+		// a way crossing the threshold would need to be pushed as cleared or changed, not supported
+		api.BadRequestResponse(w, r, errors.New("min_congestion is not supported by the traffic stream"))
+		return
+	}
+
+	subscription, err := api.trafficService.SubscribeTraffic(r.Context(), p.ByName("region"), filter)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+	defer subscription.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	// every write gets its own deadline instead of the server write timeout, a stalled client is disconnected
+	send := func(write func() error) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT)); err != nil {
+			api.log.Error("failed to set traffic stream write deadline", zap.Error(err))
+			return false
+		}
+		if write != nil {
+			if err := write(); err != nil {
+				return false
+			}
+		}
+		return rc.Flush() == nil
+	}
+	// send the headers, the first diff may wait for the first scrape
+	if !send(nil) {
+		return
+	}
+
+	heartbeat := time.NewTicker(STREAM_HEARTBEAT)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !send(func() error { _, err := fmt.Fprint(w, ": heartbeat\n\n"); return err }) {
+				return
+			}
+		case diff, ok := <-subscription.Diffs():
+			if !ok {
+				if subscription.Lagged() {
+					api.log.Warn("traffic stream subscriber fell behind, disconnecting", zap.String("remote", r.RemoteAddr))
+					send(func() error {
+						return writeEvent(w, EVENT_LAGGED, 0, NewMessageResponse("stream buffer overflow, reconnect"))
+					})
+				}
+				return
+			}
+			if !send(func() error { return writeEvent(w, EVENT_DIFF, diff.GetSequence(), NewTrafficDiffResponse(diff)) }) {
+				return
+			}
+			heartbeat.Reset(STREAM_HEARTBEAT)
+		}
+	}
+}
+
+// writeEvent. server-sent event with a json data line, the sequence is the event id if not 0
+func writeEvent(w http.ResponseWriter, event string, sequence uint64, data any) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if sequence > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", sequence); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, js)
+	return err
+}
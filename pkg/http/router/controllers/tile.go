@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/tiles"
+	"github.com/paulmach/orb/maptile"
+	"go.uber.org/zap"
+)
+
+const MVT_CONTENT_TYPE = "application/vnd.mapbox-vector-tile"
+
+// parseTile. z/x/y.mvt path parameters (xyz tile scheme), httprouter can't match the .mvt suffix so it is part of y
+func parseTile(p httprouter.Params) (maptile.Tile, error) {
+	y, ok := strings.CutSuffix(p.ByName("y"), ".mvt")
+	if !ok {
+		return maptile.Tile{}, errors.New("tile path must be /tiles/{z}/{x}/{y}.mvt")
+	}
+	z, err := strconv.ParseUint(p.ByName("z"), 10, 32)
+	if err != nil || z > tiles.MAX_ZOOM {
+		return maptile.Tile{}, errors.New(fmt.Sprintf("z must be an integer between 0 and %d", tiles.MAX_ZOOM))
+	}
+	tileX, errX := strconv.ParseUint(p.ByName("x"), 10, 32)
+	tileY, errY := strconv.ParseUint(y, 10, 32)
+	tile := maptile.New(uint32(tileX), uint32(tileY), maptile.Zoom(z))
+	if errX != nil || errY != nil || !tile.Valid() {
+		return maptile.Tile{}, errors.New(fmt.Sprintf("x and y must be integers between 0 and %d at zoom %d", (1<<z)-1, z))
+	}
+	return tile, nil
+}
+
+// tile. mapbox vector tile of the latest traffic of the region with one layer (traffic), the features are the
+// jammed & free flowing ranges of the affected ways with the speed, free flow speed, congestion ratio, range offsets,
+// highway & street name attributes. 204 if no traffic crosses the tile
+func (api *wazeAPI) tile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tile, err := parseTile(p)
+	if err != nil {
+		api.BadRequestResponse(w, r, err)
+		return
+	}
+
+	data, err := api.trafficService.GetTrafficTile(r.Context(), p.ByName("region"), tile)
+	if err != nil {
+		api.getStatusCode(w, r, err)
+		return
+	}
+
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", MVT_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		api.log.Error("failed to write vector tile response", zap.Error(err))
+	}
+}
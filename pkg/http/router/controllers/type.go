@@ -1,7 +1,26 @@
 package controllers
 
-import "github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+import (
+	"context"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/region"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
+	"github.com/paulmach/orb/maptile"
+)
 
 type TrafficService interface {
-	GetRealtimeTraffic() ([]datastructure.WayTraffic, error)
+	GetRegions(ctx context.Context) []*region.Region
+	GetRealtimeTraffic(ctx context.Context, regionName string, filter datastructure.TrafficFilter) (datastructure.TrafficSnapshot, error)
+	GetTrafficTile(ctx context.Context, regionName string, tile maptile.Tile) ([]byte, error)
+	SubscribeTraffic(ctx context.Context, regionName string, filter datastructure.TrafficFilter) (*scraper.Subscription, error)
+	GetActiveClosures(ctx context.Context, regionName string) ([]datastructure.RoadClosure, error)
+	GetWaySpeedSeries(ctx context.Context, regionName string, wayId int64, direction datastructure.Direction,
+		from, to time.Time) ([]datastructure.SpeedObservation, error)
+	GetHistoricalTraffic(ctx context.Context, regionName string, at time.Time, maxAge time.Duration,
+		filter datastructure.TrafficFilter) (datastructure.TrafficSnapshot, error)
+	GetHourOfWeekStats(ctx context.Context, regionName string, wayId int64, direction datastructure.Direction,
+		from, to time.Time, loc *time.Location) ([]datastructure.HourOfWeekStats, error)
+	GetHistoryPendingFrom(ctx context.Context, regionName string, from, to time.Time) (*time.Time, error)
 }
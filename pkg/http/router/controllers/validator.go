@@ -1,19 +1,35 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
+	"reflect"
+	"strings"
 
+	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
 )
 
+// newValidator. validator with english error messages, fields are named after their query parameter
+func newValidator() (*validator.Validate, ut.Translator) {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return strings.SplitN(field.Tag.Get("query"), ",", 2)[0]
+	})
+	english := en.New()
+	trans, _ := ut.New(english, english).GetTranslator("en")
+	en_translations.RegisterDefaultTranslations(validate, trans)
+	return validate, trans
+}
+
 func translateError(err error, trans ut.Translator) (errs []error) {
 	if err == nil {
 		return nil
 	}
 	validatorErrs := err.(validator.ValidationErrors)
 	for _, e := range validatorErrs {
-		translatedErr := fmt.Errorf(e.Translate(trans))
+		translatedErr := errors.New(e.Translate(trans))
 		errs = append(errs, translatedErr)
 	}
 	return errs
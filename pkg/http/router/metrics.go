@@ -0,0 +1,66 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "waze_http_requests_total",
+		Help: "API requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "waze_http_request_duration_seconds",
+		Help:    "Duration of the API requests by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Metrics. middleware counting the requests & observing their duration, requests are labeled with the route pattern
+// (e.g. /api/regions/:region/traffic) instead of the path to bound the number of label values
+func Metrics(router *httprouter.Router) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrapped := wrapResponseWriter(w)
+			defer func() {
+				status := wrapped.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				route := routePattern(router, r)
+				httpRequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+				httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+			}()
+
+			next.ServeHTTP(wrapped, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// routePattern. route pattern matching the request path, the params of the path are replaced with their names
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+	route := r.URL.Path
+	for _, p := range params {
+		if strings.HasPrefix(p.Value, "/") {
+			// catch-all param, always the rest of the path
+			route = strings.TrimSuffix(route, p.Value) + "/*" + p.Key
+			continue
+		}
+		route = strings.Replace(route, "/"+p.Value, "/:"+p.Key, 1)
+	}
+	return route
+}
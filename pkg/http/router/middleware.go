@@ -125,6 +125,11 @@ func (rw *responseWriter) Status() int {
 	return rw.status
 }
 
+// Unwrap. lets http.ResponseController reach the Flusher & the write deadline of the connection
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) WriteHeader(code int) {
 	if rw.wroteHeader {
 		return
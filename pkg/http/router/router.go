@@ -11,6 +11,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	"go.uber.org/zap"
 
@@ -67,6 +68,8 @@ func (api *API) Run(
 
 	router.Handler(http.MethodGet, "/debug/pprof/*item", http.DefaultServeMux)
 
+	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
+
 	group := router_helper.NewRouteGroup(router, "/api")
 
 	searcherRoutes := controllers.New(trafficService, log)
@@ -76,21 +79,35 @@ func (api *API) Run(
 	var mwChain []alice.Constructor
 	if useRateLimit {
 		mwChain = append(mwChain, corsHandler.Handler, EnforceJSONHandler, api.recoverPanic,
-			RealIP, Heartbeat("healthz"), Logger(log), Labels, Limit)
+			RealIP, Heartbeat("healthz"), Logger(log), Metrics(router), Labels, Limit)
 	} else {
 		mwChain = append(mwChain, corsHandler.Handler, EnforceJSONHandler, api.recoverPanic,
-			RealIP, Heartbeat("healthz"), Logger(log), Labels)
+			RealIP, Heartbeat("healthz"), Logger(log), Metrics(router), Labels)
 	}
 	mainMwChain := alice.New(mwChain...).Then(router)
 
+	config.NoTimeout = controllers.IsStreamRequest
 	srv := http_server.New(ctx, mainMwChain, config)
 	log.Info(fmt.Sprintf("API run on port %d", config.Port))
 
-	err := srv.ListenAndServe()
-	if err != nil {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
 		return err
+	case <-ctx.Done():
 	}
 
+	// stop accepting new requests and wait for the in-flight requests
+	log.Info("shutting down API")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -3,10 +3,10 @@ package http
 import (
 	"context"
 
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/config"
 	http_router "github.com/lintang-b-s/waze-traffic-scraper/pkg/http/router"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/http/router/controllers"
 	http_server "github.com/lintang-b-s/waze-traffic-scraper/pkg/http/server"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
@@ -23,17 +23,18 @@ func (s *Server) Use(
 	ctx context.Context,
 	log *zap.Logger,
 
-	useRateLimit bool,
+	serverConfig config.ServerConfig,
 	trafficService controllers.TrafficService,
 
 ) (*Server, error) {
-	viper.SetDefault("API_PORT", 6064)
-
-	viper.SetDefault("API_TIMEOUT", "1000s")
-
-	config := http_server.Config{
-		Port:    viper.GetInt("API_PORT"),
-		Timeout: viper.GetDuration("API_TIMEOUT"),
+	httpConfig := http_server.Config{
+		Port:              serverConfig.Port,
+		Timeout:           serverConfig.Timeout,
+		ShutdownTimeout:   serverConfig.ShutdownTimeout,
+		ReadTimeout:       serverConfig.ReadTimeout,
+		WriteTimeout:      serverConfig.WriteTimeout,
+		IdleTimeout:       serverConfig.IdleTimeout,
+		ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
 	}
 
 	server := http_router.NewAPI(log)
@@ -42,10 +43,11 @@ func (s *Server) Use(
 
 	g.Go(func() error {
 		return server.Run(
-			ctx, config, log,
-			useRateLimit, trafficService,
+			ctx, httpConfig, log,
+			serverConfig.RateLimit, trafficService,
 		)
 	})
 
-	return s, nil
+	// blocks until ctx is cancelled and the server is shut down
+	return s, g.Wait()
 }
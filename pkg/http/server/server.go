@@ -5,19 +5,21 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-
-	"github.com/spf13/viper"
 )
 
 const TimeoutMessage = `{"error":"context deadline exceeded"}`
 
+// New. every request is bounded by config.Timeout except the requests matching config.NoTimeout (streams),
+// http.TimeoutHandler buffers the whole response so a stream would never reach the client
 func New(ctx context.Context, h http.Handler, config Config) *http.Server {
-	viper.SetDefault("HTTP_SERVER_READ_TIMEOUT", "10s")
-	viper.SetDefault("HTTP_SERVER_WRITE_TIMEOUT", "10s")
-	viper.SetDefault("HTTP_SERVER_IDLE_TIMEOUT", "30s")
-	viper.SetDefault("HTTP_SERVER_READ_HEADER_TIMEOUT", "2s")
-
-	handler := http.TimeoutHandler(h, config.Timeout, fmt.Sprintf(`{"error": %q}`, TimeoutMessage))
+	timeoutHandler := http.TimeoutHandler(h, config.Timeout, fmt.Sprintf(`{"error": %q}`, TimeoutMessage))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.NoTimeout != nil && config.NoTimeout(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, r)
+	})
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
@@ -26,10 +28,10 @@ func New(ctx context.Context, h http.Handler, config Config) *http.Server {
 			return ctx
 		},
 
-		ReadTimeout:       viper.GetDuration("HTTP_SERVER_READ_TIMEOUT"),
-		WriteTimeout:      config.Timeout + viper.GetDuration("HTTP_SERVER_WRITE_TIMEOUT"),
-		IdleTimeout:       viper.GetDuration("HTTP_SERVER_IDLE_TIMEOUT"),
-		ReadHeaderTimeout: viper.GetDuration("HTTP_SERVER_READ_HEADER_TIMEOUT"),
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.Timeout + config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
 	}
 
 	return server
@@ -1,10 +1,19 @@
 package http_server
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 type Config struct {
 	Port int
 	Timeout time.Duration
+	ShutdownTimeout time.Duration
+	ReadTimeout time.Duration
+	WriteTimeout time.Duration // added to Timeout
+	IdleTimeout time.Duration
+	ReadHeaderTimeout time.Duration
+	NoTimeout func(r *http.Request) bool // requests served without Timeout, they manage their own write deadlines
 }
 
 type API struct {
@@ -1,23 +1,207 @@
 package usecases
 
 import (
+	"context"
+	"time"
+
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/region"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/tiles"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/util"
+	"github.com/paulmach/orb/maptile"
 	"go.uber.org/zap"
 )
 
 type TrafficService struct {
 	log     *zap.Logger
-	scraper *scraper.Scraper
+	regions *region.Registry
+	tiles   map[string]*tiles.TileCache // vector tile cache of every region
+
+	histories    map[string]*scraper.History // stored scrapes of every region
+	streamBuffer int                         // diffs queued per stream subscriber
 }
 
-func NewTrafficService(log *zap.Logger, scraper *scraper.Scraper) *TrafficService {
+func NewTrafficService(log *zap.Logger, regions *region.Registry, histories map[string]*scraper.History, tileCacheSize,
+	streamBuffer int) *TrafficService {
+	tileCaches := make(map[string]*tiles.TileCache)
+	for _, r := range regions.GetRegions() {
+		tileCaches[r.GetName()] = tiles.NewTileCache(tileCacheSize)
+	}
 	return &TrafficService{
 		log:     log,
-		scraper: scraper,
+		regions: regions,
+		tiles:   tileCaches,
+
+		histories:    histories,
+		streamBuffer: streamBuffer,
+	}
+}
+
+func (rs *TrafficService) getRegion(name string) (*region.Region, error) {
+	r, ok := rs.regions.GetRegion(name)
+	if !ok {
+		return nil, util.WrapErrorf(nil, util.ErrNotFound, "unknown region %q", name)
+	}
+	return r, nil
+}
+
+// GetRegions. every scraped region, the first region is the default region
+func (rs *TrafficService) GetRegions(ctx context.Context) []*region.Region {
+	return rs.regions.GetRegions()
+}
+
+// GetRealtimeTraffic. latest snapshot of the background scrape loop of the region (the default region if regionName is
+// empty) with the traffic matching the filter, never sends a request to waze
+func (rs *TrafficService) GetRealtimeTraffic(ctx context.Context, regionName string,
+	filter datastructure.TrafficFilter) (datastructure.TrafficSnapshot, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return datastructure.TrafficSnapshot{}, err
+	}
+	snapshot, ok := r.GetScraper().GetLatestTraffic()
+	if !ok {
+		return datastructure.TrafficSnapshot{}, util.WrapErrorf(nil, util.ErrNotFound, "no traffic scraped yet")
+	}
+	return datastructure.NewTrafficSnapshot(snapshot.GetTimestamp(),
+		r.GetNetwork().FilterTraffic(snapshot.GetTraffic(), filter)).WithSequence(snapshot.GetSequence()), nil
+}
+
+// GetTrafficTile. mvt tile of the latest snapshot of the region, the tile is built once per snapshot & served from the
+// tile cache until the next scrape lands. empty if no traffic crosses the tile
+func (rs *TrafficService) GetTrafficTile(ctx context.Context, regionName string, tile maptile.Tile) ([]byte, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, ok := r.GetScraper().GetLatestTraffic()
+	if !ok {
+		return nil, util.WrapErrorf(nil, util.ErrNotFound, "no traffic scraped yet")
+	}
+	network := r.GetNetwork()
+	return rs.tiles[r.GetName()].Get(snapshot.GetSequence(), tile, func() ([]byte, error) {
+		filter := datastructure.NewTrafficFilter().WithBoundingBox(tiles.TileBoundingBox(tile))
+		return tiles.EncodeTile(tile, network.FilterTraffic(snapshot.GetTraffic(), filter), network.GetHighway)
+	})
+}
+
+// SubscribeTraffic. subscribe to the traffic diffs of the region pushed after every scrape, restricted to the ways
+// matching the bounding box, way ids & highway classes of the filter. the caller must close the subscription
+func (rs *TrafficService) SubscribeTraffic(ctx context.Context, regionName string,
+	filter datastructure.TrafficFilter) (*scraper.Subscription, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return nil, err
+	}
+	if filter.IsEmpty() {
+		return r.GetScraper().SubscribeTraffic(rs.streamBuffer, nil), nil
+	}
+
+	network := r.GetNetwork()
+	var inBoundingBox map[int64]struct{}
+	if bb, ok := filter.GetBoundingBox(); ok {
+		// the road network is static, the ways of the bounding box are searched once per subscription
+		inBoundingBox = network.WayIdsInBoundingBox(bb)
+	}
+	keep := func(wayId int64) bool {
+		if inBoundingBox != nil {
+			if _, ok := inBoundingBox[wayId]; !ok {
+				return false
+			}
+		}
+		return filter.HasWayId(wayId) && filter.HasHighway(network.GetHighway(wayId))
 	}
+	return r.GetScraper().SubscribeTraffic(rs.streamBuffer, keep), nil
 }
 
-func (rs *TrafficService) GetRealtimeTraffic() ([]datastructure.WayTraffic, error) {
-	return rs.scraper.Scrape()
+// GetActiveClosures. active road closures of the background scrape loop of the region
+func (rs *TrafficService) GetActiveClosures(ctx context.Context, regionName string) ([]datastructure.RoadClosure, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetScraper().GetActiveClosures(), nil
+}
+
+// getWayHistory. history of the region, the osm way must be part of the road network of the region
+func (rs *TrafficService) getWayHistory(regionName string, wayId int64) (*scraper.History, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return nil, err
+	}
+	if r.GetNetwork().GetHighway(wayId) == "" {
+		return nil, util.WrapErrorf(nil, util.ErrNotFound, "unknown osm way %d in region %s", wayId, r.GetName())
+	}
+	history, ok := rs.histories[r.GetName()]
+	if !ok {
+		return nil, util.WrapErrorf(nil, util.ErrNotFound, "no traffic history for region %s", r.GetName())
+	}
+	return history, nil
+}
+
+// GetHistoryPendingFrom. start of the stored scrapes of the region in [from, to) that are not readable yet (the parquet
+// partition that is still being written), nil if the history of the range is complete
+func (rs *TrafficService) GetHistoryPendingFrom(ctx context.Context, regionName string, from, to time.Time) (*time.Time, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return nil, err
+	}
+	history, ok := rs.histories[r.GetName()]
+	if !ok {
+		return nil, util.WrapErrorf(nil, util.ErrNotFound, "no traffic history for region %s", r.GetName())
+	}
+	pendingFrom, ok, err := history.GetPendingFrom(from, to)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &pendingFrom, nil
+}
+
+// GetWaySpeedSeries. stored speed of the osm way in the travel direction at every scrape in [from, to)
+func (rs *TrafficService) GetWaySpeedSeries(ctx context.Context, regionName string, wayId int64,
+	direction datastructure.Direction, from, to time.Time) ([]datastructure.SpeedObservation, error) {
+	history, err := rs.getWayHistory(regionName, wayId)
+	if err != nil {
+		return nil, err
+	}
+	return history.GetWaySpeedSeries(wayId, direction, from, to)
+}
+
+// GetHistoricalTraffic. stored traffic of the region at the last scrape in [at - maxAge, at] with the traffic matching the
+// filter
+func (rs *TrafficService) GetHistoricalTraffic(ctx context.Context, regionName string, at time.Time, maxAge time.Duration,
+	filter datastructure.TrafficFilter) (datastructure.TrafficSnapshot, error) {
+	r, err := rs.getRegion(regionName)
+	if err != nil {
+		return datastructure.TrafficSnapshot{}, err
+	}
+	history, ok := rs.histories[r.GetName()]
+	if !ok {
+		return datastructure.TrafficSnapshot{}, util.WrapErrorf(nil, util.ErrNotFound, "no traffic history for region %s",
+			r.GetName())
+	}
+	snapshot, ok, err := history.GetSnapshotAt(at, maxAge)
+	if err != nil {
+		return datastructure.TrafficSnapshot{}, err
+	}
+	if !ok {
+		if pendingFrom, pending, err := history.GetPendingFrom(at.Add(-maxAge), at.Add(time.Second)); err == nil && pending {
+			return datastructure.TrafficSnapshot{}, util.WrapErrorf(nil, util.ErrNotFound,
+				"the traffic scraped since %s is not readable until its partition is complete", pendingFrom.Format(time.RFC3339))
+		}
+		return datastructure.TrafficSnapshot{}, util.WrapErrorf(nil, util.ErrNotFound, "no traffic scraped between %s and %s",
+			at.Add(-maxAge).Format(time.RFC3339), at.Format(time.RFC3339))
+	}
+	return datastructure.NewTrafficSnapshot(snapshot.GetTimestamp(), r.GetNetwork().FilterTraffic(snapshot.GetTraffic(), filter)), nil
+}
+
+// GetHourOfWeekStats. speed statistics of the osm way in the travel direction per hour of the week (in the time zone
+// loc) over the scrapes in [from, to)
+func (rs *TrafficService) GetHourOfWeekStats(ctx context.Context, regionName string, wayId int64,
+	direction datastructure.Direction, from, to time.Time, loc *time.Location) ([]datastructure.HourOfWeekStats, error) {
+	history, err := rs.getWayHistory(regionName, wayId)
+	if err != nil {
+		return nil, err
+	}
+	return history.GetHourOfWeekStats(wayId, direction, from, to, loc)
 }
@@ -0,0 +1,19 @@
+package mapmatching
+
+const (
+	DEFAULT_SEARCH_RADIUS = 0.025 // 25 meters radius (km)
+	DEFAULT_SIGMA_Z       = 10.0  // standard deviation of the distance between waze line points and the osm road (m)
+	DEFAULT_BETA          = 20.0  // expected difference between route distance & great circle distance (m)
+
+	// route search between candidates of two consecutive observations is bounded to
+	// greatCircleDistance*ROUTE_DISTANCE_FACTOR + ROUTE_DISTANCE_SLACK meters
+	ROUTE_DISTANCE_FACTOR = 3.0
+	ROUTE_DISTANCE_SLACK  = 200.0
+)
+
+const (
+	SIGMA_HEADING = 45.0 // standard deviation of the difference between the line heading and the candidate bearing (degree)
+
+	// waze line points can be projected slightly behind the previous projection on the same edge
+	SAME_EDGE_BACKWARD_TOLERANCE = 5.0 // m
+)
@@ -0,0 +1,100 @@
+package mapmatching
+
+import (
+	"container/heap"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+type pqItem struct {
+	nodeId uint32
+	dist   float64
+}
+
+type priorityQueue []pqItem
+
+func (pq priorityQueue) Len() int           { return len(pq) }
+func (pq priorityQueue) Less(i, j int) bool { return pq[i].dist < pq[j].dist }
+func (pq priorityQueue) Swap(i, j int)      { pq[i], pq[j] = pq[j], pq[i] }
+
+func (pq *priorityQueue) Push(x any) {
+	*pq = append(*pq, x.(pqItem))
+}
+
+func (pq *priorityQueue) Pop() any {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	*pq = old[:n-1]
+	return item
+}
+
+type shortestPathTree struct {
+	dist      map[uint32]float64
+	parentArc map[uint32]datastructure.Arc // node id -> arc used to reach the node, missing for source nodes
+}
+
+// shortestPaths. bounded multi source dijkstra. sources maps source node id to its initial distance (m).
+// the search stops when all target nodes are settled or the distance exceeds maxDist
+func (m *HMMMapMatcher) shortestPaths(sources map[uint32]float64, targets map[uint32]struct{},
+	maxDist float64) shortestPathTree {
+	spt := shortestPathTree{
+		dist:      make(map[uint32]float64),
+		parentArc: make(map[uint32]datastructure.Arc),
+	}
+	settled := make(map[uint32]struct{})
+	pq := make(priorityQueue, 0, len(sources))
+	for nodeId, dist := range sources {
+		spt.dist[nodeId] = dist
+		heap.Push(&pq, pqItem{nodeId, dist})
+	}
+
+	remainingTargets := len(targets)
+	for pq.Len() > 0 && remainingTargets > 0 {
+		item := heap.Pop(&pq).(pqItem)
+		if _, ok := settled[item.nodeId]; ok {
+			continue
+		}
+		if item.dist > maxDist {
+			break
+		}
+		settled[item.nodeId] = struct{}{}
+		if _, ok := targets[item.nodeId]; ok {
+			remainingTargets--
+		}
+
+		for _, arc := range m.graph.GetOutgoingArcs(item.nodeId) {
+			edge := m.graph.GetEdge(arc.GetEdgeId())
+			next := m.graph.GetArcHead(arc)
+			if _, ok := settled[next]; ok {
+				continue
+			}
+
+			newDist := item.dist + edge.GetLength()*1000
+			if oldDist, ok := spt.dist[next]; ok && oldDist <= newDist {
+				continue
+			}
+			spt.dist[next] = newDist
+			spt.parentArc[next] = arc
+			heap.Push(&pq, pqItem{next, newDist})
+		}
+	}
+	return spt
+}
+
+// arcsTo. arcs on the shortest path from a source node to nodeId
+func (spt shortestPathTree) arcsTo(nodeId uint32, m *HMMMapMatcher) []datastructure.Arc {
+	path := make([]datastructure.Arc, 0)
+	for {
+		arc, ok := spt.parentArc[nodeId]
+		if !ok {
+			break
+		}
+		path = append(path, arc)
+		nodeId = m.graph.GetArcTail(arc)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -0,0 +1,258 @@
+package mapmatching
+
+import (
+	"math"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
+)
+
+// HMMMapMatcher. Hidden Markov Model map matching (Newson & Krumm, 2009).
+// hidden states are the candidate edges (in one travel direction) of every observation, emission probability is a gaussian
+// of the distance between the observation and the candidate and of the difference between the line heading and the
+// candidate bearing, transition probability is an exponential of the difference between the route distance (on the directed
+// road graph) and the great circle distance of two consecutive observations. the most likely sequence of candidates
+// is computed with the viterbi algorithm.
+type HMMMapMatcher struct {
+	graph        *datastructure.Graph
+	rt           *spatialindex.Rtree
+	searchRadius float64 // km
+	sigmaZ       float64 // m
+	beta         float64 // m
+}
+
+func NewHMMMapMatcher(graph *datastructure.Graph, rt *spatialindex.Rtree, searchRadius, sigmaZ, beta float64) *HMMMapMatcher {
+	return &HMMMapMatcher{
+		graph:        graph,
+		rt:           rt,
+		searchRadius: searchRadius,
+		sigmaZ:       sigmaZ,
+		beta:         beta,
+	}
+}
+
+// candidates. project the observation onto the geometry of every edge within the search radius (cross track distance),
+// bidirectional edges produce one candidate for each travel direction
+func (m *HMMMapMatcher) candidates(lon, lat float64) []Candidate {
+	edges := m.rt.SearchWithinRadius(lon, lat, m.searchRadius)
+	candidates := make([]Candidate, 0, len(edges))
+	for _, edge := range edges {
+		polyline := edge.GetPolyline()
+		dist, fraction, projLon, projLat, segment := geo.PointToPolyline(lon, lat, polyline)
+		if dist > m.searchRadius {
+			continue
+		}
+		bearing := geo.InitialBearing(polyline[segment][0], polyline[segment][1],
+			polyline[segment+1][0], polyline[segment+1][1])
+		candidates = append(candidates, NewCandidate(edge, false, dist*1000, fraction, projLon, projLat, bearing))
+		if edge.IsBidirectional() {
+			candidates = append(candidates, NewCandidate(edge, true, dist*1000, 1-fraction, projLon, projLat,
+				math.Mod(bearing+180, 360)))
+		}
+	}
+	return candidates
+}
+
+// emissionLogProbability. heading is the heading of the line at the observation, NaN if the line has only one point
+func (m *HMMMapMatcher) emissionLogProbability(cand Candidate, heading float64) float64 {
+	dist := cand.GetDistance()
+	logProb := -0.5*(dist/m.sigmaZ)*(dist/m.sigmaZ) - math.Log(math.Sqrt(2*math.Pi)*m.sigmaZ)
+	if !math.IsNaN(heading) {
+		headingDiff := geo.BearingDifference(heading, cand.GetBearing())
+		logProb += -0.5 * (headingDiff / SIGMA_HEADING) * (headingDiff / SIGMA_HEADING)
+	}
+	return logProb
+}
+
+func (m *HMMMapMatcher) transitionLogProbability(routeDist, greatCircleDist float64) float64 {
+	return -math.Abs(routeDist-greatCircleDist)/m.beta - math.Log(m.beta)
+}
+
+// route. shortest route (following the travel direction) from candidate from to every candidate in to.
+// returns the route distances (m, +inf if unreachable) and the arcs between the two candidate edges of every route
+func (m *HMMMapMatcher) route(from Candidate, to []Candidate, maxDist float64) ([]float64, [][]datastructure.Arc) {
+	fromEdge := from.GetEdge()
+	sources := map[uint32]float64{
+		from.travelEndNode(): from.distanceToEnd(),
+	}
+
+	targets := make(map[uint32]struct{})
+	for _, cand := range to {
+		targets[cand.travelStartNode()] = struct{}{}
+	}
+	spt := m.shortestPaths(sources, targets, maxDist)
+
+	dists := make([]float64, len(to))
+	paths := make([][]datastructure.Arc, len(to))
+	for i, cand := range to {
+		toEdge := cand.GetEdge()
+		if toEdge.GetEdgeId() == fromEdge.GetEdgeId() && cand.IsReversed() == from.IsReversed() {
+			along := (cand.GetFraction() - from.GetFraction()) * fromEdge.GetLength() * 1000
+			if along >= -SAME_EDGE_BACKWARD_TOLERANCE {
+				dists[i] = math.Max(0, along)
+				continue
+			}
+		}
+
+		d, ok := spt.dist[cand.travelStartNode()]
+		if !ok || d+cand.distanceFromStart() > maxDist {
+			dists[i] = math.Inf(1)
+			continue
+		}
+		dists[i] = d + cand.distanceFromStart()
+		paths[i] = spt.arcsTo(cand.travelStartNode(), m)
+	}
+	return dists, paths
+}
+
+// lineHeadings. heading of the line at every point, NaN if the line has less than 2 points
+func lineHeadings(line []datastructure.Coordinate) []float64 {
+	headings := make([]float64, len(line))
+	for i := range line {
+		if len(line) < 2 {
+			headings[i] = math.NaN()
+			continue
+		}
+		from, to := i, i+1
+		if i == len(line)-1 {
+			from, to = i-1, i
+		}
+		fromLon, fromLat := line[from].GetLonLat()
+		toLon, toLat := line[to].GetLonLat()
+		headings[i] = geo.InitialBearing(fromLon, fromLat, toLon, toLat)
+	}
+	return headings
+}
+
+type viterbiStep struct {
+	obsIdx     int
+	candidates []Candidate
+	logProb    []float64
+	back       []int // index of the best previous candidate, -1 at the start of a path
+	routes     [][]datastructure.Arc
+}
+
+// MapMatch. match the line (e.g. waze jam polyline, in travel order) to a continuous, connected sequence of edges
+func (m *HMMMapMatcher) MapMatch(line []datastructure.Coordinate) MatchResult {
+	result := MatchResult{
+		points: make([]MatchedPoint, len(line)),
+		paths:  make([][]MatchedEdge, 0),
+	}
+	headings := lineHeadings(line)
+
+	steps := make([]viterbiStep, 0, len(line))
+	for i, coord := range line {
+		lon, lat := coord.GetLonLat()
+		result.points[i] = MatchedPoint{lon: lon, lat: lat}
+		cands := m.candidates(lon, lat)
+		if len(cands) == 0 {
+			continue
+		}
+		steps = append(steps, viterbiStep{obsIdx: i, candidates: cands})
+	}
+
+	pathStart := 0
+	for t := range steps {
+		curr := &steps[t]
+		curr.logProb = make([]float64, len(curr.candidates))
+		curr.back = make([]int, len(curr.candidates))
+		curr.routes = make([][]datastructure.Arc, len(curr.candidates))
+
+		connected := false
+		if t > pathStart {
+			prev := steps[t-1]
+			prevLon, prevLat := line[prev.obsIdx].GetLonLat()
+			currLon, currLat := line[curr.obsIdx].GetLonLat()
+			greatCircleDist := geo.CalculateHaversineDistance(prevLon, prevLat, currLon, currLat) * 1000
+			maxDist := greatCircleDist*ROUTE_DISTANCE_FACTOR + ROUTE_DISTANCE_SLACK
+
+			for i := range curr.candidates {
+				curr.logProb[i] = math.Inf(-1)
+				curr.back[i] = -1
+			}
+			for j, prevCand := range prev.candidates {
+				if math.IsInf(prev.logProb[j], -1) {
+					continue
+				}
+				routeDists, routes := m.route(prevCand, curr.candidates, maxDist)
+				for i, cand := range curr.candidates {
+					if math.IsInf(routeDists[i], 1) {
+						continue
+					}
+					logProb := prev.logProb[j] + m.transitionLogProbability(routeDists[i], greatCircleDist) +
+						m.emissionLogProbability(cand, headings[curr.obsIdx])
+					if logProb > curr.logProb[i] {
+						curr.logProb[i] = logProb
+						curr.back[i] = j
+						curr.routes[i] = routes[i]
+						connected = true
+					}
+				}
+			}
+		}
+
+		if !connected {
+			// hmm break, no route from the previous observation. finish the previous path and start a new path
+			if t > pathStart {
+				result.paths = append(result.paths, m.backtrack(steps[pathStart:t], &result))
+			}
+			pathStart = t
+			for i, cand := range curr.candidates {
+				curr.logProb[i] = m.emissionLogProbability(cand, headings[curr.obsIdx])
+				curr.back[i] = -1
+				curr.routes[i] = nil
+			}
+		}
+	}
+	if len(steps) > pathStart {
+		result.paths = append(result.paths, m.backtrack(steps[pathStart:], &result))
+	}
+
+	return result
+}
+
+// backtrack. follow the back pointers from the most likely last candidate, set the matched points and
+// return the connected edge sequence of the path, the first and the last edge are cut at the projections of the first and
+// the last observation
+func (m *HMMMapMatcher) backtrack(steps []viterbiStep, result *MatchResult) []MatchedEdge {
+	last := steps[len(steps)-1]
+	best := 0
+	for i := range last.candidates {
+		if last.logProb[i] > last.logProb[best] {
+			best = i
+		}
+	}
+
+	lastBest, firstBest := best, best
+	arcs := make([]datastructure.Arc, 0)
+	for t := len(steps) - 1; t >= 0; t-- {
+		step := steps[t]
+		cand := step.candidates[best]
+		result.points[step.obsIdx].candidate = cand
+		result.points[step.obsIdx].matched = true
+
+		arcs = append(arcs, cand.arc())
+		route := step.routes[best]
+		for i := len(route) - 1; i >= 0; i-- {
+			arcs = append(arcs, route[i])
+		}
+		firstBest = best
+		best = step.back[best]
+	}
+
+	path := make([]MatchedEdge, 0, len(arcs))
+	for i := len(arcs) - 1; i >= 0; i-- {
+		if i < len(arcs)-1 && arcs[i] == arcs[i+1] {
+			continue
+		}
+		path = append(path, NewMatchedEdge(m.graph.GetEdge(arcs[i].GetEdgeId()), arcs[i].IsReversed()))
+	}
+
+	// the path starts at the projection of the first observation and ends at the projection of the last observation
+	first := steps[0].candidates[firstBest]
+	path[0].startFraction = first.GetFraction()
+	lastCand := last.candidates[lastBest]
+	path[len(path)-1].endFraction = math.Max(path[len(path)-1].startFraction, lastCand.GetFraction())
+	return path
+}
@@ -0,0 +1,175 @@
+package mapmatching
+
+import (
+	"testing"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testNode struct {
+	lon, lat float64
+}
+
+// newTestEdges. arc = {from node, to node, osm way id}, arcs of the same osm way must be in the way node order
+func newTestEdges(nodes []testNode, arcs [][3]int64, bidirectional bool) []datastructure.Edge {
+	edges := make([]datastructure.Edge, 0, len(arcs))
+	wayOffsets := make(map[int64]float64)
+	for i, arc := range arcs {
+		from, to := nodes[arc[0]], nodes[arc[1]]
+		length := geo.CalculateHaversineDistance(from.lon, from.lat, to.lon, to.lat)
+		edges = append(edges, datastructure.NewEdge(from.lat, from.lon, to.lat, to.lon, uint32(i),
+			uint32(arc[0]), uint32(arc[1]), bidirectional, datastructure.FORWARD, arc[2], "primary", 50, 0, length, nil,
+			wayOffsets[arc[2]]))
+		wayOffsets[arc[2]] += length
+	}
+	return edges
+}
+
+func TestHMMMapMatchStaysOnConnectedRoad(t *testing.T) {
+	const lat = -7.78
+	nodes := []testNode{
+		// main road (osm way 1)
+		{110.3600, lat}, {110.3625, lat}, {110.3650, lat}, {110.3675, lat}, {110.3700, lat},
+		// parallel road ~20 meters north of the main road (osm way 2), only connected to the main road far away
+		{110.3610, lat + 0.00018}, {110.3690, lat + 0.00018},
+		// connector of the parallel road (osm way 3)
+		{110.3610, lat + 0.01},
+	}
+	arcs := [][3]int64{
+		{0, 1, 1}, {1, 2, 1}, {2, 3, 1}, {3, 4, 1},
+		{5, 6, 2},
+		{7, 5, 3},
+	}
+	edges := newTestEdges(nodes, arcs, true)
+	graph := datastructure.NewGraph(edges, len(nodes))
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+
+	matcher := NewHMMMapMatcher(graph, rt, DEFAULT_SEARCH_RADIUS, DEFAULT_SIGMA_Z, DEFAULT_BETA)
+
+	line := []datastructure.Coordinate{
+		datastructure.NewCoordinate(110.3605, lat+0.00002),
+		datastructure.NewCoordinate(110.3630, lat+0.00003),
+		// nearer to the parallel road, but there is no short route to it
+		datastructure.NewCoordinate(110.3655, lat+0.00011),
+		datastructure.NewCoordinate(110.3680, lat+0.00002),
+		datastructure.NewCoordinate(110.3698, lat),
+	}
+	result := matcher.MapMatch(line)
+
+	assert.Equal(t, 1, len(result.GetPaths()))
+	path := result.GetPaths()[0]
+	assert.Equal(t, 4, len(path))
+	for i := range path {
+		edge := path[i].GetEdge()
+		assert.Equal(t, int64(1), edge.GetOsmWayId())
+		assert.Equal(t, uint32(i), edge.GetEdgeId())
+		assert.Equal(t, datastructure.FORWARD, path[i].GetDirection())
+	}
+	for _, point := range result.GetPoints() {
+		assert.True(t, point.IsMatched())
+		edge := point.GetCandidate().GetEdge()
+		assert.Equal(t, int64(1), edge.GetOsmWayId())
+	}
+}
+
+func TestHMMMapMatchUnmatchedPoint(t *testing.T) {
+	const lat = -7.78
+	nodes := []testNode{{110.3600, lat}, {110.3650, lat}}
+	edges := newTestEdges(nodes, [][3]int64{{0, 1, 1}}, true)
+	graph := datastructure.NewGraph(edges, len(nodes))
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+
+	matcher := NewHMMMapMatcher(graph, rt, DEFAULT_SEARCH_RADIUS, DEFAULT_SIGMA_Z, DEFAULT_BETA)
+	result := matcher.MapMatch([]datastructure.Coordinate{
+		datastructure.NewCoordinate(110.3610, lat),
+		datastructure.NewCoordinate(110.3620, lat+0.01),
+		datastructure.NewCoordinate(110.3640, lat),
+	})
+
+	assert.True(t, result.GetPoints()[0].IsMatched())
+	assert.False(t, result.GetPoints()[1].IsMatched())
+	assert.True(t, result.GetPoints()[2].IsMatched())
+	assert.Equal(t, 1, len(result.GetEdges()))
+}
+
+func TestHMMMapMatchDirection(t *testing.T) {
+	const lat = -7.78
+	nodes := []testNode{{110.3600, lat}, {110.3625, lat}, {110.3650, lat}}
+	arcs := [][3]int64{{0, 1, 1}, {1, 2, 1}}
+
+	// jam travels westward, against the osm way node order
+	line := []datastructure.Coordinate{
+		datastructure.NewCoordinate(110.3645, lat+0.00002),
+		datastructure.NewCoordinate(110.3630, lat+0.00002),
+		datastructure.NewCoordinate(110.3605, lat+0.00002),
+	}
+
+	edges := newTestEdges(nodes, arcs, true)
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+	matcher := NewHMMMapMatcher(datastructure.NewGraph(edges, len(nodes)), rt, DEFAULT_SEARCH_RADIUS,
+		DEFAULT_SIGMA_Z, DEFAULT_BETA)
+
+	result := matcher.MapMatch(line)
+	assert.Equal(t, 1, len(result.GetPaths()))
+	path := result.GetPaths()[0]
+	assert.Equal(t, 2, len(path))
+	for i, edgeId := range []uint32{1, 0} {
+		edge := path[i].GetEdge()
+		assert.Equal(t, edgeId, edge.GetEdgeId())
+		assert.True(t, path[i].IsReversed())
+		assert.Equal(t, datastructure.BACKWARD, path[i].GetDirection())
+	}
+
+	// oneway road (forward only), the jam can't be matched against the travel direction
+	edges = newTestEdges(nodes, arcs, false)
+	rt = spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+	matcher = NewHMMMapMatcher(datastructure.NewGraph(edges, len(nodes)), rt, DEFAULT_SEARCH_RADIUS,
+		DEFAULT_SIGMA_Z, DEFAULT_BETA)
+
+	result = matcher.MapMatch(line)
+	for _, edge := range result.GetEdges() {
+		assert.Equal(t, datastructure.FORWARD, edge.GetDirection())
+	}
+}
+
+func TestHMMMapMatchWayRange(t *testing.T) {
+	const lat = -7.78
+	nodes := []testNode{{110.3600, lat}, {110.3625, lat}, {110.3650, lat}}
+	edges := newTestEdges(nodes, [][3]int64{{0, 1, 1}, {1, 2, 1}}, true)
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+	matcher := NewHMMMapMatcher(datastructure.NewGraph(edges, len(nodes)), rt, DEFAULT_SEARCH_RADIUS,
+		DEFAULT_SIGMA_Z, DEFAULT_BETA)
+
+	expectedStart := geo.CalculateHaversineDistance(110.3600, lat, 110.3610, lat) * 1000
+	expectedEnd := geo.CalculateHaversineDistance(110.3600, lat, 110.3640, lat) * 1000
+
+	eastward := []datastructure.Coordinate{
+		datastructure.NewCoordinate(110.3610, lat),
+		datastructure.NewCoordinate(110.3630, lat),
+		datastructure.NewCoordinate(110.3640, lat),
+	}
+	westward := []datastructure.Coordinate{eastward[2], eastward[1], eastward[0]}
+
+	for _, line := range [][]datastructure.Coordinate{eastward, westward} {
+		path := matcher.MapMatch(line).GetEdges()
+		assert.Equal(t, 2, len(path))
+
+		start, _ := path[0].GetWayRange()
+		_, end := path[len(path)-1].GetWayRange()
+		if path[0].GetDirection() == datastructure.BACKWARD {
+			_, end = path[0].GetWayRange()
+			start, _ = path[len(path)-1].GetWayRange()
+		}
+		assert.InDelta(t, expectedStart, start, 1)
+		assert.InDelta(t, expectedEnd, end, 1)
+	}
+}
@@ -0,0 +1,183 @@
+package mapmatching
+
+import (
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// Candidate. projection of an observation onto an edge near the observation, traversed in one travel direction
+type Candidate struct {
+	edge             datastructure.Edge
+	reversed         bool    // the edge is traversed from its to node to its from node
+	dist             float64 // distance from the observation to the projection (m)
+	fraction         float64 // position of the projection along the travel direction, 0 = start of the edge, 1 = end of the edge
+	projLon, projLat float64
+	bearing          float64 // bearing (in the travel direction) of the edge segment that contains the projection
+}
+
+func NewCandidate(edge datastructure.Edge, reversed bool, dist, fraction, projLon, projLat, bearing float64) Candidate {
+	return Candidate{
+		edge:     edge,
+		reversed: reversed,
+		dist:     dist,
+		fraction: fraction,
+		projLon:  projLon,
+		projLat:  projLat,
+		bearing:  bearing,
+	}
+}
+
+func (c Candidate) GetEdge() datastructure.Edge {
+	return c.edge
+}
+
+func (c Candidate) IsReversed() bool {
+	return c.reversed
+}
+
+// GetDirection. travel direction of the candidate relative to the osm way node order
+func (c Candidate) GetDirection() datastructure.Direction {
+	if c.reversed {
+		return c.edge.GetDirection().Reverse()
+	}
+	return c.edge.GetDirection()
+}
+
+// GetDistance. distance from the observation to the projection, in meters
+func (c Candidate) GetDistance() float64 {
+	return c.dist
+}
+
+// GetFraction. position of the projection along the travel direction of the candidate
+func (c Candidate) GetFraction() float64 {
+	return c.fraction
+}
+
+func (c Candidate) GetProjection() (float64, float64) {
+	return c.projLon, c.projLat
+}
+
+func (c Candidate) arc() datastructure.Arc {
+	return datastructure.NewArc(c.edge.GetEdgeId(), c.reversed)
+}
+
+// travelStartNode. node where the travel along the candidate edge starts
+func (c Candidate) travelStartNode() uint32 {
+	if c.reversed {
+		return c.edge.GetToNodeId()
+	}
+	return c.edge.GetFromNodeId()
+}
+
+// travelEndNode. node where the travel along the candidate edge ends
+func (c Candidate) travelEndNode() uint32 {
+	if c.reversed {
+		return c.edge.GetFromNodeId()
+	}
+	return c.edge.GetToNodeId()
+}
+
+// distanceFromStart. distance (m) along the edge from the travel start node to the projection
+func (c Candidate) distanceFromStart() float64 {
+	return c.fraction * c.edge.GetLength() * 1000
+}
+
+// distanceToEnd. distance (m) along the edge from the projection to the travel end node
+func (c Candidate) distanceToEnd() float64 {
+	return (1 - c.fraction) * c.edge.GetLength() * 1000
+}
+
+// GetBearing. bearing (in the travel direction) of the edge segment that contains the projection
+func (c Candidate) GetBearing() float64 {
+	return c.bearing
+}
+
+// MatchedEdge. edge of a matched path, its travel direction and the part of the edge covered by the path.
+// only the first and the last edge of a path are partially covered
+type MatchedEdge struct {
+	edge          datastructure.Edge
+	reversed      bool
+	startFraction float64 // start of the covered part, along the travel direction
+	endFraction   float64 // end of the covered part, along the travel direction
+}
+
+func NewMatchedEdge(edge datastructure.Edge, reversed bool) MatchedEdge {
+	return MatchedEdge{edge: edge, reversed: reversed, startFraction: 0, endFraction: 1}
+}
+
+func (me MatchedEdge) GetEdge() datastructure.Edge {
+	return me.edge
+}
+
+func (me MatchedEdge) IsReversed() bool {
+	return me.reversed
+}
+
+// GetDirection. travel direction relative to the osm way node order
+func (me MatchedEdge) GetDirection() datastructure.Direction {
+	if me.reversed {
+		return me.edge.GetDirection().Reverse()
+	}
+	return me.edge.GetDirection()
+}
+
+// GetFractions. start and end of the part of the edge covered by the path, along the travel direction (0 = travel start node)
+func (me MatchedEdge) GetFractions() (float64, float64) {
+	return me.startFraction, me.endFraction
+}
+
+// GetWayRange. start and end (m) of the covered part of the edge, measured along the osm way from the first node of the way.
+// start <= end, for backward travel the jam moves from end to start
+func (me MatchedEdge) GetWayRange() (float64, float64) {
+	direction := me.GetDirection()
+	start := me.edge.WayPosition(me.startFraction, direction) * 1000
+	end := me.edge.WayPosition(me.endFraction, direction) * 1000
+	if start > end {
+		start, end = end, start
+	}
+	return start, end
+}
+
+// MatchedPoint. observation (line point) and the candidate chosen by the map matcher
+type MatchedPoint struct {
+	lon, lat  float64
+	candidate Candidate
+	matched   bool
+}
+
+func (mp MatchedPoint) GetLonLat() (float64, float64) {
+	return mp.lon, mp.lat
+}
+
+func (mp MatchedPoint) GetCandidate() Candidate {
+	return mp.candidate
+}
+
+// IsMatched. false if there is no candidate edge within the search radius of the observation
+func (mp MatchedPoint) IsMatched() bool {
+	return mp.matched
+}
+
+type MatchResult struct {
+	points []MatchedPoint
+	paths  [][]MatchedEdge
+}
+
+// GetPoints. matched point of every observation, in the observation order
+func (mr MatchResult) GetPoints() []MatchedPoint {
+	return mr.points
+}
+
+// GetPaths. every path is a continuous, connected sequence of edges in travel order. the line is split into more than one path
+// when there is no route between the candidates of two consecutive observations
+func (mr MatchResult) GetPaths() [][]MatchedEdge {
+	return mr.paths
+}
+
+// GetEdges. edges of all paths
+func (mr MatchResult) GetEdges() []MatchedEdge {
+	edges := make([]MatchedEdge, 0)
+	for _, path := range mr.paths {
+		edges = append(edges, path...)
+	}
+	return edges
+}
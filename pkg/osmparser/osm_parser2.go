@@ -52,6 +52,7 @@ type OsmParser struct {
 	restrictions      map[int64][]restriction // wayId -> list of restrictions
 	ways              map[int64]osmWay
 	streetNameIdMap   *util.IDMap
+	graph             *datastructure.Graph
 }
 
 func NewOSMParserV2() *OsmParser {
@@ -80,6 +81,11 @@ func (o *OsmParser) GetWayMap() map[int64]datastructure.Way {
 	return o.wayMap
 }
 
+// GetGraph. road graph built from the parsed edges, only available after Parse
+func (o *OsmParser) GetGraph() *datastructure.Graph {
+	return o.graph
+}
+
 func (p *OsmParser) Parse(mapFile string, logger *zap.Logger) ([]datastructure.Edge, map[int64]float64) {
 
 	f, err := os.Open(mapFile)
@@ -113,17 +119,7 @@ func (p *OsmParser) Parse(mapFile string, logger *zap.Logger) ([]datastructure.E
 
 				countWays++
 
-				for i, node := range way.Nodes {
-					if _, ok := p.wayNodeMap[int64(node.ID)]; !ok {
-						if i == 0 || i == len(way.Nodes)-1 {
-							p.wayNodeMap[int64(node.ID)] = END_NODE
-						} else {
-							p.wayNodeMap[int64(node.ID)] = BETWEEN_NODE
-						}
-					} else {
-						p.wayNodeMap[int64(node.ID)] = JUNCTION_NODE
-					}
-				}
+				p.registerWayNodes(way)
 			}
 		case osm.TypeNode:
 			{
@@ -173,7 +169,8 @@ func (p *OsmParser) Parse(mapFile string, logger *zap.Logger) ([]datastructure.E
 
 				wayExtraInfoData := wayExtraInfo{}
 				okvf, okmvf, okvb, okmvb := getReversedOneWay(way)
-				if val := way.Tags.Find("oneway"); val == "yes" || val == "-1" || okvf || okmvf || okvb || okmvb {
+				if val := way.Tags.Find("oneway"); val == "yes" || val == "-1" || okvf || okmvf || okvb || okmvb ||
+					way.Tags.Find("junction") == "roundabout" {
 					wayExtraInfoData.oneWay = true
 				}
 
@@ -254,6 +251,8 @@ func (p *OsmParser) Parse(mapFile string, logger *zap.Logger) ([]datastructure.E
 		p.wayMap[way.id] = datastructure.NewWay(way.id, wCoords)
 	}
 
+	p.graph = datastructure.NewGraph(scannedEdges, len(p.nodeIDMap))
+
 	return scannedEdges, waySpeed
 }
 
@@ -282,7 +281,8 @@ func (p *OsmParser) processWay(way *osm.Way,
 
 	wayExtraInfoData := wayExtraInfo{}
 	okvf, okmvf, okvb, okmvb := getReversedOneWay(way)
-	if val := way.Tags.Find("oneway"); val == "yes" || val == "-1" || okvf || okmvf || okvb || okmvb {
+	if val := way.Tags.Find("oneway"); val == "yes" || val == "-1" || okvf || okmvf || okvb || okmvb ||
+		way.Tags.Find("junction") == "roundabout" {
 		wayExtraInfoData.oneWay = true
 	}
 
@@ -363,6 +363,7 @@ func (p *OsmParser) processWay(way *osm.Way,
 		maxSpeed = 30
 	}
 
+	wayOffset := 0.0
 	waySegment := []node{}
 	for _, wayNode := range way.Nodes {
 		nodeCoord := p.acceptedNodeMap[int64(wayNode.ID)]
@@ -374,7 +375,7 @@ func (p *OsmParser) processWay(way *osm.Way,
 
 			waySegment = append(waySegment, nodeData)
 			p.processSegment(waySegment, tempMap, maxSpeed, wayExtraInfoData,
-				edgeSet, scannedEdges, int64(way.ID))
+				edgeSet, scannedEdges, int64(way.ID), &wayOffset)
 			waySegment = []node{}
 
 			waySegment = append(waySegment, nodeData)
@@ -385,7 +386,7 @@ func (p *OsmParser) processWay(way *osm.Way,
 
 	}
 	if len(waySegment) > 1 {
-		p.processSegment(waySegment, tempMap, maxSpeed, wayExtraInfoData, edgeSet, scannedEdges, int64(way.ID))
+		p.processSegment(waySegment, tempMap, maxSpeed, wayExtraInfoData, edgeSet, scannedEdges, int64(way.ID), &wayOffset)
 	}
 
 	return nil
@@ -406,23 +407,27 @@ func getReversedOneWay(way *osm.Way) (bool, bool, bool, bool) {
 	return isRestricted(vehicleForward), isRestricted(motorVehicleForward), isRestricted(vehicleBackward), isRestricted(motorVehicleBackward)
 }
 
+// processSegment. wayOffset is the distance (km) along the osm way to the first node of the segment, segments of a way
+// must be processed in the osm way node order
 func (p *OsmParser) processSegment(segment []node, tempMap map[string]string, speed float64,
-	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64) {
+	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64,
+	wayOffset *float64) {
 
 	if len(segment) == 2 && segment[0].id == segment[1].id {
 		// skip
 		return
 	} else if len(segment) > 2 && segment[0].id == segment[len(segment)-1].id {
 		// loop
-		p.processSegment2(segment[0:len(segment)-1], tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id)
-		p.processSegment2(segment[len(segment)-2:], tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id)
+		p.processSegment2(segment[0:len(segment)-1], tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id, wayOffset)
+		p.processSegment2(segment[len(segment)-2:], tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id, wayOffset)
 	} else {
-		p.processSegment2(segment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id)
+		p.processSegment2(segment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id, wayOffset)
 	}
 }
 
 func (p *OsmParser) processSegment2(segment []node, tempMap map[string]string, speed float64,
-	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64) {
+	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64,
+	wayOffset *float64) {
 	waySegment := []node{}
 	for i := 0; i < len(segment); i++ {
 		nodeData := segment[i]
@@ -432,7 +437,7 @@ func (p *OsmParser) processSegment2(segment []node, tempMap map[string]string, s
 				// if current node is a barrier
 				// add the barrier node and process the segment (add edge)
 				waySegment = append(waySegment, nodeData)
-				p.addEdge(waySegment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id)
+				p.addEdge(waySegment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id, wayOffset)
 				waySegment = []node{}
 			}
 			// copy the barrier node but with different id so that previous edge (with barrier) not connected with the new edge
@@ -445,7 +450,7 @@ func (p *OsmParser) processSegment2(segment []node, tempMap map[string]string, s
 		}
 	}
 	if len(waySegment) > 1 {
-		p.addEdge(waySegment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id)
+		p.addEdge(waySegment, tempMap, speed, wayExtraInfoData, edgeSet, scannedEdges, id, wayOffset)
 	}
 }
 
@@ -467,7 +472,15 @@ func (p *OsmParser) copyNode(nodeData node) node {
 }
 
 func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed float64,
-	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64) {
+	wayExtraInfoData wayExtraInfo, edgeSet map[uint32]map[uint32]struct{}, scannedEdges *[]datastructure.Edge, id int64,
+	wayOffset *float64) {
+	// advance the way offset even if the segment is skipped, so the offsets of the next edges of the way stay correct
+	edgeWayOffset := *wayOffset
+	for i := 1; i < len(segment); i++ {
+		*wayOffset += geo.CalculateHaversineDistance(segment[i-1].coord.lon, segment[i-1].coord.lat,
+			segment[i].coord.lon, segment[i].coord.lat)
+	}
+
 	from := segment[0]
 
 	to := segment[len(segment)-1]
@@ -486,11 +499,13 @@ func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed flo
 	}
 
 	distance := 0.0
+	geometry := make([]datastructure.Coordinate, 0, len(segment))
 	for i := 0; i < len(segment); i++ {
+		geometry = append(geometry, datastructure.NewCoordinate(segment[i].coord.lon, segment[i].coord.lat))
 		if i != 0 && i != len(segment)-1 && p.nodeTag[int64(segment[i].id)][p.tagStringIdMap.GetID(TRAFFIC_LIGHT)] == 1 {
 
-			distToFromNode := geo.CalculateHaversineDistance(from.coord.lat, from.coord.lon, segment[i].coord.lat, segment[i].coord.lon)
-			distToToNode := geo.CalculateHaversineDistance(to.coord.lat, to.coord.lon, segment[i].coord.lat, segment[i].coord.lon)
+			distToFromNode := geo.CalculateHaversineDistance(from.coord.lon, from.coord.lat, segment[i].coord.lon, segment[i].coord.lat)
+			distToToNode := geo.CalculateHaversineDistance(to.coord.lon, to.coord.lat, segment[i].coord.lon, segment[i].coord.lat)
 			if distToFromNode < distToToNode {
 				if _, ok := p.nodeTag[int64(segment[0].id)]; !ok {
 					p.nodeTag[int64(segment[0].id)] = make(map[int]int)
@@ -505,7 +520,7 @@ func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed flo
 		}
 
 		if i > 0 {
-			distance += geo.CalculateHaversineDistance(segment[i-1].coord.lat, segment[i-1].coord.lon, segment[i].coord.lat, segment[i].coord.lon)
+			distance += geo.CalculateHaversineDistance(segment[i-1].coord.lon, segment[i-1].coord.lat, segment[i].coord.lon, segment[i].coord.lat)
 		}
 	}
 
@@ -529,11 +544,17 @@ func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed flo
 				from.coord.lat, from.coord.lon,
 				to.coord.lat, to.coord.lon,
 				uint32(len(*scannedEdges)),
+				p.nodeIDMap[from.id],
+				p.nodeIDMap[to.id],
 				false,
+				datastructure.FORWARD,
 				id,
 				wayExtraInfoData.highwayType,
 				speed,
 				p.streetNameIdMap.GetID(tempMap[STREET_NAME]),
+				distance,
+				geometry,
+				edgeWayOffset,
 			))
 
 		} else {
@@ -543,15 +564,22 @@ func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed flo
 			}
 			edgeSet[p.nodeIDMap[to.id]][p.nodeIDMap[from.id]] = struct{}{}
 
+			// oneway against the osm way node order, the edge goes from the last node to the first node
 			*scannedEdges = append(*scannedEdges, datastructure.NewEdge(
-				from.coord.lat, from.coord.lon,
 				to.coord.lat, to.coord.lon,
+				from.coord.lat, from.coord.lon,
 				uint32(len(*scannedEdges)),
+				p.nodeIDMap[to.id],
+				p.nodeIDMap[from.id],
 				false,
+				datastructure.BACKWARD,
 				id,
 				wayExtraInfoData.highwayType,
 				speed,
 				p.streetNameIdMap.GetID(tempMap[STREET_NAME]),
+				distance,
+				util.ReverseG(geometry),
+				edgeWayOffset,
 			))
 		}
 	} else {
@@ -565,11 +593,17 @@ func (p *OsmParser) addEdge(segment []node, tempMap map[string]string, speed flo
 			from.coord.lat, from.coord.lon,
 			to.coord.lat, to.coord.lon,
 			uint32(len(*scannedEdges)),
-			false,
+			p.nodeIDMap[from.id],
+			p.nodeIDMap[to.id],
+			true,
+			datastructure.FORWARD,
 			id,
 			wayExtraInfoData.highwayType,
 			speed,
 			p.streetNameIdMap.GetID(tempMap[STREET_NAME]),
+			distance,
+			geometry,
+			edgeWayOffset,
 		))
 
 	}
@@ -616,6 +650,22 @@ func roadTypeMaxSpeed2(roadType string) float64 {
 	}
 }
 
+// registerWayNodes. marks the nodes of an accepted osm way as end, between or junction node (node shared by several ways
+// or visited twice by a closed way), ways are split into edges at the junction nodes
+func (p *OsmParser) registerWayNodes(way *osm.Way) {
+	for i, node := range way.Nodes {
+		if _, ok := p.wayNodeMap[int64(node.ID)]; !ok {
+			if i == 0 || i == len(way.Nodes)-1 {
+				p.wayNodeMap[int64(node.ID)] = END_NODE
+			} else {
+				p.wayNodeMap[int64(node.ID)] = BETWEEN_NODE
+			}
+		} else {
+			p.wayNodeMap[int64(node.ID)] = JUNCTION_NODE
+		}
+	}
+}
+
 func (p *OsmParser) isJunctionNode(nodeID int64) bool {
 	return p.wayNodeMap[int64(nodeID)] == JUNCTION_NODE
 }
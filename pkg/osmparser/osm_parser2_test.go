@@ -0,0 +1,137 @@
+package osmparser
+
+import (
+	"testing"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/paulmach/osm"
+	"github.com/stretchr/testify/assert"
+)
+
+// 0.001 degree of longitude at latitude -7.79 (km)
+const LON_STEP_KM = 0.11019
+
+// parseTestWays. builds the edges of in-memory osm ways like the two passes of Parse
+func parseTestWays(t *testing.T, nodes map[int64]nodeCoord, ways []*osm.Way) ([]datastructure.Edge, *OsmParser) {
+	p := NewOSMParserV2()
+	for _, way := range ways {
+		p.registerWayNodes(way)
+	}
+	for id, coord := range nodes {
+		p.acceptedNodeMap[id] = coord
+		p.maxNodeID = max(p.maxNodeID, id)
+	}
+
+	edgeSet := make(map[uint32]map[uint32]struct{})
+	scannedEdges := make([]datastructure.Edge, 0)
+	for _, way := range ways {
+		assert.Nil(t, p.processWay(way, make(map[string][2]bool), edgeSet, &scannedEdges))
+	}
+	return scannedEdges, p
+}
+
+func newTestWay(id int64, nodeIds []int64, tags ...osm.Tag) *osm.Way {
+	way := &osm.Way{ID: osm.WayID(id), Tags: append(osm.Tags{{Key: "highway", Value: "primary"}}, tags...)}
+	for _, nodeId := range nodeIds {
+		way.Nodes = append(way.Nodes, osm.WayNode{ID: osm.NodeID(nodeId)})
+	}
+	return way
+}
+
+func osmNodeIds(p *OsmParser, e datastructure.Edge) [2]int64 {
+	return [2]int64{p.nodeToOsmId[e.GetFromNodeId()], p.nodeToOsmId[e.GetToNodeId()]}
+}
+
+func TestProcessWay(t *testing.T) {
+	// nodes along latitude -7.79, 0.001 degree of longitude apart
+	nodes := map[int64]nodeCoord{
+		1: {-7.79, 110.360},
+		2: {-7.79, 110.361},
+		3: {-7.79, 110.362},
+		4: {-7.79, 110.363},
+	}
+
+	t.Run("oneway", func(t *testing.T) {
+		// node 2 is a junction with way 11, way 10 is split into 2 edges
+		edges, p := parseTestWays(t, nodes, []*osm.Way{
+			newTestWay(10, []int64{1, 2, 4}, osm.Tag{Key: "oneway", Value: "yes"}),
+			newTestWay(11, []int64{2, 3}),
+		})
+
+		assert.Equal(t, 3, len(edges))
+		assert.Equal(t, [2]int64{1, 2}, osmNodeIds(p, edges[0]))
+		assert.Equal(t, [2]int64{2, 4}, osmNodeIds(p, edges[1]))
+		for _, e := range edges[:2] {
+			assert.Equal(t, datastructure.FORWARD, e.GetDirection())
+			assert.False(t, e.IsBidirectional())
+		}
+		assert.InDelta(t, LON_STEP_KM, edges[0].GetLength(), 1e-4)
+		assert.InDelta(t, 2*LON_STEP_KM, edges[1].GetLength(), 1e-4)
+		assert.Equal(t, 0.0, edges[0].GetWayOffset())
+		assert.InDelta(t, LON_STEP_KM, edges[1].GetWayOffset(), 1e-4)
+	})
+
+	t.Run("oneway against the way node order", func(t *testing.T) {
+		edges, p := parseTestWays(t, nodes, []*osm.Way{
+			newTestWay(10, []int64{1, 2, 4}, osm.Tag{Key: "oneway", Value: "-1"}),
+			newTestWay(11, []int64{2, 3}),
+		})
+
+		assert.Equal(t, 3, len(edges))
+		// the edges go from the last to the first way node, the way offset is still measured from the first way node
+		assert.Equal(t, [2]int64{2, 1}, osmNodeIds(p, edges[0]))
+		assert.Equal(t, [2]int64{4, 2}, osmNodeIds(p, edges[1]))
+		for _, e := range edges[:2] {
+			assert.Equal(t, datastructure.BACKWARD, e.GetDirection())
+			assert.False(t, e.IsBidirectional())
+		}
+		fromLon, _ := edges[1].GetFromLonLat()
+		toLon, _ := edges[1].GetToLonLat()
+		assert.Equal(t, [2]float64{110.363, 110.361}, [2]float64{fromLon, toLon})
+		geometry := edges[1].GetGeometry()
+		firstLon, _ := geometry[0].GetLonLat()
+		lastLon, _ := geometry[len(geometry)-1].GetLonLat()
+		assert.Equal(t, [2]float64{110.363, 110.361}, [2]float64{firstLon, lastLon})
+		assert.InDelta(t, 2*LON_STEP_KM, edges[1].GetLength(), 1e-4)
+		assert.Equal(t, 0.0, edges[0].GetWayOffset())
+		assert.InDelta(t, LON_STEP_KM, edges[1].GetWayOffset(), 1e-4)
+	})
+
+	t.Run("two-way", func(t *testing.T) {
+		edges, p := parseTestWays(t, nodes, []*osm.Way{
+			newTestWay(10, []int64{1, 2, 3}),
+		})
+
+		assert.Equal(t, 1, len(edges))
+		assert.Equal(t, [2]int64{1, 3}, osmNodeIds(p, edges[0]))
+		assert.Equal(t, datastructure.FORWARD, edges[0].GetDirection())
+		assert.True(t, edges[0].IsBidirectional())
+		assert.InDelta(t, 2*LON_STEP_KM, edges[0].GetLength(), 1e-4)
+		assert.Equal(t, 0.0, edges[0].GetWayOffset())
+	})
+
+	t.Run("closed way", func(t *testing.T) {
+		loopNodes := map[int64]nodeCoord{
+			1: {-7.79, 110.360},
+			2: {-7.79, 110.361},
+			3: {-7.789, 110.361},
+		}
+		// the first node is visited twice, the loop is split before its last node
+		edges, p := parseTestWays(t, loopNodes, []*osm.Way{
+			newTestWay(10, []int64{1, 2, 3, 1}, osm.Tag{Key: "junction", Value: "roundabout"}),
+		})
+
+		assert.Equal(t, 2, len(edges))
+		assert.Equal(t, [2]int64{1, 3}, osmNodeIds(p, edges[0]))
+		assert.Equal(t, [2]int64{3, 1}, osmNodeIds(p, edges[1]))
+		for _, e := range edges {
+			assert.Equal(t, datastructure.FORWARD, e.GetDirection())
+			assert.False(t, e.IsBidirectional())
+		}
+		// 0.001 degree of latitude is ~0.1112 km
+		assert.InDelta(t, LON_STEP_KM+0.1112, edges[0].GetLength(), 1e-3)
+		assert.Equal(t, 0.0, edges[0].GetWayOffset())
+		assert.InDelta(t, edges[0].GetLength(), edges[1].GetWayOffset(), 1e-9)
+		assert.InDelta(t, 0.1572, edges[1].GetLength(), 1e-3)
+	})
+}
@@ -0,0 +1,52 @@
+package region
+
+import (
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// GetHighway. highway class of the osm way (see Edge.GetHighwayTypeString)
+func (n *RoadNetwork) GetHighway(wayId int64) string {
+	return n.highways[wayId]
+}
+
+// WayIdsInBoundingBox. osm ways with an edge intersecting the bounding box, searched in the rtree of the road network
+func (n *RoadNetwork) WayIdsInBoundingBox(bb datastructure.BoundingBox) map[int64]struct{} {
+	wayIds := make(map[int64]struct{})
+	for _, edge := range n.rt.SearchBoundingBox(bb) {
+		wayIds[edge.GetOsmWayId()] = struct{}{}
+	}
+	return wayIds
+}
+
+// FilterTraffic. traffic matching the filter, the bounding box is searched in the rtree of the road network
+func (n *RoadNetwork) FilterTraffic(traffic []datastructure.WayTraffic, filter datastructure.TrafficFilter) []datastructure.WayTraffic {
+	if filter.IsEmpty() {
+		return traffic
+	}
+
+	var inBoundingBox map[int64]struct{}
+	if bb, ok := filter.GetBoundingBox(); ok {
+		inBoundingBox = n.WayIdsInBoundingBox(bb)
+	}
+
+	filtered := make([]datastructure.WayTraffic, 0)
+	for _, wt := range traffic {
+		wayId := wt.GetWay().GetID()
+		if !filter.HasWayId(wayId) || !filter.HasHighway(n.GetHighway(wayId)) {
+			continue
+		}
+		if inBoundingBox != nil {
+			if _, ok := inBoundingBox[wayId]; !ok {
+				continue
+			}
+		}
+		if filter.GetMinCongestion() > 0 {
+			ratio, ok := wt.GetCongestionRatio()
+			if !ok || ratio < filter.GetMinCongestion() {
+				continue
+			}
+		}
+		filtered = append(filtered, wt)
+	}
+	return filtered
+}
@@ -0,0 +1,60 @@
+package region
+
+import (
+	"testing"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestNetwork() *RoadNetwork {
+	edges := []datastructure.Edge{
+		// osm way 1 (primary) in the west, osm way 2 (secondary) in the east
+		datastructure.NewEdge(-7.78, 110.36, -7.78, 110.37, 0, 0, 1, true, datastructure.FORWARD, 1, "primary", 50, 0,
+			1.1, nil, 0),
+		datastructure.NewEdge(-7.78, 110.40, -7.78, 110.41, 1, 2, 3, true, datastructure.FORWARD, 2, "secondary", 40, 0,
+			1.1, nil, 0),
+	}
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+	return newRoadNetwork(nil, edges, map[int64]float64{1: 50, 2: 40}, rt, nil)
+}
+
+func filteredWayIds(traffic []datastructure.WayTraffic) []int64 {
+	ids := make([]int64, 0, len(traffic))
+	for _, wt := range traffic {
+		ids = append(ids, wt.GetWay().GetID())
+	}
+	return ids
+}
+
+func TestFilterTraffic(t *testing.T) {
+	network := newTestNetwork()
+	traffic := []datastructure.WayTraffic{
+		datastructure.NewWayTraffic(datastructure.NewWay(1, nil), datastructure.FORWARD, 10, 50, "", "", "", nil),
+		datastructure.NewWayTraffic(datastructure.NewWay(2, nil), datastructure.FORWARD, 30, 40, "", "", "", nil),
+		// free flow speed unknown
+		datastructure.NewWayTraffic(datastructure.NewWay(3, nil), datastructure.FORWARD, 5, 0, "", "", "", nil),
+	}
+
+	assert.Equal(t, []int64{1, 2, 3}, filteredWayIds(network.FilterTraffic(traffic, datastructure.NewTrafficFilter())))
+
+	west := datastructure.NewBoundingBox(110.355, -7.79, 110.375, -7.77)
+	assert.Equal(t, []int64{1}, filteredWayIds(network.FilterTraffic(traffic,
+		datastructure.NewTrafficFilter().WithBoundingBox(west))))
+
+	assert.Equal(t, []int64{2, 3}, filteredWayIds(network.FilterTraffic(traffic,
+		datastructure.NewTrafficFilter().WithWayIds([]int64{2, 3}))))
+
+	assert.Equal(t, []int64{2}, filteredWayIds(network.FilterTraffic(traffic,
+		datastructure.NewTrafficFilter().WithHighways([]string{"secondary", "tertiary"}))))
+
+	// congestion ratio of way 1 = 0.8, way 2 = 0.25
+	assert.Equal(t, []int64{1}, filteredWayIds(network.FilterTraffic(traffic,
+		datastructure.NewTrafficFilter().WithMinCongestion(0.5))))
+
+	assert.Empty(t, network.FilterTraffic(traffic,
+		datastructure.NewTrafficFilter().WithBoundingBox(west).WithHighways([]string{"secondary"})))
+}
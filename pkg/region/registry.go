@@ -0,0 +1,183 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/config"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/mapmatching"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/osmparser"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/scraper"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
+	"go.uber.org/zap"
+)
+
+// RoadNetwork. parsed osm road network with the spatial index & map matcher
+type RoadNetwork struct {
+	osmParser *osmparser.OsmParser
+	edges     []datastructure.Edge
+	waySpeed  map[int64]float64
+	highways  map[int64]string // highway class of every osm way
+	rt        *spatialindex.Rtree
+	matcher   *mapmatching.HMMMapMatcher
+}
+
+// LoadRoadNetwork. parse the osm pbf file, build the rtree and the map matcher
+func LoadRoadNetwork(osmFile string, cfg *config.Config, log *zap.Logger) *RoadNetwork {
+	osmParser := osmparser.NewOSMParserV2()
+	edges, waySpeed := osmParser.Parse(osmFile, log)
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, cfg.Index.RtreeBufferKm, log)
+	matcher := mapmatching.NewHMMMapMatcher(osmParser.GetGraph(), rt, cfg.Matcher.GetSearchRadius(),
+		cfg.Matcher.SigmaZ, cfg.Matcher.Beta)
+	return newRoadNetwork(osmParser, edges, waySpeed, rt, matcher)
+}
+
+func newRoadNetwork(osmParser *osmparser.OsmParser, edges []datastructure.Edge, waySpeed map[int64]float64,
+	rt *spatialindex.Rtree, matcher *mapmatching.HMMMapMatcher) *RoadNetwork {
+	highways := make(map[int64]string)
+	for _, edge := range edges {
+		highways[edge.GetOsmWayId()] = edge.GetHighwayTypeString()
+	}
+	return &RoadNetwork{
+		osmParser: osmParser,
+		edges:     edges,
+		waySpeed:  waySpeed,
+		highways:  highways,
+		rt:        rt,
+		matcher:   matcher,
+	}
+}
+
+func (n *RoadNetwork) GetOsmParser() *osmparser.OsmParser {
+	return n.osmParser
+}
+
+func (n *RoadNetwork) GetEdges() []datastructure.Edge {
+	return n.edges
+}
+
+// GetWaySpeed. free flow speed of every osm way
+func (n *RoadNetwork) GetWaySpeed() map[int64]float64 {
+	return n.waySpeed
+}
+
+func (n *RoadNetwork) GetRtree() *spatialindex.Rtree {
+	return n.rt
+}
+
+// Region. area with its own scraper (bounding box, schedule, latest snapshot & closures) and outputs
+type Region struct {
+	name        string
+	boundingBox datastructure.BoundingBox
+	output      string
+	network     *RoadNetwork
+	scraper     *scraper.Scraper
+}
+
+func (r *Region) GetName() string {
+	return r.name
+}
+
+func (r *Region) GetBoundingBox() datastructure.BoundingBox {
+	return r.boundingBox
+}
+
+// GetOutput. output file name of the region
+func (r *Region) GetOutput() string {
+	return r.output
+}
+
+func (r *Region) GetNetwork() *RoadNetwork {
+	return r.network
+}
+
+func (r *Region) GetScraper() *scraper.Scraper {
+	return r.scraper
+}
+
+// Registry. regions in the config order, the first region is the default region of the api
+type Registry struct {
+	regions []*Region
+	byName  map[string]*Region
+}
+
+// NewRegistry. build the scraper of the regions named in names (every configured region if names is empty), every osm
+// file is parsed (and indexed) once and shared by the regions using it
+func NewRegistry(cfg *config.Config, names []string, log *zap.Logger) (*Registry, error) {
+	regionConfigs, err := SelectRegions(cfg, names)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make(map[string]*RoadNetwork)
+	registry := &Registry{
+		regions: make([]*Region, 0, len(regionConfigs)),
+		byName:  make(map[string]*Region, len(regionConfigs)),
+	}
+	for _, regionConfig := range regionConfigs {
+		if _, ok := registry.byName[regionConfig.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("duplicate region %q", regionConfig.Name))
+		}
+		network, ok := networks[regionConfig.OSMFile]
+		if !ok {
+			network = LoadRoadNetwork(regionConfig.OSMFile, cfg, log)
+			networks[regionConfig.OSMFile] = network
+		}
+
+		regionLog := log.With(zap.String("region", regionConfig.Name))
+		scp := scraper.NewScraper(regionConfig.Name, cfg.GetRegionScraperConfig(regionConfig), network.rt, network.matcher, regionLog,
+			network.waySpeed, network.osmParser.GetStreetIdMap(), network.osmParser.GetWayMap())
+		bb := regionConfig.BoundingBox
+		region := &Region{
+			name:        regionConfig.Name,
+			boundingBox: datastructure.NewBoundingBox(bb.MinLon, bb.MinLat, bb.MaxLon, bb.MaxLat),
+			output:      regionConfig.Output,
+			network:     network,
+			scraper:     scp,
+		}
+		registry.regions = append(registry.regions, region)
+		registry.byName[region.name] = region
+	}
+	return registry, nil
+}
+
+func (r *Registry) GetRegions() []*Region {
+	return r.regions
+}
+
+// GetRegion. region by name, the default region if name is empty
+func (r *Registry) GetRegion(name string) (*Region, bool) {
+	if name == "" {
+		return r.regions[0], true
+	}
+	region, ok := r.byName[name]
+	return region, ok
+}
+
+// SelectRegions. configured regions named in names (every region if names is empty), in the order of names
+func SelectRegions(cfg *config.Config, names []string) ([]config.RegionConfig, error) {
+	regionConfigs := cfg.GetRegions()
+	if len(regionConfigs) == 0 {
+		return nil, errors.New("no region configured")
+	}
+	if len(names) == 0 {
+		return regionConfigs, nil
+	}
+	selected := make([]config.RegionConfig, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, regionConfig := range regionConfigs {
+			if regionConfig.Name == name {
+				selected = append(selected, regionConfig)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New(fmt.Sprintf("unknown region %q", name))
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,315 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetAffectedAlerts. snap every waze alert (accident, road closure, hazard, police, etc.) location to the nearest osm way
+func (sc *Scraper) GetAffectedAlerts(data wazeResponse) []alertData {
+	alerts := make([]alertData, 0, len(data.Alerts))
+	for _, alert := range data.Alerts {
+		nearestEdge, dist, ok := sc.nearestEdge(alert.Location.Longitude, alert.Location.Latitude, sc.alertSnapRadius)
+		if !ok {
+			// keep the alert even if there is no osm way nearby
+			alerts = append(alerts, NewAlertData(alert, -1, "", -1))
+			continue
+		}
+
+		alerts = append(alerts, NewAlertData(alert, nearestEdge.GetOsmWayId(),
+			sc.streetIdMap.GetStr(nearestEdge.GetStreet()), dist))
+	}
+	return alerts
+}
+
+// readAlertUUIDsFromCSV. uuid of the alerts already in the alerts csv file, empty if the file doesn't exist
+func readAlertUUIDsFromCSV(csvPath string) (map[string]struct{}, error) {
+	uuids := make(map[string]struct{})
+	f, err := os.Open(csvPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return uuids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	// skip the header, the uuid is the first column
+	if _, err := r.Read(); err == io.EOF {
+		return uuids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) > 0 {
+			uuids[rec[0]] = struct{}{}
+		}
+	}
+	return uuids, nil
+}
+
+// writeAlertsToCSV. append the alerts that are not in seen (same uuid) to the alerts csv file and the alerts json lines
+// file, seen is updated
+func writeAlertsToCSV(alerts []alertData, csvPath, jsonPath string, seen map[string]struct{}) error {
+	// an empty file (crash before the header was flushed) gets the header
+	fileExists := false
+	if info, err := os.Stat(csvPath); err == nil && info.Size() > 0 {
+		fileExists = true
+	}
+
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	jsonFile, err := os.OpenFile(jsonPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+	jw := bufio.NewWriter(jsonFile)
+
+	w := csv.NewWriter(f)
+	if !fileExists {
+		if err := w.Write([]string{"uuid", "type", "subtype", "street", "city", "lon", "lat", "reliability",
+			"confidence", "pub_time", "osm_way_id", "osm_way_street_name", "snap_distance_m"}); err != nil {
+			return err
+		}
+	}
+
+	written := make([]string, 0)
+	for _, alert := range alerts {
+		if _, exists := seen[alert.getUUID()]; exists {
+			continue
+		}
+
+		osmWayId := ""
+		snapDistance := ""
+		if alert.getOsmWayId() != -1 {
+			osmWayId = strconv.FormatInt(alert.getOsmWayId(), 10)
+			snapDistance = fmt.Sprintf("%.2f", alert.getSnapDistance()*1000)
+		}
+		lon, lat := alert.getLonLat()
+		rec := []string{
+			alert.getUUID(),
+			alert.getType(),
+			alert.getSubtype(),
+			alert.getStreet(),
+			alert.getCity(),
+			strconv.FormatFloat(lon, 'f', 6, 64),
+			strconv.FormatFloat(lat, 'f', 6, 64),
+			strconv.Itoa(alert.getReliability()),
+			strconv.Itoa(alert.getConfidence()),
+			time.UnixMilli(alert.getPubMillis()).UTC().Format(time.RFC3339),
+			osmWayId,
+			alert.getOsmStreet(),
+			snapDistance,
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		if err := writeJSONLine(jw, newAlertRecord(alert)); err != nil {
+			return err
+		}
+		seen[alert.getUUID()] = struct{}{}
+		written = append(written, alert.getUUID())
+	}
+
+	w.Flush()
+	if err := errors.Join(w.Error(), jw.Flush()); err != nil {
+		// not on disk, write them again with the next scrape
+		for _, uuid := range written {
+			delete(seen, uuid)
+		}
+		return err
+	}
+	return nil
+}
+
+// alertRecord. json lines record of a snapped alert, osm_way_id & snap_distance_m are null if the alert is not snapped
+type alertRecord struct {
+	UUID             string   `json:"uuid"`
+	Type             string   `json:"type"`
+	Subtype          string   `json:"subtype"`
+	Street           string   `json:"street"`
+	City             string   `json:"city"`
+	Lon              float64  `json:"lon"`
+	Lat              float64  `json:"lat"`
+	Reliability      int      `json:"reliability"`
+	Confidence       int      `json:"confidence"`
+	PubTime          string   `json:"pub_time"`
+	OsmWayId         *int64   `json:"osm_way_id"`
+	OsmWayStreetName string   `json:"osm_way_street_name"`
+	SnapDistanceM    *float64 `json:"snap_distance_m"`
+}
+
+func newAlertRecord(alert alertData) alertRecord {
+	lon, lat := alert.getLonLat()
+	rec := alertRecord{
+		UUID:             alert.getUUID(),
+		Type:             alert.getType(),
+		Subtype:          alert.getSubtype(),
+		Street:           alert.getStreet(),
+		City:             alert.getCity(),
+		Lon:              lon,
+		Lat:              lat,
+		Reliability:      alert.getReliability(),
+		Confidence:       alert.getConfidence(),
+		PubTime:          time.UnixMilli(alert.getPubMillis()).UTC().Format(time.RFC3339),
+		OsmWayStreetName: alert.getOsmStreet(),
+	}
+	if alert.getOsmWayId() != -1 {
+		osmWayId, snapDistance := alert.getOsmWayId(), alert.getSnapDistance()*1000
+		rec.OsmWayId, rec.SnapDistanceM = &osmWayId, &snapDistance
+	}
+	return rec
+}
+
+// irregularityKey. an irregularity keeps its id while waze updates it, every update is written
+type irregularityKey struct {
+	id         int64
+	updateTime int64
+}
+
+// irregularityRecord. json lines record of a waze irregularity (traffic much slower than the regular speed of the road)
+type irregularityRecord struct {
+	ID            int64        `json:"id"`
+	Type          string       `json:"type"`
+	Street        string       `json:"street"`
+	City          string       `json:"city"`
+	StartNode     string       `json:"start_node"`
+	EndNode       string       `json:"end_node"`
+	Speed         float64      `json:"speed"`
+	RegularSpeed  float64      `json:"regular_speed"`
+	DelaySeconds  int          `json:"delay_seconds"`
+	Length        int          `json:"length"`
+	Trend         int          `json:"trend"`
+	Severity      int          `json:"severity"`
+	JamLevel      int          `json:"jam_level"`
+	DriversCount  int          `json:"drivers_count"`
+	AlertsCount   int          `json:"alerts_count"`
+	Highway       bool         `json:"highway"`
+	CauseType     string       `json:"cause_type"`
+	DetectionTime string       `json:"detection_time"`
+	UpdateTime    string       `json:"update_time"`
+	UpdateMillis  int64        `json:"update_millis"`
+	Line          [][2]float64 `json:"line"` // lon, lat
+}
+
+func newIrregularityRecord(irregularity wazeIrregularity) irregularityRecord {
+	line := make([][2]float64, 0, len(irregularity.Line))
+	for _, p := range irregularity.Line {
+		line = append(line, [2]float64{p.Longitude, p.Latitude})
+	}
+	return irregularityRecord{
+		ID:            irregularity.ID,
+		Type:          irregularity.Type,
+		Street:        irregularity.Street,
+		City:          irregularity.City,
+		StartNode:     irregularity.StartNode,
+		EndNode:       irregularity.EndNode,
+		Speed:         irregularity.Speed,
+		RegularSpeed:  irregularity.RegularSpeed,
+		DelaySeconds:  irregularity.DelaySeconds,
+		Length:        irregularity.Length,
+		Trend:         irregularity.Trend,
+		Severity:      irregularity.Severity,
+		JamLevel:      irregularity.JamLevel,
+		DriversCount:  irregularity.DriversCount,
+		AlertsCount:   irregularity.AlertsCount,
+		Highway:       irregularity.Highway,
+		CauseType:     irregularity.CauseType,
+		DetectionTime: time.UnixMilli(irregularity.DetectionDateMillis).UTC().Format(time.RFC3339),
+		UpdateTime:    time.UnixMilli(irregularity.UpdateDateMillis).UTC().Format(time.RFC3339),
+		UpdateMillis:  irregularity.UpdateDateMillis,
+		Line:          line,
+	}
+}
+
+// readIrregularityKeys. (id, update time) of the irregularities already in the json lines file, empty if the file doesn't
+// exist
+func readIrregularityKeys(jsonPath string) (map[irregularityKey]struct{}, error) {
+	keys := make(map[irregularityKey]struct{})
+	f, err := os.Open(jsonPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec irregularityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a line cut by a crash, the irregularity is written again on its next update
+			continue
+		}
+		keys[irregularityKey{id: rec.ID, updateTime: rec.UpdateMillis}] = struct{}{}
+	}
+	return keys, scanner.Err()
+}
+
+// writeIrregularitiesToJSON. append the irregularities (or irregularity updates) that are not in seen to the json lines
+// file, seen is updated
+func writeIrregularitiesToJSON(irregularities []wazeIrregularity, jsonPath string, seen map[irregularityKey]struct{}) error {
+	f, err := os.OpenFile(jsonPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	written := make([]irregularityKey, 0)
+	for _, irregularity := range irregularities {
+		key := irregularityKey{id: irregularity.ID, updateTime: irregularity.UpdateDateMillis}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if err := writeJSONLine(w, newIrregularityRecord(irregularity)); err != nil {
+			return err
+		}
+		seen[key] = struct{}{}
+		written = append(written, key)
+	}
+	if err := w.Flush(); err != nil {
+		// not on disk, write them again with the next scrape
+		for _, key := range written {
+			delete(seen, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func writeJSONLine(w *bufio.Writer, v any) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+	_, err = w.Write(js)
+	return err
+}
@@ -0,0 +1,126 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestGetAffectedAlerts(t *testing.T) {
+	const lat = -7.79
+	streetIdMap := util.NewIdMap()
+	edges := []datastructure.Edge{
+		// osm way 1 along the latitude, osm way 2 ~110 meters north of it
+		datastructure.NewEdge(lat, 110.360, lat, 110.362, 0, 0, 1, true, datastructure.FORWARD, 1, "primary", 50,
+			streetIdMap.GetID("Jalan Malioboro"), 0.22, nil, 0),
+		datastructure.NewEdge(lat+0.001, 110.360, lat+0.001, 110.362, 1, 2, 3, true, datastructure.FORWARD, 2, "primary",
+			50, streetIdMap.GetID("Jalan Mataram"), 0.22, nil, 0),
+	}
+	rt := spatialindex.NewRtree()
+	rt.Build(edges, 0.03, zap.NewNop())
+	sc := &Scraper{rt: rt, streetIdMap: streetIdMap, alertSnapRadius: 0.05}
+
+	alerts := sc.GetAffectedAlerts(wazeResponse{Alerts: []wazeAlert{
+		{UUID: "near-way-1", Type: "ACCIDENT", Location: wazePoint{110.361, lat + 0.0001}},
+		{UUID: "near-way-2", Type: "HAZARD", Location: wazePoint{110.361, lat + 0.0009}},
+		{UUID: "far", Type: "POLICE", Location: wazePoint{110.361, lat + 0.005}},
+	}})
+
+	assert.Equal(t, 3, len(alerts))
+	assert.Equal(t, int64(1), alerts[0].getOsmWayId())
+	assert.Equal(t, "Jalan Malioboro", alerts[0].getOsmStreet())
+	assert.InDelta(t, 0.011, alerts[0].getSnapDistance(), 0.001)
+	assert.Equal(t, int64(2), alerts[1].getOsmWayId())
+	assert.Equal(t, "Jalan Mataram", alerts[1].getOsmStreet())
+	// kept without osm way
+	assert.Equal(t, int64(-1), alerts[2].getOsmWayId())
+	assert.Equal(t, "far", alerts[2].getUUID())
+}
+
+func readJSONLines[T any](t *testing.T, path string) []T {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	records := make([]T, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec T
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestWriteAlertsToCSV(t *testing.T) {
+	dir := t.TempDir()
+	csvPath, jsonPath := filepath.Join(dir, "alerts.csv"), filepath.Join(dir, "alerts.jsonl")
+	// an empty file left by a crash before the header was written
+	assert.Nil(t, os.WriteFile(csvPath, nil, 0644))
+	// the pub time is written in utc whatever the time zone of the scraper
+	local := time.Local
+	time.Local = time.FixedZone("WIB", 7*60*60)
+	defer func() { time.Local = local }()
+
+	snapped := NewAlertData(wazeAlert{UUID: "a-1", Type: "ACCIDENT", PubMillis: 1704092400000,
+		Location: wazePoint{110.36, -7.79}}, 1, "Jalan Malioboro", 0.004)
+	unsnapped := NewAlertData(wazeAlert{UUID: "a-2", Type: "POLICE"}, -1, "", -1)
+	seen, err := readAlertUUIDsFromCSV(csvPath)
+	assert.Nil(t, err)
+	assert.Nil(t, writeAlertsToCSV([]alertData{snapped}, csvPath, jsonPath, seen))
+	// a-1 is still active in the next scrape
+	assert.Nil(t, writeAlertsToCSV([]alertData{snapped, unsnapped}, csvPath, jsonPath, seen))
+	// and after a restart
+	seen, err = readAlertUUIDsFromCSV(csvPath)
+	assert.Nil(t, err)
+	assert.Nil(t, writeAlertsToCSV([]alertData{snapped, unsnapped}, csvPath, jsonPath, seen))
+
+	records := readJSONLines[alertRecord](t, jsonPath)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "a-1", records[0].UUID)
+	assert.Equal(t, "2024-01-01T07:00:00Z", records[0].PubTime)
+	assert.Equal(t, int64(1), *records[0].OsmWayId)
+	assert.InDelta(t, 4.0, *records[0].SnapDistanceM, 1e-9)
+	assert.Equal(t, "a-2", records[1].UUID)
+	assert.Nil(t, records[1].OsmWayId)
+	assert.Nil(t, records[1].SnapDistanceM)
+
+	rows, err := os.ReadFile(csvPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "uuid,type,subtype,street,city,lon,lat,reliability,confidence,pub_time,osm_way_id,osm_way_street_name,snap_distance_m\n"+
+		"a-1,ACCIDENT,,,,110.360000,-7.790000,0,0,2024-01-01T07:00:00Z,1,Jalan Malioboro,4.00\n"+
+		"a-2,POLICE,,,,0.000000,0.000000,0,0,1970-01-01T00:00:00Z,,,\n", string(rows))
+}
+
+func TestWriteIrregularitiesToJSON(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "irregularities.jsonl")
+
+	seen, err := readIrregularityKeys(jsonPath)
+	assert.Nil(t, err)
+	irregularity := wazeIrregularity{ID: 7, Type: "LARGE", Speed: 8, RegularSpeed: 35, UpdateDateMillis: 1000,
+		Line: []wazePoint{{110.36, -7.79}, {110.37, -7.79}}}
+	assert.Nil(t, writeIrregularitiesToJSON([]wazeIrregularity{irregularity}, jsonPath, seen))
+	assert.Nil(t, writeIrregularitiesToJSON([]wazeIrregularity{irregularity}, jsonPath, seen))
+
+	// an update of the irregularity is written again, also after a restart
+	seen, err = readIrregularityKeys(jsonPath)
+	assert.Nil(t, err)
+	updated := irregularity
+	updated.Speed, updated.UpdateDateMillis = 5, 2000
+	assert.Nil(t, writeIrregularitiesToJSON([]wazeIrregularity{irregularity, updated}, jsonPath, seen))
+
+	records := readJSONLines[irregularityRecord](t, jsonPath)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, 8.0, records[0].Speed)
+	assert.Equal(t, 5.0, records[1].Speed)
+	assert.Equal(t, [][2]float64{{110.36, -7.79}, {110.37, -7.79}}, records[1].Line)
+}
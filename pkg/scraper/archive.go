@@ -0,0 +1,291 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// rawTileResponse. raw georss json of one tile
+type rawTileResponse struct {
+	BoundingBox [4]float64      `json:"bbox"` // min lon, min lat, max lon, max lat
+	Body        json.RawMessage `json:"body"`
+}
+
+func newRawTileResponse(tile datastructure.BoundingBox, body []byte) rawTileResponse {
+	minLon, minLat := tile.GetMin()
+	maxLon, maxLat := tile.GetMax()
+	return rawTileResponse{
+		BoundingBox: [4]float64{minLon, minLat, maxLon, maxLat},
+		Body:        body,
+	}
+}
+
+// rawScrape. raw responses of all tiles of one scrape
+type rawScrape struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Tiles     []rawTileResponse `json:"tiles"`
+}
+
+// RAW_ARCHIVE_RECORD_MAGIC. start of every raw archive record, the reader scans for it to resync after a corrupt record
+const RAW_ARCHIVE_RECORD_MAGIC = "WZRA"
+
+// RAW_ARCHIVE_HEADER_SIZE. magic + big endian uint32 length of the gzip member
+const RAW_ARCHIVE_HEADER_SIZE = 8
+
+// RawArchive. archive of the raw georss responses, rotated by day (utc): <dir>/waze_raw_<prefix>_2024-01-01.gzrec.
+// every scrape is appended as one record: RAW_ARCHIVE_RECORD_MAGIC, the length of the gzip member and a gzip member with
+// the json line of the scrape. a record cut by a crash is skipped by the reader, which resyncs on the magic of the next
+// record, so the scrapes appended after the crash stay readable
+type RawArchive struct {
+	mu     sync.Mutex
+	dir    string
+	prefix string
+}
+
+func NewRawArchive(dir, prefix string) (*RawArchive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to create raw archive directory %s: %s", dir, err.Error()))
+	}
+	return &RawArchive{dir: dir, prefix: prefix}, nil
+}
+
+func (a *RawArchive) filePath(timestamp time.Time) string {
+	return filepath.Join(a.dir, fmt.Sprintf("waze_raw_%s_%s.gzrec", a.prefix, timestamp.UTC().Format("2006-01-02")))
+}
+
+// Files. archive files sorted by day
+func (a *RawArchive) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(a.dir, fmt.Sprintf("waze_raw_%s_*.gzrec", a.prefix)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (a *RawArchive) write(scrape rawScrape) error {
+	line, err := json.Marshal(scrape)
+	if err != nil {
+		return err
+	}
+
+	var record bytes.Buffer
+	record.WriteString(RAW_ARCHIVE_RECORD_MAGIC)
+	record.Write(make([]byte, RAW_ARCHIVE_HEADER_SIZE-len(RAW_ARCHIVE_RECORD_MAGIC)))
+	zw := gzip.NewWriter(&record)
+	if _, err := zw.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(record.Bytes()[len(RAW_ARCHIVE_RECORD_MAGIC):RAW_ARCHIVE_HEADER_SIZE],
+		uint32(record.Len()-RAW_ARCHIVE_HEADER_SIZE))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.filePath(scrape.Timestamp), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(record.Bytes()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readRawArchive. call fn for every archived scrape of the file, in the write order. corrupt records (cut by a crash
+// while writing them) are skipped, returns the number of skipped parts of the file
+func readRawArchive(path string, fn func(scrape rawScrape) error) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	offset := int64(0)
+	skipped, corrupt := 0, false
+	header := make([]byte, RAW_ARCHIVE_HEADER_SIZE)
+	for {
+		scrape, size, err := readRawArchiveRecord(r, header, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			return skipped, nil
+		}
+		if errors.Is(err, errCorruptRawRecord) {
+			if !corrupt {
+				skipped++
+				corrupt = true
+			}
+			// resync on the next magic after the start of the corrupt record
+			if offset, err = seekRawArchiveMagic(f, r, offset+1); errors.Is(err, io.EOF) {
+				return skipped, nil
+			}
+			if err != nil {
+				return skipped, errors.New(fmt.Sprintf("failed to read raw archive %s: %s", path, err.Error()))
+			}
+			continue
+		}
+		if err != nil {
+			return skipped, errors.New(fmt.Sprintf("failed to read raw archive %s: %s", path, err.Error()))
+		}
+
+		offset += size
+		corrupt = false
+		if err := fn(scrape); err != nil {
+			return skipped, err
+		}
+	}
+}
+
+var errCorruptRawRecord = errors.New("corrupt raw archive record")
+
+// readRawArchiveRecord. read the record at the reader position, returns the record size. io.EOF at the end of the file,
+// errCorruptRawRecord if the record is cut or doesn't start with the magic
+func readRawArchiveRecord(r *bufio.Reader, header []byte, remaining int64) (rawScrape, int64, error) {
+	if _, err := io.ReadFull(r, header); errors.Is(err, io.ErrUnexpectedEOF) {
+		return rawScrape{}, 0, errCorruptRawRecord
+	} else if err != nil {
+		return rawScrape{}, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[len(RAW_ARCHIVE_RECORD_MAGIC):]))
+	if string(header[:len(RAW_ARCHIVE_RECORD_MAGIC)]) != RAW_ARCHIVE_RECORD_MAGIC ||
+		length > remaining-RAW_ARCHIVE_HEADER_SIZE {
+		return rawScrape{}, 0, errCorruptRawRecord
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return rawScrape{}, 0, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return rawScrape{}, 0, errCorruptRawRecord
+	}
+	line, err := io.ReadAll(zr)
+	if err != nil {
+		return rawScrape{}, 0, errCorruptRawRecord
+	}
+	var scrape rawScrape
+	if err := json.Unmarshal(line, &scrape); err != nil {
+		return rawScrape{}, 0, errCorruptRawRecord
+	}
+	return scrape, RAW_ARCHIVE_HEADER_SIZE + length, nil
+}
+
+// seekRawArchiveMagic. move the reader to the first RAW_ARCHIVE_RECORD_MAGIC at or after from, returns its offset
+func seekRawArchiveMagic(f *os.File, r *bufio.Reader, from int64) (int64, error) {
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r.Reset(f)
+
+	offset, matched := from, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		offset++
+		if b == RAW_ARCHIVE_RECORD_MAGIC[matched] {
+			matched++
+		} else if b == RAW_ARCHIVE_RECORD_MAGIC[0] {
+			matched = 1
+		} else {
+			matched = 0
+		}
+		if matched == len(RAW_ARCHIVE_RECORD_MAGIC) {
+			start := offset - int64(len(RAW_ARCHIVE_RECORD_MAGIC))
+			if _, err := f.Seek(start, io.SeekStart); err != nil {
+				return 0, err
+			}
+			r.Reset(f)
+			return start, nil
+		}
+	}
+}
+
+// decodeRawScrape. parse and merge the raw tile responses of one scrape
+func decodeRawScrape(scrape rawScrape) (wazeResponse, error) {
+	responses := make([]wazeResponse, len(scrape.Tiles))
+	for i, tile := range scrape.Tiles {
+		if err := json.Unmarshal(tile.Body, &responses[i]); err != nil {
+			return wazeResponse{}, errors.New(fmt.Sprintf("failed parsing waze response data: %s", err.Error()))
+		}
+	}
+	return mergeWazeResponses(responses), nil
+}
+
+// Replay. feed every archived scrape (in time order) through the map matcher and the storage instead of scraping waze,
+// used to rebuild the outputs with a new osm extract or new matching code. stops after the scrape being written when ctx
+// is cancelled
+func (sc *Scraper) Replay(ctx context.Context, archive *RawArchive, storage Storage) error {
+	files, err := archive.Files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New(fmt.Sprintf("no raw archive files in %s", archive.dir))
+	}
+
+	count, staleCount := 0, 0
+	stale := newStaleDetector()
+	for _, file := range files {
+		skipped, err := readRawArchive(file, func(scrape rawScrape) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			data, err := decodeRawScrape(scrape)
+			if err != nil {
+				return err
+			}
+			// repeated waze responses are skipped or flagged like in the scrape loop
+			if stale.check(data) {
+				staleCount++
+				if sc.staleData == STALE_DATA_SKIP {
+					return nil
+				}
+				return storage.Write(sc.newScrapeSnapshot(data, scrape.Timestamp).flagStale())
+			}
+			sc.closures.update(sc.GetClosures(data), scrape.Timestamp)
+			if err := storage.Write(sc.newScrapeSnapshot(data, scrape.Timestamp)); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+		if errors.Is(err, context.Canceled) {
+			sc.log.Sugar().Infof("replay stopped, %d scrapes replayed, %d stale scrapes", count, staleCount)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			sc.log.Sugar().Warnf("skipped %d corrupt parts of %s (scrapes cut by a crash)", skipped, file)
+		}
+		sc.log.Sugar().Infof("replayed %s, %d scrapes & %d stale scrapes so far", file, count, staleCount)
+	}
+	return nil
+}
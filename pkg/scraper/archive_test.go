@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawArchiveRoundTrip(t *testing.T) {
+	archive, err := NewRawArchive(t.TempDir(), "test")
+	assert.Nil(t, err)
+
+	tile := datastructure.NewBoundingBox(110.1, -8.2, 110.35, -7.95)
+	first := time.Date(2024, 1, 1, 23, 59, 50, 0, time.UTC)
+	scrapes := []rawScrape{
+		{Timestamp: first, Tiles: []rawTileResponse{
+			newRawTileResponse(tile, []byte(`{"startTimeMillis":1,"endTimeMillis":2,"jams":[{"uuid":10,"speedKMH":12}]}`)),
+			newRawTileResponse(tile, []byte(`{"startTimeMillis":1,"endTimeMillis":3,"jams":[{"uuid":10},{"uuid":11}]}`)),
+		}},
+		{Timestamp: first.Add(20 * time.Second), Tiles: []rawTileResponse{
+			newRawTileResponse(tile, []byte(`{"jams":[]}`)),
+		}},
+		{Timestamp: first.Add(40 * time.Second), Tiles: []rawTileResponse{
+			newRawTileResponse(tile, []byte(`{"jams":[{"uuid":12}]}`)),
+		}},
+	}
+	for _, scrape := range scrapes {
+		assert.Nil(t, archive.write(scrape))
+	}
+
+	files, err := archive.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files)) // rotated by day
+
+	// simulate a crash while writing the last scrape of the second day
+	f, err := os.OpenFile(files[1], os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0x1f, 0x8b, 0x08})
+	assert.Nil(t, err)
+	f.Close()
+
+	read := make([]rawScrape, 0)
+	skipped := 0
+	for _, file := range files {
+		n, err := readRawArchive(file, func(scrape rawScrape) error {
+			read = append(read, scrape)
+			return nil
+		})
+		assert.Nil(t, err)
+		skipped += n
+	}
+	assert.Equal(t, 3, len(read))
+	assert.Equal(t, 1, skipped)
+	assert.True(t, scrapes[2].Timestamp.Equal(read[2].Timestamp))
+
+	data, err := decodeRawScrape(read[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(data.Jams))
+	assert.Equal(t, 12.0, data.Jams[0].SpeedKMH)
+	assert.Equal(t, int64(3), data.EndTimeMillis)
+}
+
+func TestRawArchiveTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewRawArchive(dir, "test")
+	assert.Nil(t, err)
+
+	tile := datastructure.NewBoundingBox(110.1, -8.2, 110.35, -7.95)
+	first := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	newScrape := func(i int) rawScrape {
+		return rawScrape{Timestamp: first.Add(time.Duration(i) * 20 * time.Second), Tiles: []rawTileResponse{
+			newRawTileResponse(tile, []byte(fmt.Sprintf(`{"jams":[{"uuid":%d}]}`, i))),
+		}}
+	}
+	assert.Nil(t, archive.write(newScrape(0)))
+	assert.Nil(t, archive.write(newScrape(1)))
+
+	// the process was killed in the middle of writing the third scrape, then restarted
+	other, err := NewRawArchive(filepath.Join(dir, "other"), "test")
+	assert.Nil(t, err)
+	assert.Nil(t, other.write(newScrape(2)))
+	record, err := os.ReadFile(other.filePath(first))
+	assert.Nil(t, err)
+	f, err := os.OpenFile(archive.filePath(first), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(record[:len(record)/2])
+	assert.Nil(t, err)
+	f.Close()
+
+	assert.Nil(t, archive.write(newScrape(3)))
+	assert.Nil(t, archive.write(newScrape(4)))
+
+	uuids := make([]int64, 0)
+	skipped, err := readRawArchive(archive.filePath(first), func(scrape rawScrape) error {
+		data, err := decodeRawScrape(scrape)
+		assert.Nil(t, err)
+		uuids = append(uuids, data.Jams[0].UUID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, []int64{0, 1, 3, 4}, uuids)
+}
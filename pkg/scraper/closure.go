@@ -0,0 +1,125 @@
+package scraper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// closureStore. active road closures keyed by the uuid of the blocked waze jam
+type closureStore struct {
+	mu       sync.RWMutex
+	closures map[int64][]datastructure.RoadClosure
+}
+
+func newClosureStore() *closureStore {
+	return &closureStore{
+		closures: make(map[int64][]datastructure.RoadClosure),
+	}
+}
+
+// update. replace the closures of every blocked jam in the latest response, unless the stored closure has a newer block update.
+// closures that are missing from the latest response are kept until their block expiration (waze sometimes drops blocked jams
+// from the feed for a few periods), closures without block expiration are removed as soon as they are missing.
+func (cs *closureStore) update(latest map[int64][]datastructure.RoadClosure, now time.Time) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for jamUUID, closures := range latest {
+		if len(closures) == 0 {
+			continue
+		}
+		if old, ok := cs.closures[jamUUID]; ok && len(old) > 0 &&
+			old[0].GetLastUpdate().After(closures[0].GetLastUpdate()) {
+			continue
+		}
+		cs.closures[jamUUID] = closures
+	}
+
+	for jamUUID, closures := range cs.closures {
+		_, inLatest := latest[jamUUID]
+		if len(closures) == 0 || closures[0].IsExpired(now) ||
+			(!inLatest && closures[0].GetExpiration().IsZero()) {
+			delete(cs.closures, jamUUID)
+		}
+	}
+}
+
+func (cs *closureStore) active(now time.Time) []datastructure.RoadClosure {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	result := make([]datastructure.RoadClosure, 0, len(cs.closures))
+	for _, closures := range cs.closures {
+		for _, closure := range closures {
+			if closure.IsExpired(now) {
+				continue
+			}
+			result = append(result, closure)
+		}
+	}
+	return result
+}
+
+// GetClosures. map match every blocked waze jam (jam with block type) onto the osm ways (and travel directions) it closes
+func (sc *Scraper) GetClosures(data wazeResponse) map[int64][]datastructure.RoadClosure {
+	result := make(map[int64][]datastructure.RoadClosure)
+	for _, jam := range data.Jams {
+		if jam.BlockType == "" {
+			continue
+		}
+
+		seen := make(map[wayDirectionKey]struct{})
+		closures := make([]datastructure.RoadClosure, 0)
+		matched := sc.matcher.MapMatch(jamLineCoordinates(jam.Line))
+		for _, matchedEdge := range matched.GetEdges() {
+			edge := matchedEdge.GetEdge()
+			way, exists := sc.wayMap[edge.GetOsmWayId()]
+			if !exists {
+				continue
+			}
+
+			key := newWayDirectionKey(way.GetID(), matchedEdge.GetDirection())
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			closures = append(closures, datastructure.NewRoadClosure(jam.UUID, way, key.getDirection(),
+				jam.BlockType, jam.BlockDescription, jam.Street, jam.City,
+				millisToTime(jam.BlockStartTime), millisToTime(jam.BlockExpiration), millisToTime(jam.BlockUpdate)))
+		}
+		if len(closures) > 0 {
+			result[jam.UUID] = closures
+		}
+	}
+	return result
+}
+
+func (sc *Scraper) updateClosures(data wazeResponse) {
+	sc.closures.update(sc.GetClosures(data), time.Now())
+}
+
+// GetActiveClosures. returns the active (not expired) road closures of the latest scrape
+func (sc *Scraper) GetActiveClosures() []datastructure.RoadClosure {
+	return sc.closures.active(time.Now())
+}
+
+// ScrapeClosures. scrape waze, update the closure set and return the active road closures
+func (sc *Scraper) ScrapeClosures(ctx context.Context) ([]datastructure.RoadClosure, error) {
+	data, err := sc.scrape(ctx)
+	if err != nil {
+		return []datastructure.RoadClosure{}, err
+	}
+	sc.updateClosures(data)
+	return sc.GetActiveClosures(), nil
+}
+
+func millisToTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
@@ -0,0 +1,39 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosureStoreUpdate(t *testing.T) {
+	now := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	way := datastructure.NewWay(1, nil)
+	newClosure := func(jamUUID int64, expiration, lastUpdate time.Time) datastructure.RoadClosure {
+		return datastructure.NewRoadClosure(jamUUID, way, datastructure.FORWARD, "ROAD_CLOSED_EVENT", "", "", "",
+			now.Add(-time.Hour), expiration, lastUpdate)
+	}
+
+	cs := newClosureStore()
+	cs.update(map[int64][]datastructure.RoadClosure{
+		1: {newClosure(1, now.Add(time.Hour), now)},
+		2: {newClosure(2, time.Time{}, now)},
+		3: {newClosure(3, now.Add(10*time.Minute), now)},
+	}, now)
+	assert.Equal(t, 3, len(cs.active(now)))
+
+	// jam 2 (no expiration) & jam 3 are missing from the latest response, jam 1 comes with a stale block update
+	later := now.Add(20 * time.Minute)
+	cs.update(map[int64][]datastructure.RoadClosure{
+		1: {newClosure(1, now.Add(2*time.Hour), now.Add(-time.Minute))},
+	}, later)
+
+	active := cs.active(later)
+	assert.Equal(t, 1, len(active))
+	assert.Equal(t, int64(1), active[0].GetJamUUID())
+	assert.Equal(t, now.Add(time.Hour), active[0].GetExpiration())
+
+	assert.Equal(t, 0, len(cs.active(now.Add(2*time.Hour))))
+}
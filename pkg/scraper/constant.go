@@ -29,3 +29,27 @@ var userAgents = []string{
 	"Mozilla/5.0 (Windows NT 6.3; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2225.0 Safari/537.36",
 	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/37.0.2062.124 Safari/537.36",
 }
+
+const (
+	WAZE_GEORSS_TYPES = "traffic,alerts,irregularities"
+)
+
+const (
+	SOURCE_WAZE    = "waze"    // speed of a waze jam
+	SOURCE_CSV     = "csv"     // imported from the wide traffic csv, jam speeds and default speeds are not distinguishable
+	SOURCE_MISSING = "missing" // failed or skipped scrape, the row marks a gap in the time series
+	SOURCE_STALE   = "stale"   // repeated (frozen) waze response, written with scraper.stale_data = flag
+)
+
+const (
+	STALE_DATA_SKIP = "skip"
+	STALE_DATA_FLAG = "flag"
+)
+
+const (
+	PARQUET_EXPORT_BATCH_SIZE = 100000 // rows per parquet row group when exporting csv files
+)
+
+const (
+	HOURS_PER_WEEK = 7 * 24 // hour of week buckets of the history statistics
+)
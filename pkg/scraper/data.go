@@ -1,13 +1,19 @@
 package scraper
 
-import "github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+import (
+	"math"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
 
 type wazeResponse struct {
-	EndTimeMillis   int64     `json:"endTimeMillis"`
-	StartTimeMillis int64     `json:"startTimeMillis"`
-	StartTime       string    `json:"startTime"`
-	EndTime         string    `json:"endTime"`
-	Jams            []wazeJam `json:"jams"`
+	EndTimeMillis   int64              `json:"endTimeMillis"`
+	StartTimeMillis int64              `json:"startTimeMillis"`
+	StartTime       string             `json:"startTime"`
+	EndTime         string             `json:"endTime"`
+	Jams            []wazeJam          `json:"jams"`
+	Alerts          []wazeAlert        `json:"alerts"`
+	Irregularities  []wazeIrregularity `json:"irregularities"`
 }
 
 type wazeJam struct {
@@ -81,6 +87,61 @@ type wazeCauseAlert struct {
 	PubMillis                int64     `json:"pubMillis"`
 }
 
+// wazeAlert. user/partner reported event, e.g. ACCIDENT, ROAD_CLOSED, HAZARD, POLICE, JAM
+type wazeAlert struct {
+	Country                  string    `json:"country"`
+	City                     string    `json:"city"`
+	ReportRating             int       `json:"reportRating"`
+	ReportByMunicipalityUser string    `json:"reportByMunicipalityUser"`
+	Reliability              int       `json:"reliability"`
+	Type                     string    `json:"type"`
+	UUID                     string    `json:"uuid"`
+	Speed                    int       `json:"speed"`
+	ReportMood               int       `json:"reportMood"`
+	Subtype                  string    `json:"subtype"`
+	Street                   string    `json:"street"`
+	AdditionalInfo           string    `json:"additionalInfo"`
+	ID                       string    `json:"id"`
+	NThumbsUp                int       `json:"nThumbsUp"`
+	Inscale                  bool      `json:"inscale"`
+	Confidence               int       `json:"confidence"`
+	RoadType                 int       `json:"roadType"`
+	Magvar                   int       `json:"magvar"`
+	WazeData                 string    `json:"wazeData"`
+	ReportDescription        string    `json:"reportDescription"`
+	Location                 wazePoint `json:"location"`
+	PubMillis                int64     `json:"pubMillis"`
+}
+
+// wazeIrregularity. traffic condition that is significantly worse than the historical speed of the road
+type wazeIrregularity struct {
+	ID                  int64         `json:"id"`
+	Country             string        `json:"country"`
+	City                string        `json:"city"`
+	Street              string        `json:"street"`
+	StartNode           string        `json:"startNode"`
+	EndNode             string        `json:"endNode"`
+	Line                []wazePoint   `json:"line"`
+	Speed               float64       `json:"speed"`
+	RegularSpeed        float64       `json:"regularSpeed"`
+	DelaySeconds        int           `json:"delaySeconds"`
+	Seconds             int           `json:"seconds"`
+	Length              int           `json:"length"`
+	Trend               int           `json:"trend"`
+	Type                string        `json:"type"`
+	Severity            int           `json:"severity"`
+	JamLevel            int           `json:"jamLevel"`
+	DriversCount        int           `json:"driversCount"`
+	AlertsCount         int           `json:"alertsCount"`
+	NThumbsUp           int           `json:"nThumbsUp"`
+	NComments           int           `json:"nComments"`
+	Highway             bool          `json:"highway"`
+	CauseType           string        `json:"causeType"`
+	DetectionDateMillis int64         `json:"detectionDateMillis"`
+	UpdateDateMillis    int64         `json:"updateDateMillis"`
+	Segments            []wazeSegment `json:"segments"`
+}
+
 type edgesWithDistance struct {
 	edge datastructure.Edge
 	dist float64
@@ -98,13 +159,37 @@ func NewEdgesWithDistance(edge datastructure.Edge, dist float64) edgesWithDistan
 	return edgesWithDistance{edge, dist}
 }
 
+// wayDirectionKey. osm way and travel direction (relative to the osm way node order)
+type wayDirectionKey struct {
+	osmWayId  int64
+	direction datastructure.Direction
+}
+
+func newWayDirectionKey(osmWayId int64, direction datastructure.Direction) wayDirectionKey {
+	return wayDirectionKey{osmWayId, direction}
+}
+
+func (k wayDirectionKey) getOsmWayId() int64 {
+	return k.osmWayId
+}
+
+func (k wayDirectionKey) getDirection() datastructure.Direction {
+	return k.direction
+}
+
 type osmwayTrafficData struct {
 	id        int64
+	direction datastructure.Direction
 	speedKMH  float64
 	street    string
 	city      string
 	endNode   string
 	osmStreet string
+	ranges    []datastructure.WayRange // parts of the way covered by jams
+}
+
+func (o osmwayTrafficData) getDirection() datastructure.Direction {
+	return o.direction
 }
 
 func (o osmwayTrafficData) getSpeed() float64 {
@@ -117,7 +202,7 @@ func (o osmwayTrafficData) getStreet() string {
 
 
 func (o osmwayTrafficData) getOsmStreet() string {
-	return o.street
+	return o.osmStreet
 }
 
 func (o osmwayTrafficData) getCity() string {
@@ -128,7 +213,100 @@ func (o osmwayTrafficData) getEndNode() string {
 	return o.endNode
 }
 
-func NewOsmWayTrafficData(id int64, speedKMH float64,
-	street string, city string, endNode, osmStreet string) osmwayTrafficData {
-	return osmwayTrafficData{id, speedKMH, street, city, endNode, osmStreet}
+func (o osmwayTrafficData) getRanges() []datastructure.WayRange {
+	return o.ranges
+}
+
+// addRange. add a part of the way covered by another jam, the way speed is the speed of the slowest jam
+func (o osmwayTrafficData) addRange(wr datastructure.WayRange) osmwayTrafficData {
+	o.ranges = append(o.ranges, wr)
+	o.speedKMH = math.Min(o.speedKMH, wr.GetSpeed())
+	return o
+}
+
+func NewOsmWayTrafficData(id int64, direction datastructure.Direction, speedKMH float64,
+	street string, city string, endNode, osmStreet string, ranges []datastructure.WayRange) osmwayTrafficData {
+	return osmwayTrafficData{id, direction, speedKMH, street, city, endNode, osmStreet, ranges}
+}
+
+type alertData struct {
+	uuid         string
+	alertType    string
+	subtype      string
+	street       string
+	city         string
+	lon, lat     float64
+	reliability  int
+	confidence   int
+	pubMillis    int64
+	osmWayId     int64
+	osmStreet    string
+	snapDistance float64
+}
+
+func (a alertData) getUUID() string {
+	return a.uuid
+}
+
+func (a alertData) getType() string {
+	return a.alertType
+}
+
+func (a alertData) getSubtype() string {
+	return a.subtype
+}
+
+func (a alertData) getStreet() string {
+	return a.street
+}
+
+func (a alertData) getCity() string {
+	return a.city
+}
+
+func (a alertData) getLonLat() (float64, float64) {
+	return a.lon, a.lat
+}
+
+func (a alertData) getReliability() int {
+	return a.reliability
+}
+
+func (a alertData) getConfidence() int {
+	return a.confidence
+}
+
+func (a alertData) getPubMillis() int64 {
+	return a.pubMillis
+}
+
+// getOsmWayId. returns -1 if the alert location is not snapped to any osm way
+func (a alertData) getOsmWayId() int64 {
+	return a.osmWayId
+}
+
+func (a alertData) getOsmStreet() string {
+	return a.osmStreet
+}
+
+func (a alertData) getSnapDistance() float64 {
+	return a.snapDistance
+}
+
+func NewAlertData(alert wazeAlert, osmWayId int64, osmStreet string, snapDistance float64) alertData {
+	return alertData{
+		uuid:         alert.UUID,
+		alertType:    alert.Type,
+		subtype:      alert.Subtype,
+		street:       alert.Street,
+		city:         alert.City,
+		lon:          alert.Location.Longitude,
+		lat:          alert.Location.Latitude,
+		reliability:  alert.Reliability,
+		confidence:   alert.Confidence,
+		pubMillis:    alert.PubMillis,
+		osmWayId:     osmWayId,
+		osmStreet:    osmStreet,
+		snapDistance: snapDistance,
+	}
 }
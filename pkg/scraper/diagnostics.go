@@ -0,0 +1,220 @@
+package scraper
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// scrapeDiagnostics. quality of one scrape, tells a drop in affected ways caused by less traffic apart from a broken
+// response or a broken map matching
+type scrapeDiagnostics struct {
+	jams             int           // jams received
+	jamsSkipped      int           // road block & alert caused jams, not map matched
+	linePoints       int           // points of the map matched jam lines
+	unmatchedPoints  int           // line points without a candidate edge within the search radius
+	meanSnapDistance float64       // mean distance (m) of the matched line points to their candidate
+	affectedWays     int           // affected (osm way, travel direction)
+	dataTime         time.Time     // end of the waze data window (EndTimeMillis), zero if waze didn't send it
+	dataAge          time.Duration // scrape timestamp - dataTime
+}
+
+// jamMatchStats. counts of the map matching of the jams of one response
+type jamMatchStats struct {
+	jamsSkipped      int
+	linePoints       int
+	unmatchedPoints  int
+	snapDistanceSumM float64
+}
+
+func newScrapeDiagnostics(data wazeResponse, stats jamMatchStats, affectedWays int, timestamp time.Time) scrapeDiagnostics {
+	d := scrapeDiagnostics{
+		jams:            len(data.Jams),
+		jamsSkipped:     stats.jamsSkipped,
+		linePoints:      stats.linePoints,
+		unmatchedPoints: stats.unmatchedPoints,
+		affectedWays:    affectedWays,
+	}
+	if matched := stats.linePoints - stats.unmatchedPoints; matched > 0 {
+		d.meanSnapDistance = stats.snapDistanceSumM / float64(matched)
+	}
+	if data.EndTimeMillis > 0 {
+		d.dataTime = time.UnixMilli(data.EndTimeMillis)
+		d.dataAge = timestamp.Sub(d.dataTime)
+	}
+	return d
+}
+
+func (d scrapeDiagnostics) getJams() int {
+	return d.jams
+}
+
+func (d scrapeDiagnostics) getJamsSkipped() int {
+	return d.jamsSkipped
+}
+
+func (d scrapeDiagnostics) getLinePoints() int {
+	return d.linePoints
+}
+
+func (d scrapeDiagnostics) getUnmatchedPoints() int {
+	return d.unmatchedPoints
+}
+
+// getUnmatchedRatio. fraction of the line points without candidate
+func (d scrapeDiagnostics) getUnmatchedRatio() float64 {
+	if d.linePoints == 0 {
+		return 0
+	}
+	return float64(d.unmatchedPoints) / float64(d.linePoints)
+}
+
+// getMeanSnapDistance. in meters
+func (d scrapeDiagnostics) getMeanSnapDistance() float64 {
+	return d.meanSnapDistance
+}
+
+func (d scrapeDiagnostics) getAffectedWays() int {
+	return d.affectedWays
+}
+
+// getDataTime. end of the waze data window, false if waze didn't send it
+func (d scrapeDiagnostics) getDataTime() (time.Time, bool) {
+	return d.dataTime, !d.dataTime.IsZero()
+}
+
+func (d scrapeDiagnostics) getDataAge() time.Duration {
+	return d.dataAge
+}
+
+// diagnosticMetric. diagnostic compared against the recent history, values below floor are raised to floor so that
+// a change from (almost) zero is not an infinite ratio
+type diagnosticMetric struct {
+	name  string
+	value func(d scrapeDiagnostics) float64
+	floor float64
+}
+
+var diagnosticMetrics = []diagnosticMetric{
+	{"jams", func(d scrapeDiagnostics) float64 { return float64(d.getJams()) }, 1},
+	{"affected_ways", func(d scrapeDiagnostics) float64 { return float64(d.getAffectedWays()) }, 1},
+	{"unmatched_ratio", scrapeDiagnostics.getUnmatchedRatio, 0.01},
+	{"mean_snap_distance_m", scrapeDiagnostics.getMeanSnapDistance, 1},
+	{"data_age_s", func(d scrapeDiagnostics) float64 { return d.getDataAge().Seconds() }, 60},
+}
+
+// diagnosticDeviation. diagnostic of a scrape that is more than deviationFactor times above or below the median of the
+// recent scrapes
+type diagnosticDeviation struct {
+	metric string
+	value  float64
+	median float64
+}
+
+// diagnosticsHistory. diagnostics of the last size successful scrapes
+type diagnosticsHistory struct {
+	size            int
+	minHistory      int
+	deviationFactor float64
+	history         []scrapeDiagnostics
+}
+
+func newDiagnosticsHistory(size, minHistory int, deviationFactor float64) *diagnosticsHistory {
+	return &diagnosticsHistory{
+		size:            size,
+		minHistory:      minHistory,
+		deviationFactor: deviationFactor,
+		history:         make([]scrapeDiagnostics, 0, size),
+	}
+}
+
+// add. compare d with the history and append it, returns the deviating diagnostics. nothing is compared until the
+// history has minHistory scrapes
+func (h *diagnosticsHistory) add(d scrapeDiagnostics) []diagnosticDeviation {
+	deviations := make([]diagnosticDeviation, 0)
+	if len(h.history) >= h.minHistory {
+		for _, metric := range diagnosticMetrics {
+			values := make([]float64, len(h.history))
+			for i, past := range h.history {
+				values[i] = metric.value(past)
+			}
+			median := medianOf(values)
+			value := metric.value(d)
+			curr, base := max(value, metric.floor), max(median, metric.floor)
+			if curr > base*h.deviationFactor || curr < base/h.deviationFactor {
+				deviations = append(deviations, diagnosticDeviation{metric: metric.name, value: value, median: median})
+			}
+		}
+	}
+
+	if len(h.history) == h.size {
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, d)
+	return deviations
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+var diagnosticsCsvHeader = []string{"timestamp", "jams", "jams_skipped", "line_points", "unmatched_points",
+	"mean_snap_distance_m", "affected_ways", "data_time", "data_age_s"}
+
+// diagnosticsRecord. csv row of the diagnostics, data_time & data_age_s are empty if waze didn't send the data window
+func diagnosticsRecord(timestamp time.Time, d scrapeDiagnostics) []string {
+	dataTime, dataAge := "", ""
+	if t, ok := d.getDataTime(); ok {
+		dataTime = t.UTC().Format(time.RFC3339)
+		dataAge = fmt.Sprintf("%.0f", d.getDataAge().Seconds())
+	}
+	return []string{
+		timestamp.Format(time.RFC3339),
+		strconv.Itoa(d.getJams()),
+		strconv.Itoa(d.getJamsSkipped()),
+		strconv.Itoa(d.getLinePoints()),
+		strconv.Itoa(d.getUnmatchedPoints()),
+		fmt.Sprintf("%.2f", d.getMeanSnapDistance()),
+		strconv.Itoa(d.getAffectedWays()),
+		dataTime,
+		dataAge,
+	}
+}
+
+// appendDiagnosticsToCSV. append the diagnostics row of the scrape to the diagnostics csv file
+func appendDiagnosticsToCSV(timestamp time.Time, d scrapeDiagnostics, csvPath string) error {
+	fileExists := false
+	if _, err := os.Stat(csvPath); err == nil {
+		fileExists = true
+	}
+
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if !fileExists {
+		if err := w.Write(diagnosticsCsvHeader); err != nil {
+			return err
+		}
+	}
+	if err := w.Write(diagnosticsRecord(timestamp, d)); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,65 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScrapeDiagnostics(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	data := wazeResponse{
+		EndTimeMillis: timestamp.Add(-90 * time.Second).UnixMilli(),
+		Jams:          []wazeJam{{UUID: 1}, {UUID: 2}, {UUID: 3, BlockType: "ROAD_CLOSED"}},
+	}
+	stats := jamMatchStats{jamsSkipped: 1, linePoints: 10, unmatchedPoints: 2, snapDistanceSumM: 40}
+
+	d := newScrapeDiagnostics(data, stats, 4, timestamp)
+	assert.Equal(t, 3, d.getJams())
+	assert.Equal(t, 1, d.getJamsSkipped())
+	assert.Equal(t, 0.2, d.getUnmatchedRatio())
+	assert.Equal(t, 5.0, d.getMeanSnapDistance())
+	assert.Equal(t, 4, d.getAffectedWays())
+	assert.Equal(t, 90*time.Second, d.getDataAge())
+	assert.Equal(t, []string{"2024-01-01T07:00:00Z", "3", "1", "10", "2", "5.00", "4", "2024-01-01T06:58:30Z", "90"},
+		diagnosticsRecord(timestamp, d))
+
+	// without the data window the data age is unknown
+	d = newScrapeDiagnostics(wazeResponse{}, jamMatchStats{}, 0, timestamp)
+	_, ok := d.getDataTime()
+	assert.False(t, ok)
+	assert.Equal(t, "", diagnosticsRecord(timestamp, d)[7])
+}
+
+func TestDiagnosticsHistoryDeviations(t *testing.T) {
+	history := newDiagnosticsHistory(5, 3, 3)
+	normal := scrapeDiagnostics{jams: 100, linePoints: 1000, unmatchedPoints: 10, meanSnapDistance: 6, affectedWays: 300,
+		dataAge: 30 * time.Second}
+
+	// not enough history to compare
+	broken := normal
+	broken.affectedWays = 0
+	assert.Empty(t, history.add(broken))
+	for range 5 {
+		assert.Empty(t, history.add(normal))
+	}
+
+	// less jams but a similar match quality, within the deviation factor
+	quiet := normal
+	quiet.jams, quiet.affectedWays = 50, 150
+	assert.Empty(t, history.add(quiet))
+
+	// same jams, most line points unmatched: broken map matching
+	deviations := history.add(scrapeDiagnostics{jams: 100, linePoints: 1000, unmatchedPoints: 900, meanSnapDistance: 6,
+		affectedWays: 20, dataAge: 30 * time.Second})
+	metrics := make([]string, 0, len(deviations))
+	for _, deviation := range deviations {
+		metrics = append(metrics, deviation.metric)
+	}
+	assert.Equal(t, []string{"affected_ways", "unmatched_ratio"}, metrics)
+	assert.Equal(t, 300.0, deviations[0].median)
+
+	// the history is capped at size
+	assert.Equal(t, 5, len(history.history))
+}
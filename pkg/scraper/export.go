@@ -0,0 +1,148 @@
+package scraper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExportCSVToParquet. convert a traffic csv file (wide waze_traffic_*.csv or long format) into the parquet traffic table
+// partitioned by day or hour, and the metadata csv file (waze_metadata_*.csv, optional) into the parquet way_metadata table.
+// the output layout is the same as ParquetStorage
+func ExportCSVToParquet(trafficCsvFilePath, metadataCsvFilePath, dir string, interval PartitionInterval) error {
+	writer := newParquetPartitionWriter[parquetTrafficRow](filepath.Join(dir, "traffic"), interval)
+
+	batch := make([]parquetTrafficRow, 0, PARQUET_EXPORT_BATCH_SIZE)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := writer.write(batch[0].Timestamp, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	err := forEachTrafficCSVRecord(trafficCsvFilePath, func(record trafficRecord) error {
+		if len(batch) > 0 && (len(batch) >= PARQUET_EXPORT_BATCH_SIZE ||
+			interval.partitionPath(record.getTimestamp()) != interval.partitionPath(batch[0].Timestamp)) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, newParquetTrafficRow(record))
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		writer.close()
+		return errors.New(fmt.Sprintf("failed to export %s: %s", trafficCsvFilePath, err.Error()))
+	}
+	if err := writer.close(); err != nil {
+		return err
+	}
+
+	if metadataCsvFilePath == "" {
+		return nil
+	}
+	if _, err := os.Stat(metadataCsvFilePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	metadataRows, err := readWayMetadataCSV(metadataCsvFilePath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to export %s: %s", metadataCsvFilePath, err.Error()))
+	}
+	metadataDir := filepath.Join(dir, "way_metadata")
+	if err := os.MkdirAll(metadataDir, 0755); err != nil {
+		return err
+	}
+	return writeParquetFile(filepath.Join(metadataDir,
+		fmt.Sprintf("part-%s.parquet", time.Now().UTC().Format("20060102T150405.000000000"))), metadataRows)
+}
+
+// readWayMetadataCSV. columns are looked up by name, metadata files written before the direction column existed are forward
+func readWayMetadataCSV(csvPath string) ([]parquetWayMetadataRow, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		columns[h] = i
+	}
+	if _, ok := columns["osm_way_id"]; !ok {
+		return nil, errors.New(fmt.Sprintf("%s has no osm_way_id column", csvPath))
+	}
+	column := func(rec []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	rows := make([]parquetWayMetadataRow, 0)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		osmWayId, err := strconv.ParseInt(column(rec, "osm_way_id"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		direction := column(rec, "direction")
+		if direction == "" {
+			direction = datastructure.FORWARD.String()
+		}
+		rows = append(rows, parquetWayMetadataRow{
+			OsmWayId:         osmWayId,
+			Direction:        direction,
+			Street:           column(rec, "street"),
+			City:             column(rec, "city"),
+			EndNode:          column(rec, "end_node"),
+			OsmWayStreetName: column(rec, "osm_way_street_name"),
+		})
+	}
+	return rows, nil
+}
+
+func writeParquetFile[T any](path string, rows []T) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := parquet.NewGenericWriter[T](f, parquet.Compression(&parquet.Zstd))
+	if _, err := w.Write(rows); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
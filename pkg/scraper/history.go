@@ -0,0 +1,713 @@
+package scraper
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/parquet-go/parquet-go"
+)
+
+// HistoryReader. read access to the scrapes written by a Storage backend
+type HistoryReader interface {
+	// forEachScrape. stored scrapes with from <= timestamp < to in timestamp order. with osmWayId != 0 the scrapes only
+	// have the traffic records of that osm way, but every scrape of the time range is still visited
+	forEachScrape(from, to time.Time, osmWayId int64, fn func(scrape historicalScrape) error) error
+	// pendingFrom. start of the earliest stored data overlapping [from, to) that can't be read yet, false if every stored
+	// scrape of the range is readable
+	pendingFrom(from, to time.Time) (time.Time, bool, error)
+	Close() error
+}
+
+// historicalScrape. one stored scrape, read back from the storage
+type historicalScrape struct {
+	timestamp time.Time
+	source    string // SOURCE_WAZE (or SOURCE_CSV for imported wide csv files), SOURCE_STALE or SOURCE_MISSING
+	records   []trafficRecord
+}
+
+func (s historicalScrape) getTimestamp() time.Time {
+	return s.timestamp
+}
+
+func (s historicalScrape) getSource() string {
+	return s.source
+}
+
+func (s historicalScrape) getRecords() []trafficRecord {
+	return s.records
+}
+
+func (s historicalScrape) isMissing() bool {
+	return s.source == SOURCE_MISSING
+}
+
+func (s historicalScrape) isStale() bool {
+	return s.source == SOURCE_STALE
+}
+
+// scrapeKey. identifies a scrape in the file backends, a stale scrape has the same timestamp as the scrape it repeats
+type scrapeKey struct {
+	timestamp int64
+	source    string
+}
+
+// sourceOrder. order of the scrapes with the same timestamp, a repeated response comes after the original one
+func sourceOrder(source string) int {
+	switch source {
+	case SOURCE_STALE:
+		return 1
+	case SOURCE_MISSING:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// scrapeCollector. groups the rows of the file backends into scrapes, the rows of one scrape are not adjacent in every
+// file (the diagnostics are in another file than the traffic rows)
+type scrapeCollector struct {
+	from, to time.Time
+	osmWayId int64
+	scrapes  map[scrapeKey]*historicalScrape
+}
+
+func newScrapeCollector(from, to time.Time, osmWayId int64) *scrapeCollector {
+	return &scrapeCollector{
+		from:     from,
+		to:       to,
+		osmWayId: osmWayId,
+		scrapes:  make(map[scrapeKey]*historicalScrape),
+	}
+}
+
+func (c *scrapeCollector) inRange(timestamp time.Time) bool {
+	return !timestamp.Before(c.from) && timestamp.Before(c.to)
+}
+
+// addScrape. scrape without traffic records, e.g. a successful scrape without jams
+func (c *scrapeCollector) addScrape(timestamp time.Time, source string) *historicalScrape {
+	key := scrapeKey{timestamp: timestamp.Unix(), source: source}
+	scrape, ok := c.scrapes[key]
+	if !ok {
+		scrape = &historicalScrape{timestamp: timestamp, source: source, records: make([]trafficRecord, 0)}
+		c.scrapes[key] = scrape
+	}
+	return scrape
+}
+
+func (c *scrapeCollector) addRecord(record trafficRecord) {
+	if !c.inRange(record.getTimestamp()) {
+		return
+	}
+	scrape := c.addScrape(record.getTimestamp(), record.getSource())
+	if record.isMissing() || (c.osmWayId != 0 && record.osmWayId != c.osmWayId) {
+		return
+	}
+	scrape.records = append(scrape.records, record)
+}
+
+func (c *scrapeCollector) forEach(fn func(scrape historicalScrape) error) error {
+	scrapes := make([]*historicalScrape, 0, len(c.scrapes))
+	for _, scrape := range c.scrapes {
+		scrapes = append(scrapes, scrape)
+	}
+	sort.Slice(scrapes, func(i, j int) bool {
+		if !scrapes[i].timestamp.Equal(scrapes[j].timestamp) {
+			return scrapes[i].timestamp.Before(scrapes[j].timestamp)
+		}
+		return sourceOrder(scrapes[i].source) < sourceOrder(scrapes[j].source)
+	})
+	for _, scrape := range scrapes {
+		if err := fn(*scrape); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV_INDEX_BLOCK_ROWS. rows per block of the csv time index
+const CSV_INDEX_BLOCK_ROWS = 10000
+
+// csvIndexBlock. rows [offset, end) of a csv file and their time range
+type csvIndexBlock struct {
+	offset, end      int64
+	minTime, maxTime time.Time
+}
+
+// csvTimeIndex. sparse index of an append only csv file with the RFC3339 timestamp in the first column. the rows are
+// grouped in blocks of CSV_INDEX_BLOCK_ROWS rows, a time range query only reads the blocks overlapping the range. the
+// rows appended since the previous query are indexed by the next query, the rows don't have to be in time order
+type csvTimeIndex struct {
+	mu        sync.Mutex
+	path      string
+	blockRows int
+	header    []string
+	blocks    []csvIndexBlock
+}
+
+func newCSVTimeIndex(path string) *csvTimeIndex {
+	return &csvTimeIndex{path: path, blockRows: CSV_INDEX_BLOCK_ROWS}
+}
+
+// update. index the rows appended since the last update, the last block (which may not be full) is indexed again
+func (idx *csvTimeIndex) update(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if len(idx.blocks) > 0 && info.Size() < idx.blocks[len(idx.blocks)-1].end {
+		// the file was replaced
+		idx.header, idx.blocks = nil, nil
+	}
+
+	offset := int64(0)
+	if len(idx.blocks) > 0 {
+		offset = idx.blocks[len(idx.blocks)-1].offset
+		idx.blocks = idx.blocks[:len(idx.blocks)-1]
+	}
+	// a row without its newline is still being written (or was cut off), it is left for the next update
+	complete, err := lastLineEnd(f, offset, info.Size())
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(io.NewSectionReader(f, offset, complete-offset))
+	r.FieldsPerRecord = -1
+	if idx.header == nil {
+		header, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(header) == 0 || header[0] != "timestamp" {
+			return errors.New(fmt.Sprintf("%s has no timestamp column", idx.path))
+		}
+		idx.header = header
+	}
+
+	block := csvIndexBlock{offset: offset + r.InputOffset()}
+	rows := 0
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		timestamp, err := time.Parse(time.RFC3339, rec[0])
+		if err != nil {
+			return err
+		}
+		if rows == 0 || timestamp.Before(block.minTime) {
+			block.minTime = timestamp
+		}
+		if rows == 0 || timestamp.After(block.maxTime) {
+			block.maxTime = timestamp
+		}
+		rows++
+		block.end = offset + r.InputOffset()
+		if rows == idx.blockRows {
+			idx.blocks = append(idx.blocks, block)
+			block, rows = csvIndexBlock{offset: block.end}, 0
+		}
+	}
+	if rows > 0 {
+		idx.blocks = append(idx.blocks, block)
+	}
+	return nil
+}
+
+// lastLineEnd. offset after the last newline of the file between from and size, from if there is none
+func lastLineEnd(f *os.File, from, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > from; {
+		start := max(end-int64(len(buf)), from)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return from, nil
+}
+
+// forEachRow. call fn for the rows of the blocks overlapping [from, to), rows outside the range can be passed too
+func (idx *csvTimeIndex) forEachRow(from, to time.Time, fn func(header, rec []string) error) error {
+	f, err := os.Open(idx.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx.mu.Lock()
+	err = idx.update(f)
+	header, blocks := idx.header, idx.blocks
+	idx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		if !block.minTime.Before(to) || block.maxTime.Before(from) {
+			continue
+		}
+		r := csv.NewReader(io.NewSectionReader(f, block.offset, block.end-block.offset))
+		r.FieldsPerRecord = -1
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(header, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CSVHistoryReader. reads the long traffic csv & diagnostics csv files of CSVStorage. both files are indexed by time
+// (see csvTimeIndex), a query only reads the parts of the files overlapping its time range. a stale scrape without jams
+// is not stored by the csv backend so it is missing from the history
+type CSVHistoryReader struct {
+	traffic     *csvTimeIndex
+	diagnostics *csvTimeIndex
+}
+
+func NewCSVHistoryReader(trafficCsvFilePath, diagnosticsCsvFilePath string) *CSVHistoryReader {
+	return &CSVHistoryReader{
+		traffic:     newCSVTimeIndex(trafficCsvFilePath),
+		diagnostics: newCSVTimeIndex(diagnosticsCsvFilePath),
+	}
+}
+
+func (r *CSVHistoryReader) forEachScrape(from, to time.Time, osmWayId int64, fn func(scrape historicalScrape) error) error {
+	collector := newScrapeCollector(from, to, osmWayId)
+	err := r.traffic.forEachRow(from, to, func(header, rec []string) error {
+		return parseTrafficCSVRow(header, rec, func(record trafficRecord) error {
+			collector.addRecord(record)
+			return nil
+		})
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// every successful scrape has a diagnostics row, also the scrapes without affected ways
+	err = r.diagnostics.forEachRow(from, to, func(header, rec []string) error {
+		timestamp, err := time.Parse(time.RFC3339, rec[0])
+		if err != nil {
+			return err
+		}
+		if collector.inRange(timestamp) {
+			collector.addScrape(timestamp, SOURCE_WAZE)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return collector.forEach(fn)
+}
+
+func (r *CSVHistoryReader) pendingFrom(from, to time.Time) (time.Time, bool, error) {
+	return time.Time{}, false, nil
+}
+
+func (r *CSVHistoryReader) Close() error {
+	return nil
+}
+
+// SQLiteHistoryReader. reads the scrapes & traffic tables of SQLiteStorage, the database is opened read only and can be
+// read while the scraper writes it
+type SQLiteHistoryReader struct {
+	db *sql.DB
+}
+
+func NewSQLiteHistoryReader(dbPath string) (*SQLiteHistoryReader, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", dbPath))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to open sqlite database %s: %s", dbPath, err.Error()))
+	}
+	return &SQLiteHistoryReader{db: db}, nil
+}
+
+func (r *SQLiteHistoryReader) forEachScrape(from, to time.Time, osmWayId int64, fn func(scrape historicalScrape) error) error {
+	query := `SELECT s.id, s.timestamp, s.status, t.osm_way_id, t.direction, t.speed, t.source FROM scrapes s
+		LEFT JOIN traffic t ON t.timestamp = s.timestamp AND t.scrape_id = s.id`
+	args := []any{}
+	if osmWayId != 0 {
+		query += ` AND t.osm_way_id = ?`
+		args = append(args, osmWayId)
+	}
+	query += ` WHERE s.timestamp >= ? AND s.timestamp < ? ORDER BY s.timestamp, s.id`
+	// the timestamps are stored in whole seconds, round the bounds up so that [from, to) keeps its meaning
+	args = append(args, from.Add(time.Second-1).Unix(), to.Add(time.Second-1).Unix())
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		scrape   *historicalScrape
+		scrapeId int64 = -1
+	)
+	for rows.Next() {
+		var (
+			id, timestamp int64
+			status        string
+			wayId         sql.NullInt64
+			direction     sql.NullString
+			speed         sql.NullFloat64
+			source        sql.NullString
+		)
+		if err := rows.Scan(&id, &timestamp, &status, &wayId, &direction, &speed, &source); err != nil {
+			return err
+		}
+		if id != scrapeId {
+			if scrape != nil {
+				if err := fn(*scrape); err != nil {
+					return err
+				}
+			}
+			scrapeId = id
+			scrape = &historicalScrape{
+				timestamp: time.Unix(timestamp, 0).UTC(),
+				source:    sqliteScrapeSource(status),
+				records:   make([]trafficRecord, 0),
+			}
+		}
+		if !wayId.Valid {
+			continue
+		}
+		wayDirection := datastructure.FORWARD
+		if direction.String == datastructure.BACKWARD.String() {
+			wayDirection = datastructure.BACKWARD
+		}
+		scrape.records = append(scrape.records, newTrafficRecord(scrape.timestamp, wayId.Int64, wayDirection,
+			speed.Float64, source.String))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if scrape != nil {
+		return fn(*scrape)
+	}
+	return nil
+}
+
+func (r *SQLiteHistoryReader) pendingFrom(from, to time.Time) (time.Time, bool, error) {
+	return time.Time{}, false, nil
+}
+
+func (r *SQLiteHistoryReader) Close() error {
+	return r.db.Close()
+}
+
+// sqliteScrapeSource. source of the traffic of a row of the scrapes table
+func sqliteScrapeSource(status string) string {
+	switch status {
+	case SOURCE_STALE, SOURCE_MISSING:
+		return status
+	default:
+		return SOURCE_WAZE
+	}
+}
+
+// ParquetHistoryReader. reads the traffic & scrapes tables of ParquetStorage (or ExportCSVToParquet). only the partitions
+// overlapping the time range are read, and only completed files: a part-*.parquet.tmp file has no parquet footer until
+// it is full (see PARQUET_FILE_MAX_ROWS & PARQUET_FILE_MAX_AGE), the partition rolls over or the scraper stops, so the
+// latest scrapes are missing from the history until then, see pendingFrom
+type ParquetHistoryReader struct {
+	dir string
+}
+
+func NewParquetHistoryReader(dir string) *ParquetHistoryReader {
+	return &ParquetHistoryReader{dir: dir}
+}
+
+func (r *ParquetHistoryReader) forEachScrape(from, to time.Time, osmWayId int64, fn func(scrape historicalScrape) error) error {
+	collector := newScrapeCollector(from, to, osmWayId)
+
+	trafficFiles, err := parquetPartitionFiles(filepath.Join(r.dir, "traffic"), from, to)
+	if err != nil {
+		return err
+	}
+	for _, file := range trafficFiles {
+		rows, err := parquet.ReadFile[parquetTrafficRow](file)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to read %s: %s", file, err.Error()))
+		}
+		for _, row := range rows {
+			direction := datastructure.FORWARD
+			if row.Direction == datastructure.BACKWARD.String() {
+				direction = datastructure.BACKWARD
+			}
+			collector.addRecord(newTrafficRecord(row.Timestamp, row.OsmWayId, direction, row.Speed, row.Source))
+		}
+	}
+
+	// every successful scrape has a scrapes row, also the scrapes without affected ways
+	scrapeFiles, err := parquetPartitionFiles(filepath.Join(r.dir, "scrapes"), from, to)
+	if err != nil {
+		return err
+	}
+	for _, file := range scrapeFiles {
+		rows, err := parquet.ReadFile[parquetScrapeRow](file)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to read %s: %s", file, err.Error()))
+		}
+		for _, row := range rows {
+			if collector.inRange(row.Timestamp) {
+				collector.addScrape(row.Timestamp, SOURCE_WAZE)
+			}
+		}
+	}
+	return collector.forEach(fn)
+}
+
+func (r *ParquetHistoryReader) Close() error {
+	return nil
+}
+
+func (r *ParquetHistoryReader) pendingFrom(from, to time.Time) (time.Time, bool, error) {
+	var (
+		pending time.Time
+		found   bool
+	)
+	for _, table := range []string{"traffic", "scrapes"} {
+		err := forEachParquetPartition(filepath.Join(r.dir, table), from, to, func(start time.Time, dir string) error {
+			tmpFiles, err := filepath.Glob(filepath.Join(dir, "part-*.parquet.tmp"))
+			if err != nil {
+				return err
+			}
+			if len(tmpFiles) > 0 && (!found || start.Before(pending)) {
+				pending, found = start, true
+			}
+			return nil
+		})
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	return pending, found, nil
+}
+
+// parquetPartitionFiles. completed parquet files of the partitions of the table directory overlapping [from, to)
+func parquetPartitionFiles(tableDir string, from, to time.Time) ([]string, error) {
+	files := make([]string, 0)
+	err := forEachParquetPartition(tableDir, from, to, func(start time.Time, dir string) error {
+		partitionFiles, err := filepath.Glob(filepath.Join(dir, "part-*.parquet"))
+		if err != nil {
+			return err
+		}
+		files = append(files, partitionFiles...)
+		return nil
+	})
+	return files, err
+}
+
+// forEachParquetPartition. call fn with the start & directory of the day (date=) or hour (date=/hour=) partitions of the
+// table directory overlapping [from, to). a day directory of hour partitions is passed too, it has no parquet files
+func forEachParquetPartition(tableDir string, from, to time.Time, fn func(start time.Time, dir string) error) error {
+	dateDirs, err := filepath.Glob(filepath.Join(tableDir, "date=*"))
+	if err != nil {
+		return err
+	}
+	overlaps := func(start time.Time, length time.Duration) bool {
+		return start.Before(to) && start.Add(length).After(from)
+	}
+
+	for _, dateDir := range dateDirs {
+		date, err := time.Parse("2006-01-02", strings.TrimPrefix(filepath.Base(dateDir), "date="))
+		if err != nil || !overlaps(date, 24*time.Hour) {
+			continue
+		}
+		if err := fn(date, dateDir); err != nil {
+			return err
+		}
+
+		hourDirs, err := filepath.Glob(filepath.Join(dateDir, "hour=*"))
+		if err != nil {
+			return err
+		}
+		for _, hourDir := range hourDirs {
+			hour, err := time.Parse("15", strings.TrimPrefix(filepath.Base(hourDir), "hour="))
+			start := date.Add(time.Duration(hour.Hour()) * time.Hour)
+			if err != nil || !overlaps(start, time.Hour) {
+				continue
+			}
+			if err := fn(start, hourDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// History. queries over the stored scrapes of a region: way speed time series, past snapshots & hour of week statistics.
+// a way without a stored record in a scrape was not jammed, its speed is the default (free flow) speed of the way
+type History struct {
+	reader       HistoryReader
+	defaultSpeed map[int64]float64
+	wayMap       map[int64]datastructure.Way
+}
+
+// NewHistory. history of the scrapes written by the storage backend of the scraper
+func (sc *Scraper) NewHistory(reader HistoryReader) *History {
+	return &History{
+		reader:       reader,
+		defaultSpeed: sc.osmWayDefaultSpeed,
+		wayMap:       sc.wayMap,
+	}
+}
+
+func (h *History) Close() error {
+	return h.reader.Close()
+}
+
+// GetPendingFrom. start of the stored scrapes in [from, to) that are not readable yet (the partition of an open parquet
+// file), false if the history of the range is complete
+func (h *History) GetPendingFrom(from, to time.Time) (time.Time, bool, error) {
+	return h.reader.pendingFrom(from, to)
+}
+
+// findRecord. traffic record of the (osm way, travel direction) in the scrape, false if the way was not jammed
+func findRecord(scrape historicalScrape, key wayDirectionKey) (trafficRecord, bool) {
+	for _, record := range scrape.getRecords() {
+		if record.getKey() == key {
+			return record, true
+		}
+	}
+	return trafficRecord{}, false
+}
+
+// GetWaySpeedSeries. speed of the osm way in the travel direction at every stored scrape in [from, to). failed scrapes
+// are kept as gaps (source missing, speed 0) and repeated waze responses are flagged (source stale)
+func (h *History) GetWaySpeedSeries(osmWayId int64, direction datastructure.Direction,
+	from, to time.Time) ([]datastructure.SpeedObservation, error) {
+	key := newWayDirectionKey(osmWayId, direction)
+	series := make([]datastructure.SpeedObservation, 0)
+	err := h.reader.forEachScrape(from, to, osmWayId, func(scrape historicalScrape) error {
+		if scrape.isMissing() {
+			series = append(series, datastructure.NewSpeedObservation(scrape.getTimestamp(), 0, false, SOURCE_MISSING))
+			return nil
+		}
+		if record, ok := findRecord(scrape, key); ok {
+			series = append(series, datastructure.NewSpeedObservation(scrape.getTimestamp(), record.getSpeed(), true,
+				scrape.getSource()))
+			return nil
+		}
+		series = append(series, datastructure.NewSpeedObservation(scrape.getTimestamp(), h.defaultSpeed[osmWayId], false,
+			scrape.getSource()))
+		return nil
+	})
+	return series, err
+}
+
+// GetSnapshotAt. traffic of the last stored scrape in [at - maxAge, at], false if there is no successful scrape in the
+// window. the way ranges & waze street names are not part of the history
+func (h *History) GetSnapshotAt(at time.Time, maxAge time.Duration) (datastructure.TrafficSnapshot, bool, error) {
+	var (
+		last  historicalScrape
+		found bool
+	)
+	err := h.reader.forEachScrape(at.Add(-maxAge), at.Add(time.Second), 0, func(scrape historicalScrape) error {
+		if !scrape.isMissing() && !scrape.getTimestamp().After(at) {
+			last, found = scrape, true
+		}
+		return nil
+	})
+	if err != nil || !found {
+		return datastructure.TrafficSnapshot{}, false, err
+	}
+
+	traffic := make([]datastructure.WayTraffic, 0, len(last.getRecords()))
+	for _, record := range last.getRecords() {
+		way, exists := h.wayMap[record.osmWayId]
+		if !exists {
+			continue
+		}
+		traffic = append(traffic, datastructure.NewWayTraffic(way, record.direction, record.getSpeed(),
+			h.defaultSpeed[record.osmWayId], "", "", "", nil))
+	}
+	return datastructure.NewTrafficSnapshot(last.getTimestamp(), traffic), true, nil
+}
+
+// GetHourOfWeekStats. mean, 15th & 85th percentile speed of the osm way in the travel direction per hour of the week
+// (in the time zone loc) over the scrapes in [from, to). failed scrapes & repeated waze responses are left out, only the
+// hours of week with at least one scrape are returned
+func (h *History) GetHourOfWeekStats(osmWayId int64, direction datastructure.Direction, from, to time.Time,
+	loc *time.Location) ([]datastructure.HourOfWeekStats, error) {
+	key := newWayDirectionKey(osmWayId, direction)
+	defaultSpeed, hasDefaultSpeed := h.defaultSpeed[osmWayId]
+	speeds := make([][]float64, HOURS_PER_WEEK)
+	err := h.reader.forEachScrape(from, to, osmWayId, func(scrape historicalScrape) error {
+		if scrape.isMissing() || scrape.isStale() {
+			return nil
+		}
+		hour := hourOfWeek(scrape.getTimestamp().In(loc))
+		if record, ok := findRecord(scrape, key); ok {
+			speeds[hour] = append(speeds[hour], record.getSpeed())
+		} else if hasDefaultSpeed {
+			speeds[hour] = append(speeds[hour], defaultSpeed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]datastructure.HourOfWeekStats, 0)
+	for hour, values := range speeds {
+		if len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		stats = append(stats, datastructure.NewHourOfWeekStats(hour, len(values), sum/float64(len(values)),
+			percentile(values, 0.15), percentile(values, 0.85)))
+	}
+	return stats, nil
+}
+
+// hourOfWeek. 0 is monday 00:00-01:00, 167 is sunday 23:00-24:00
+func hourOfWeek(t time.Time) int {
+	return ((int(t.Weekday())+6)%7)*24 + t.Hour()
+}
+
+// percentile. p-th percentile (0 <= p <= 1) of the sorted values, linear interpolation between the closest ranks
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
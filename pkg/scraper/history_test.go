@@ -0,0 +1,230 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// writeTestHistory. jam on way 1 at 07:00:00 (monday), no jams at 07:00:20, failed scrape at 07:00:40 and a repeated
+// response at 07:01:00
+func writeTestHistory(t *testing.T, storage Storage) {
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	noJams := scrapeSnapshot{
+		timestamp:    timestamp.Add(20 * time.Second),
+		affectedWays: make(map[wayDirectionKey]osmwayTrafficData),
+		wayRanges:    make(map[wayDirectionKey][]datastructure.WayRange),
+		alerts:       make([]alertData, 0),
+		jams:         make([]wazeJam, 0),
+	}
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp)))
+	assert.Nil(t, storage.Write(noJams))
+	assert.Nil(t, storage.Write(newMissingSnapshot(timestamp.Add(40*time.Second), errors.New("timeout"))))
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(time.Minute)).flagStale()))
+	assert.Nil(t, storage.Close())
+}
+
+func newTestHistory(reader HistoryReader) *History {
+	return &History{
+		reader:       reader,
+		defaultSpeed: map[int64]float64{1: 40},
+		wayMap:       map[int64]datastructure.Way{1: datastructure.NewWay(1, nil)},
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	newReaders := map[string]func(t *testing.T) HistoryReader{
+		"csv": func(t *testing.T) HistoryReader {
+			trafficPath, diagnosticsPath := filepath.Join(dir, "traffic.csv"), filepath.Join(dir, "diagnostics.csv")
+			storage, err := NewCSVStorage(trafficPath, filepath.Join(dir, "metadata.csv"), filepath.Join(dir, "alerts.csv"),
+				filepath.Join(dir, "alerts.jsonl"), filepath.Join(dir, "irregularities.jsonl"), filepath.Join(dir, "ranges.csv"),
+				diagnosticsPath)
+			assert.Nil(t, err)
+			writeTestHistory(t, storage)
+			return NewCSVHistoryReader(trafficPath, diagnosticsPath)
+		},
+		"sqlite": func(t *testing.T) HistoryReader {
+			dbPath := filepath.Join(dir, "traffic.db")
+			storage, err := NewSQLiteStorage(dbPath)
+			assert.Nil(t, err)
+			writeTestHistory(t, storage)
+			reader, err := NewSQLiteHistoryReader(dbPath)
+			assert.Nil(t, err)
+			return reader
+		},
+		"parquet": func(t *testing.T) HistoryReader {
+			storage, err := NewParquetStorage(filepath.Join(dir, "parquet"), PARTITION_HOUR, zap.NewNop())
+			assert.Nil(t, err)
+			writeTestHistory(t, storage)
+			return NewParquetHistoryReader(filepath.Join(dir, "parquet"))
+		},
+	}
+
+	for name, newReader := range newReaders {
+		t.Run(name, func(t *testing.T) {
+			history := newTestHistory(newReader(t))
+			defer history.Close()
+			timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+
+			series, err := history.GetWaySpeedSeries(1, datastructure.BACKWARD, timestamp, timestamp.Add(time.Hour))
+			assert.Nil(t, err)
+			assert.Equal(t, 4, len(series))
+			assert.True(t, timestamp.Equal(series[0].GetTimestamp()))
+			assert.Equal(t, []float64{12, 40, 0, 12}, []float64{series[0].GetSpeed(), series[1].GetSpeed(),
+				series[2].GetSpeed(), series[3].GetSpeed()})
+			assert.Equal(t, []bool{true, false, false, true}, []bool{series[0].IsJammed(), series[1].IsJammed(),
+				series[2].IsJammed(), series[3].IsJammed()})
+			assert.Equal(t, []string{SOURCE_WAZE, SOURCE_WAZE, SOURCE_MISSING, SOURCE_STALE}, []string{
+				series[0].GetSource(), series[1].GetSource(), series[2].GetSource(), series[3].GetSource()})
+
+			// the other direction was never jammed
+			series, err = history.GetWaySpeedSeries(1, datastructure.FORWARD, timestamp, timestamp.Add(30*time.Second))
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(series))
+			assert.False(t, series[0].IsJammed())
+			assert.Equal(t, 40.0, series[0].GetSpeed())
+
+			snapshot, ok, err := history.GetSnapshotAt(timestamp.Add(10*time.Second), time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.True(t, timestamp.Equal(snapshot.GetTimestamp()))
+			assert.Equal(t, 1, len(snapshot.GetTraffic()))
+			assert.Equal(t, datastructure.BACKWARD, snapshot.GetTraffic()[0].GetDirection())
+			assert.Equal(t, 12.0, snapshot.GetTraffic()[0].GetSpeed())
+
+			// the failed scrape is skipped
+			snapshot, ok, err = history.GetSnapshotAt(timestamp.Add(50*time.Second), time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.True(t, timestamp.Add(20*time.Second).Equal(snapshot.GetTimestamp()))
+			assert.Equal(t, 0, len(snapshot.GetTraffic()))
+
+			_, ok, err = history.GetSnapshotAt(timestamp.Add(-time.Second), time.Minute)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			// the failed & stale scrapes are left out
+			stats, err := history.GetHourOfWeekStats(1, datastructure.BACKWARD, timestamp, timestamp.Add(time.Hour), time.UTC)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(stats))
+			assert.Equal(t, 7, stats[0].GetHourOfWeek())
+			assert.Equal(t, 2, stats[0].GetCount())
+			assert.Equal(t, 26.0, stats[0].GetMean())
+			assert.InDelta(t, 16.2, stats[0].GetP15(), 1e-9)
+			assert.InDelta(t, 35.8, stats[0].GetP85(), 1e-9)
+
+			stats, err = history.GetHourOfWeekStats(1, datastructure.BACKWARD, timestamp, timestamp.Add(time.Hour),
+				time.FixedZone("WIB", 7*3600))
+			assert.Nil(t, err)
+			assert.Equal(t, 14, stats[0].GetHourOfWeek())
+		})
+	}
+}
+
+func TestHourOfWeek(t *testing.T) {
+	assert.Equal(t, 0, hourOfWeek(time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)))   // monday
+	assert.Equal(t, 167, hourOfWeek(time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC))) // sunday
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50}
+	assert.Equal(t, 10.0, percentile(values, 0))
+	assert.Equal(t, 30.0, percentile(values, 0.5))
+	assert.InDelta(t, 16.0, percentile(values, 0.15), 1e-9)
+	assert.InDelta(t, 44.0, percentile(values, 0.85), 1e-9)
+	assert.Equal(t, 50.0, percentile(values, 1))
+	assert.Equal(t, 7.0, percentile([]float64{7}, 0.85))
+}
+
+func TestCSVTimeIndex(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "traffic.csv")
+	first := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	appendScrapes := func(start, end int) {
+		for i := start; i < end; i++ {
+			assert.Nil(t, appendTrafficRecordsToCSV([]trafficRecord{
+				newTrafficRecord(first.Add(time.Duration(i)*time.Minute), 1, datastructure.FORWARD, float64(i), SOURCE_WAZE),
+				newTrafficRecord(first.Add(time.Duration(i)*time.Minute), 2, datastructure.FORWARD, float64(i), SOURCE_WAZE),
+			}, csvPath))
+		}
+	}
+	appendScrapes(0, 10)
+
+	idx := newCSVTimeIndex(csvPath)
+	idx.blockRows = 4
+	readSpeeds := func(from, to time.Time) ([]float64, int) {
+		speeds, rows := make([]float64, 0), 0
+		assert.Nil(t, idx.forEachRow(from, to, func(header, rec []string) error {
+			rows++
+			return parseTrafficCSVRow(header, rec, func(record trafficRecord) error {
+				if !record.getTimestamp().Before(from) && record.getTimestamp().Before(to) && record.osmWayId == 1 {
+					speeds = append(speeds, record.getSpeed())
+				}
+				return nil
+			})
+		}))
+		return speeds, rows
+	}
+
+	speeds, rows := readSpeeds(first.Add(3*time.Minute), first.Add(5*time.Minute))
+	assert.Equal(t, []float64{3, 4}, speeds)
+	assert.Equal(t, 8, rows) // blocks of minutes 2-3 & 4-5
+	assert.Equal(t, 5, len(idx.blocks))
+
+	// the rows appended after the first query are indexed by the next one
+	appendScrapes(10, 13)
+	speeds, rows = readSpeeds(first.Add(9*time.Minute), first.Add(time.Hour))
+	assert.Equal(t, []float64{9, 10, 11, 12}, speeds)
+	assert.Equal(t, 10, rows) // blocks of minutes 8-9, 10-11 & 12
+	assert.Equal(t, 7, len(idx.blocks))
+
+	// a row being written is not indexed until its newline is on disk
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString(first.Add(13*time.Minute).Format(time.RFC3339) + ",1,forw")
+	assert.Nil(t, err)
+	speeds, _ = readSpeeds(first.Add(12*time.Minute), first.Add(time.Hour))
+	assert.Equal(t, []float64{12}, speeds)
+	_, err = f.WriteString("ard,13.00," + SOURCE_WAZE + "\n")
+	assert.Nil(t, err)
+	speeds, _ = readSpeeds(first.Add(12*time.Minute), first.Add(time.Hour))
+	assert.Equal(t, []float64{12, 13}, speeds)
+}
+
+func TestParquetHistoryPending(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewParquetStorage(dir, PARTITION_DAY, zap.NewNop())
+	assert.Nil(t, err)
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp)))
+
+	history := newTestHistory(NewParquetHistoryReader(dir))
+	defer history.Close()
+	// the day partition is still being written
+	pendingFrom, ok, err := history.GetPendingFrom(timestamp, timestamp.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Equal(pendingFrom))
+	series, err := history.GetWaySpeedSeries(1, datastructure.BACKWARD, timestamp, timestamp.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(series))
+
+	_, ok, err = history.GetPendingFrom(timestamp.Add(24*time.Hour), timestamp.Add(25*time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, storage.Close())
+	_, ok, err = history.GetPendingFrom(timestamp, timestamp.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	series, err = history.GetWaySpeedSeries(1, datastructure.BACKWARD, timestamp, timestamp.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(series))
+}
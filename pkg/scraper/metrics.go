@@ -0,0 +1,120 @@
+package scraper
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// prometheus metrics of the scrapers, every metric is labeled with the region of the scraper
+var (
+	scrapesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "waze_scrapes_total",
+		Help: "Scrapes by result: success, failure, skipped (circuit breaker open) or stale (repeated waze response).",
+	}, []string{"region", "result"})
+
+	upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "waze_upstream_request_duration_seconds",
+		Help:    "Duration of the waze georss tile requests (including the retries) by result: success or failure.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"region", "result"})
+
+	jamsPerResponse = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "waze_jams_per_response",
+		Help:    "Number of jams in the merged waze response of a scrape.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"region"})
+
+	jamPointsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "waze_jam_points_total",
+		Help: "Jam line points by map matching result: matched, or unmatched (no road segment candidate within the search radius).",
+	}, []string{"region", "result"})
+
+	dataAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "waze_data_age_seconds",
+		Help: "Time between the last scrape and the end of its waze data window (endTimeMillis).",
+	}, []string{"region"})
+
+	storageWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "waze_storage_write_duration_seconds",
+		Help:    "Duration of the storage writes of a scrape by storage backend.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"region", "backend"})
+
+	streamSubscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "waze_stream_subscribers",
+		Help: "Subscribers of the traffic diff stream.",
+	}, []string{"region"})
+
+	streamDropsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "waze_stream_drops_total",
+		Help: "Traffic diff stream subscribers dropped because their buffer was full.",
+	}, []string{"region"})
+)
+
+const (
+	resultSuccess   = "success"
+	resultFailure   = "failure"
+	resultSkipped   = "skipped"
+	resultStale     = "stale"
+	resultMatched   = "matched"
+	resultUnmatched = "unmatched"
+)
+
+// scraperMetrics. metrics of a scraper, curried with the region label
+type scraperMetrics struct {
+	region string
+}
+
+func newScraperMetrics(region string) scraperMetrics {
+	return scraperMetrics{region: region}
+}
+
+func (m scraperMetrics) observeScrape(result string) {
+	scrapesTotal.WithLabelValues(m.region, result).Inc()
+}
+
+func (m scraperMetrics) observeUpstreamRequest(start time.Time, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultFailure
+	}
+	upstreamRequestDuration.WithLabelValues(m.region, result).Observe(time.Since(start).Seconds())
+}
+
+func (m scraperMetrics) observeDiagnostics(d scrapeDiagnostics) {
+	jamsPerResponse.WithLabelValues(m.region).Observe(float64(d.getJams()))
+	matched := d.getLinePoints() - d.getUnmatchedPoints()
+	jamPointsTotal.WithLabelValues(m.region, resultMatched).Add(float64(matched))
+	jamPointsTotal.WithLabelValues(m.region, resultUnmatched).Add(float64(d.getUnmatchedPoints()))
+	if _, ok := d.getDataTime(); ok {
+		dataAge.WithLabelValues(m.region).Set(d.getDataAge().Seconds())
+	}
+}
+
+func (m scraperMetrics) observeStorageWrite(storage Storage, start time.Time) {
+	storageWriteDuration.WithLabelValues(m.region, storageBackend(storage)).Observe(time.Since(start).Seconds())
+}
+
+func (m scraperMetrics) observeStreamSubscribers(subscribers int) {
+	streamSubscribers.WithLabelValues(m.region).Set(float64(subscribers))
+}
+
+func (m scraperMetrics) observeStreamDrop() {
+	streamDropsTotal.WithLabelValues(m.region).Inc()
+}
+
+// storageBackend. backend label of the storage
+func storageBackend(storage Storage) string {
+	switch storage.(type) {
+	case *CSVStorage:
+		return "csv"
+	case *SQLiteStorage:
+		return "sqlite"
+	case *ParquetStorage:
+		return "parquet"
+	default:
+		return "unknown"
+	}
+}
@@ -1,25 +1,26 @@
 package scraper
 
 import (
-	"encoding/csv"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	"math/rand"
 
 	"github.com/gojek/heimdall/v7"
 	"github.com/gojek/heimdall/v7/httpclient"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/config"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/mapmatching"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/spatialindex"
 	"github.com/lintang-b-s/waze-traffic-scraper/pkg/util"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 type Scraper struct {
@@ -30,31 +31,60 @@ type Scraper struct {
 	maximumJitterInterval time.Duration
 	period                time.Duration
 	url                   string
+	boundingBox           datastructure.BoundingBox
+	tileSize              float64
+	maxConcurrentRequests int
 	retryCount            int
+	alertSnapRadius       float64 // in km
+	circuitBreaker        config.CircuitBreakerConfig
 	rt                    *spatialindex.Rtree
+	matcher               *mapmatching.HMMMapMatcher
 	log                   *zap.Logger
 	osmWayDefaultSpeed    map[int64]float64
 	streetIdMap           *util.IDMap
 	wayMap                map[int64]datastructure.Way
+	closures              *closureStore
+	snapshots             *snapshotStore
+	stream                *diffBroadcaster
+	diagnostics           *diagnosticsHistory
+	stale                 *staleDetector
+	staleData             string // STALE_DATA_SKIP or STALE_DATA_FLAG
+	metrics               scraperMetrics
 }
 
-func NewScraper(requestTimeout, initialTimeout, maxTimeout, period, maximumJitterInterval time.Duration,
-	exponentFactor float64, url string, retryCount int, rt *spatialindex.Rtree, log *zap.Logger, waySpeed map[int64]float64,
-	streetIdMap *util.IDMap, wayMap map[int64]datastructure.Way) *Scraper {
+// NewScraper. scraper of the region name, name labels the metrics of the scraper
+func NewScraper(name string, cfg config.ScraperConfig, rt *spatialindex.Rtree, matcher *mapmatching.HMMMapMatcher, log *zap.Logger,
+	waySpeed map[int64]float64, streetIdMap *util.IDMap, wayMap map[int64]datastructure.Way) *Scraper {
+	metrics := newScraperMetrics(name)
 	return &Scraper{
-		initialTimeout:        initialTimeout,
-		maxTimeout:            maxTimeout,
-		requestTimeout:        requestTimeout,
-		maximumJitterInterval: maximumJitterInterval,
-		exponentFactor:        exponentFactor,
-		url:                   url,
-		retryCount:            retryCount,
+		initialTimeout:        cfg.InitialTimeout,
+		maxTimeout:            cfg.MaxTimeout,
+		requestTimeout:        cfg.RequestTimeout,
+		maximumJitterInterval: cfg.MaxJitter,
+		exponentFactor:        cfg.BackoffFactor,
+		url:                   cfg.URL,
+		boundingBox: datastructure.NewBoundingBox(cfg.BoundingBox.MinLon, cfg.BoundingBox.MinLat,
+			cfg.BoundingBox.MaxLon, cfg.BoundingBox.MaxLat),
+		tileSize:              cfg.TileSize,
+		maxConcurrentRequests: cfg.Concurrency,
+		retryCount:            cfg.RetryCount,
+		alertSnapRadius:       cfg.GetAlertSnapRadius(),
+		circuitBreaker:        cfg.CircuitBreaker,
 		rt:                    rt,
+		matcher:               matcher,
 		log:                   log,
 		osmWayDefaultSpeed:    waySpeed,
-		period:                period,
+		period:                cfg.Period,
 		streetIdMap:           streetIdMap,
 		wayMap:                wayMap,
+		closures:              newClosureStore(),
+		snapshots:             newSnapshotStore(),
+		stream:                newDiffBroadcaster(metrics),
+		metrics:               metrics,
+		stale:                 newStaleDetector(),
+		staleData:             cfg.StaleData,
+		diagnostics: newDiagnosticsHistory(cfg.Diagnostics.HistorySize, cfg.Diagnostics.MinHistory,
+			cfg.Diagnostics.DeviationFactor),
 	}
 }
 
@@ -90,7 +120,30 @@ func (sc *Scraper) getRetryCount() int {
 	return sc.retryCount
 }
 
-func (sc *Scraper) scrape() (wazeResponse, error) {
+func (sc *Scraper) getBoundingBox() datastructure.BoundingBox {
+	return sc.boundingBox
+}
+
+func (sc *Scraper) getTileSize() float64 {
+	return sc.tileSize
+}
+
+func (sc *Scraper) getMaxConcurrentRequests() int {
+	return sc.maxConcurrentRequests
+}
+
+// scrape. fetch and decode all tiles
+func (sc *Scraper) scrape(ctx context.Context) (wazeResponse, error) {
+	raw, err := sc.fetch(ctx)
+	if err != nil {
+		return wazeResponse{}, err
+	}
+	return decodeRawScrape(raw)
+}
+
+// fetch. split the scraper bounding box into tiles, fetch every tile with at most maxConcurrentRequests
+// requests in flight and return the raw tile responses. cancelling ctx aborts the requests in flight
+func (sc *Scraper) fetch(ctx context.Context) (rawScrape, error) {
 
 	backoff := heimdall.NewExponentialBackoff(sc.getInitialTimeout(), sc.getMaxTimeout(), sc.getExponentFactor(), sc.getMaximumJitterInterval())
 	retrier := heimdall.NewRetrier(backoff)
@@ -101,291 +154,206 @@ func (sc *Scraper) scrape() (wazeResponse, error) {
 		httpclient.WithRetryCount(sc.getRetryCount()),
 	)
 
-	httpHeaders := make(http.Header)
-	httpHeaders.Set("User-Agent", userAgents[rand.Intn(len(userAgents))])
-	httpHeaders.Set("Accept", "application/json")
-	resp, err := client.Get(sc.getURL(), httpHeaders)
+	timestamp := time.Now()
+	tiles := splitBoundingBox(sc.getBoundingBox(), sc.getTileSize())
+	responses := make([]rawTileResponse, len(tiles))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, sc.getMaxConcurrentRequests()))
+	for i, tile := range tiles {
+		g.Go(func() error {
+			start := time.Now()
+			body, err := sc.fetchTile(gctx, client, tile)
+			sc.metrics.observeUpstreamRequest(start, err)
+			if err != nil {
+				return err
+			}
+			responses[i] = newRawTileResponse(tile, body)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return rawScrape{}, err
+	}
+
+	return rawScrape{Timestamp: timestamp, Tiles: responses}, nil
+}
+
+func (sc *Scraper) fetchTile(ctx context.Context, client *httpclient.Client, tile datastructure.BoundingBox) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, buildGeorssURL(sc.getURL(), tile), nil)
 	if err != nil {
-		return wazeResponse{}, errors.New(fmt.Sprintf("failed to receive response from api after %d times retry: %s",
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgents[rand.Intn(len(userAgents))])
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New(fmt.Sprintf("failed to receive response from api after %d times retry: %s",
 			sc.getRetryCount(), err.Error()))
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return wazeResponse{}, errors.New(fmt.Sprintf("failed read response data: %s", err.Error()))
-
+		return nil, errors.New(fmt.Sprintf("failed read response data: %s", err.Error()))
 	}
-	var data wazeResponse
-	if err := json.Unmarshal(body, &data); err != nil {
-		return wazeResponse{}, errors.New(fmt.Sprintf("failed parsing waze response data: %s", err.Error()))
+	if !json.Valid(body) {
+		return nil, errors.New(fmt.Sprintf("failed parsing waze response data: invalid json (status %d)", resp.StatusCode))
 	}
 
-	return data, nil
+	return body, nil
 }
 
-// scrapePeriodically. scrape every period seconds + rand(0,maxJitterInterval)
-func (sc *Scraper) ScrapePeriodically(trafficCsvFilePath, metadataCsvFilePath string) error {
+// scrapePeriodically. scrape every period seconds + rand(0,maxJitterInterval), archive the raw responses (if archive is not nil)
+// and write every scrape to the storage. failed scrapes are logged and written as missing rows, after repeated failures the
+// circuit breaker stops sending requests for a cool-down period. only returns when ctx is cancelled, a scrape that is
+// being written when ctx is cancelled is written completely before returning
+func (sc *Scraper) ScrapePeriodically(ctx context.Context, storage Storage, archive *RawArchive) error {
+	breaker := newCircuitBreaker(sc.circuitBreaker.Threshold, sc.circuitBreaker.Cooldown, sc.circuitBreaker.MaxCooldown)
+	totalScrapes, totalFailures := 0, 0
 	for {
 		jitter := time.Duration(rand.Int63n(int64(sc.getMaximumJitterInterval())))
 		sleepDuration := sc.getPeriod() + jitter
-		time.Sleep(sleepDuration)
+		select {
+		case <-ctx.Done():
+			sc.log.Info("scraper stopped", zap.Int("total_scrapes", totalScrapes), zap.Int("total_failures", totalFailures))
+			return nil
+		case <-time.After(sleepDuration):
+		}
 
-		data, err := sc.scrape()
-		if err != nil {
-			return err
+		now := time.Now()
+		if !breaker.allow(now) {
+			sc.metrics.observeScrape(resultSkipped)
+			sc.writeMissing(storage, now, errCircuitOpen)
+			continue
+		}
+
+		totalScrapes++
+		stale, err := sc.scrapeOnce(ctx, storage, archive)
+		if ctx.Err() != nil {
+			// shutting down, an aborted fetch is not a failed scrape
+			sc.log.Info("scraper stopped", zap.Int("total_scrapes", totalScrapes), zap.Int("total_failures", totalFailures))
+			return nil
 		}
-		err = sc.writeTrafficDataToCSV(data, trafficCsvFilePath, metadataCsvFilePath)
 		if err != nil {
-			return err
+			sc.metrics.observeScrape(resultFailure)
+			totalFailures++
+			opened := breaker.failure(now)
+			sc.log.Error("failed to scrape waze traffic", zap.Error(err),
+				zap.Int("consecutive_failures", breaker.getConsecutiveFailures()),
+				zap.Int("total_failures", totalFailures), zap.Int("total_scrapes", totalScrapes))
+			if opened {
+				sc.log.Warn("circuit breaker opened, pausing waze requests",
+					zap.Time("until", breaker.getOpenUntil()))
+			}
+			sc.writeMissing(storage, now, err)
+			continue
+		}
+		breaker.success()
+		if stale {
+			sc.metrics.observeScrape(resultStale)
+			continue
 		}
+		sc.metrics.observeScrape(resultSuccess)
 		sc.log.Info("scraping waze traffic...", zap.Time("timestamp", time.Now()))
 	}
 }
 
-func (sc *Scraper) Scrape() ([]datastructure.WayTraffic, error) {
+// writeMissing. record the gap in the time series
+func (sc *Scraper) writeMissing(storage Storage, timestamp time.Time, reason error) {
+	if err := storage.Write(newMissingSnapshot(timestamp, reason)); err != nil {
+		sc.log.Error("failed to write missing scrape", zap.Error(err))
+	}
+}
+
+// Scrape. scrape waze once and return the matched traffic, the api serves GetLatestTraffic instead
+func (sc *Scraper) Scrape(ctx context.Context) ([]datastructure.WayTraffic, error) {
 
-	data, err := sc.scrape()
+	data, err := sc.scrape(ctx)
 	if err != nil {
 		return []datastructure.WayTraffic{}, err
 	}
-	affectedWays := sc.GetAffectedWays(data)
+	sc.updateClosures(data)
+	return sc.wayTraffic(sc.newScrapeSnapshot(data, time.Now())), nil
+}
 
-	result := make([]datastructure.WayTraffic, 0, len(affectedWays))
-	for osmWayId, trafficData := range affectedWays {
-		way, exists := sc.wayMap[osmWayId]
-		if !exists {
-			continue
-		}
-		result = append(result, datastructure.NewWayTraffic(
-			way,
-			trafficData.getSpeed(),
-		))
-	}
-	return result, nil
+// GetAffectedWays. map match every jam polyline and return the traffic data of every traversed (osm way, travel direction),
+// including the parts (offsets along the way) of the way covered by the jams
+func (sc *Scraper) GetAffectedWays(data wazeResponse) map[wayDirectionKey]osmwayTrafficData {
+	affectedWays, _ := sc.matchJams(data)
+	return affectedWays
 }
 
-func (sc *Scraper) GetAffectedWays(data wazeResponse) map[int64]osmwayTrafficData {
-	affectedWays := make(map[int64]osmwayTrafficData)
+// matchJams. GetAffectedWays with the map matching counts of the jams
+func (sc *Scraper) matchJams(data wazeResponse) (map[wayDirectionKey]osmwayTrafficData, jamMatchStats) {
+	affectedWays := make(map[wayDirectionKey]osmwayTrafficData)
+	stats := jamMatchStats{}
 	for _, jam := range data.Jams {
 		if jam.CauseAlert.Type != "" || jam.BlockType != "" { // skip road segment block event
+			stats.jamsSkipped++
 			continue
 		}
-		for _, coord := range jam.Line {
-			edges := sc.rt.SearchWithinRadius(coord.Longitude, coord.Latitude,
-				0.025) // 25 meters radius
-			if len(edges) == 0 {
+		matched := sc.matcher.MapMatch(jamLineCoordinates(jam.Line))
+		for _, point := range matched.GetPoints() {
+			stats.linePoints++
+			if !point.IsMatched() {
+				stats.unmatchedPoints++
 				continue
 			}
-
-			edgeDists := make([]edgesWithDistance, 0)
-			for _, edge := range edges {
-				fromLon, fromLat := edge.GetFromLonLat()
-				toLon, toLat := edge.GetToLonLat()
-				midLon, midLat := geo.MidPoint(fromLon, fromLat, toLon, toLat)
-				edgeDists = append(edgeDists, NewEdgesWithDistance(edge,
-					geo.CalculateHaversineDistance(coord.Longitude, coord.Latitude,
-						midLon, midLat)))
+			stats.snapDistanceSumM += point.GetCandidate().GetDistance()
+		}
+		for _, matchedEdge := range matched.GetEdges() {
+			edge := matchedEdge.GetEdge()
+			key := newWayDirectionKey(edge.GetOsmWayId(), matchedEdge.GetDirection())
+			start, end := matchedEdge.GetWayRange()
+			jamRange := datastructure.NewWayRange(start, end, float64(jam.SpeedKMH), true)
+			if trafficData, ok := affectedWays[key]; ok {
+				affectedWays[key] = trafficData.addRange(jamRange)
+				continue
 			}
-			util.QuickSortGIdx(edgeDists, func(j, pivotIdx int) bool {
-				return edgeDists[j].getDist() < edgeDists[pivotIdx].getDist()
-			})
-
-			nearestEdge := edgeDists[0].getEdge()
-			affectedWays[nearestEdge.GetOsmWayId()] = NewOsmWayTrafficData(
-				nearestEdge.GetOsmWayId(), float64(jam.SpeedKMH),
-				jam.Street, jam.City, jam.EndNode, sc.streetIdMap.GetStr(nearestEdge.GetStreet()),
+			affectedWays[key] = NewOsmWayTrafficData(
+				edge.GetOsmWayId(), matchedEdge.GetDirection(), float64(jam.SpeedKMH),
+				jam.Street, jam.City, jam.EndNode, sc.streetIdMap.GetStr(edge.GetStreet()),
+				[]datastructure.WayRange{jamRange},
 			)
 		}
 	}
-	return affectedWays
+	return affectedWays, stats
 }
 
-func (sc *Scraper) writeTrafficDataToCSV(data wazeResponse, trafficCsvFilePath, metadataCsvFilePath string) error {
-	affectedWays := sc.GetAffectedWays(data)
-	// traffic speed data
-	err := sc.writeTrafficSpeedDataToCSV(affectedWays, trafficCsvFilePath)
-	if err != nil {
-		return err
+func jamLineCoordinates(line []wazePoint) []datastructure.Coordinate {
+	coords := make([]datastructure.Coordinate, 0, len(line))
+	for _, p := range line {
+		coords = append(coords, datastructure.NewCoordinate(p.Longitude, p.Latitude))
 	}
-	// metadata
-	return sc.writeMetadataToCSV(affectedWays, metadataCsvFilePath)
+	return coords
 }
 
-func (sc *Scraper) writeTrafficSpeedDataToCSV(affectedWays map[int64]osmwayTrafficData, trafficCsvFilePath string) error {
-	var headers []string
-
-	fileExists := false
-	if _, err := os.Stat(trafficCsvFilePath); err == nil {
-		fileExists = true
-	}
-
-	if fileExists {
-		f, err := os.Open(trafficCsvFilePath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		r := csv.NewReader(f)
-		headers, err = r.Read()
-		if err != nil {
-			return err
-		}
-	} else {
-		headers = []string{"timestamp"}
-	}
-
-	for osmWayId := range affectedWays {
-		found := false
-		for _, h := range headers {
-			if h == strconv.FormatInt(osmWayId, 10) {
-				found = true
-				break
-			}
-		}
-		if !found {
-			headers = append(headers, strconv.FormatInt(osmWayId, 10))
-		}
+// nearestEdge. returns the edge nearest (cross track distance to the edge geometry) to the query point within radius (in km)
+// and its distance (in km)
+func (sc *Scraper) nearestEdge(lon, lat, radius float64) (datastructure.Edge, float64, bool) {
+	edges := sc.rt.SearchWithinRadius(lon, lat, radius)
+	if len(edges) == 0 {
+		return datastructure.Edge{}, 0, false
 	}
 
-	row := make([]string, len(headers))
-	row[0] = time.Now().Format(time.RFC3339)
-	for i, h := range headers {
-		if h == "timestamp" {
+	edgeDists := make([]edgesWithDistance, 0, len(edges))
+	for _, edge := range edges {
+		dist, _, _, _, _ := geo.PointToPolyline(lon, lat, edge.GetPolyline())
+		if dist > radius {
 			continue
 		}
-		osmWayId, _ := strconv.ParseInt(h, 10, 64)
-		if speed, ok := affectedWays[osmWayId]; ok {
-			row[i] = fmt.Sprintf("%.2f", speed.getSpeed())
-		} else {
-			row[i] = fmt.Sprintf("%.2f", sc.osmWayDefaultSpeed[osmWayId])
-		}
-	}
-
-	timestamp := time.Now().Format("20060102_150405")
-	newFileName := fmt.Sprintf("%s.%s.csv", trafficCsvFilePath, timestamp)
-	f, err := os.Create(newFileName)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	w := csv.NewWriter(f)
-	defer w.Flush()
-
-	if err := w.Write(headers); err != nil {
-		return err
-	}
-
-	if fileExists {
-		oldF, err := os.Open(trafficCsvFilePath)
-		if err != nil {
-			return err
-		}
-		defer oldF.Close()
-		r := csv.NewReader(oldF)
-		_, _ = r.Read() // skip header
-		for {
-			rec, err := r.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
-			if len(headers) > len(rec) {
-				oldLen := len(rec)
-				rec = append(rec, make([]string, len(headers)-len(rec))...)
-				for i := oldLen; i < len(headers); i++ {
-					h := headers[i]
-					if h == "timestamp" {
-						continue
-					}
-					osmWayId, _ := strconv.ParseInt(h, 10, 64)
-					rec[i] = fmt.Sprintf("%.2f", sc.osmWayDefaultSpeed[osmWayId])
-				}
-			}
-
-			if err := w.Write(rec[:len(headers)]); err != nil {
-				return err
-			}
-		}
-	}
-	if err := w.Write(row); err != nil {
-		return err
-	}
-
-	if fileExists {
-		if err := os.Remove(trafficCsvFilePath); err != nil {
-			return err
-		}
+		edgeDists = append(edgeDists, NewEdgesWithDistance(edge, dist))
 	}
-	if err := os.Rename(newFileName, trafficCsvFilePath); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (sc *Scraper) writeMetadataToCSV(affectedWays map[int64]osmwayTrafficData, csvPath string) error {
-	existing := make(map[int64]bool)
-	fileExists := false
-	if _, err := os.Stat(csvPath); err == nil {
-		fileExists = true
-	}
-
-	var existingRecords [][]string
-	if fileExists {
-		f, err := os.Open(csvPath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		r := csv.NewReader(f)
-		existingRecords, err = r.ReadAll()
-		if err != nil {
-			return err
-		}
-
-		for _, rec := range existingRecords[1:] {
-			if len(rec) > 0 {
-				if id, err := strconv.ParseInt(rec[0], 10, 64); err == nil {
-					existing[id] = true
-				}
-			}
-		}
-	}
-
-	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	w := csv.NewWriter(f)
-	defer w.Flush()
-
-	if !fileExists {
-		if err := w.Write([]string{"osm_way_id", "street", "city", "end_node", "osm_way_street_name"}); err != nil {
-			return err
-		}
-	}
-
-	for id, info := range affectedWays {
-		if _, exists := existing[id]; exists {
-			continue
-		}
-		rec := []string{
-			strconv.FormatInt(id, 10),
-			info.getStreet(),
-			info.getCity(),
-			info.getEndNode(),
-			info.getOsmStreet(),
-		}
-		if err := w.Write(rec); err != nil {
-			return err
-		}
-		existing[id] = true
+	if len(edgeDists) == 0 {
+		return datastructure.Edge{}, 0, false
 	}
+	util.QuickSortGIdx(edgeDists, func(j, pivotIdx int) bool {
+		return edgeDists[j].getDist() < edgeDists[pivotIdx].getDist()
+	})
 
-	return nil
+	return edgeDists[0].getEdge(), edgeDists[0].getDist(), true
 }
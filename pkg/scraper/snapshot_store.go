@@ -0,0 +1,71 @@
+package scraper
+
+import (
+	"sync"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// snapshotStore. latest matched traffic snapshot, written by the scrape loop and read by the api handlers
+type snapshotStore struct {
+	mu       sync.RWMutex
+	latest   datastructure.TrafficSnapshot
+	hasValue bool
+	sequence uint64
+}
+
+func newSnapshotStore() *snapshotStore {
+	return &snapshotStore{}
+}
+
+// set. replace the latest snapshot, the snapshot gets the next sequence number
+func (ss *snapshotStore) set(snapshot datastructure.TrafficSnapshot) datastructure.TrafficSnapshot {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sequence++
+	ss.latest = snapshot.WithSequence(ss.sequence)
+	ss.hasValue = true
+	return ss.latest
+}
+
+// get. latest snapshot, false if no scrape succeeded yet. the snapshot is replaced (not modified) by the scrape loop,
+// so it can be read without holding the lock
+func (ss *snapshotStore) get() (datastructure.TrafficSnapshot, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.latest, ss.hasValue
+}
+
+// wayTraffic. traffic of every affected (osm way, travel direction) of the snapshot
+func (sc *Scraper) wayTraffic(snapshot scrapeSnapshot) []datastructure.WayTraffic {
+	result := make([]datastructure.WayTraffic, 0, len(snapshot.getAffectedWays()))
+	for _, key := range snapshot.sortedKeys() {
+		way, exists := sc.wayMap[key.getOsmWayId()]
+		if !exists {
+			continue
+		}
+		trafficData := snapshot.getAffectedWays()[key]
+		result = append(result, datastructure.NewWayTraffic(
+			way,
+			key.getDirection(),
+			trafficData.getSpeed(),
+			sc.osmWayDefaultSpeed[key.getOsmWayId()],
+			trafficData.getStreet(),
+			trafficData.getCity(),
+			trafficData.getOsmStreet(),
+			snapshot.getWayRanges()[key],
+		))
+	}
+	return result
+}
+
+// publish. make the snapshot the latest traffic served by the api and send its diff to the stream subscribers
+func (sc *Scraper) publish(snapshot scrapeSnapshot) {
+	published := sc.snapshots.set(datastructure.NewTrafficSnapshot(snapshot.getTimestamp(), sc.wayTraffic(snapshot)))
+	sc.stream.broadcast(published, sc.GetActiveClosures())
+}
+
+// GetLatestTraffic. traffic of the latest successful scrape of ScrapePeriodically, false if no scrape succeeded yet
+func (sc *Scraper) GetLatestTraffic() (datastructure.TrafficSnapshot, bool) {
+	return sc.snapshots.get()
+}
@@ -0,0 +1,36 @@
+package scraper
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	ss := newSnapshotStore()
+	_, ok := ss.get()
+	assert.False(t, ok)
+
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ss.set(datastructure.NewTrafficSnapshot(start.Add(time.Duration(i)*time.Minute), nil))
+		}()
+		go func() {
+			defer wg.Done()
+			ss.get()
+		}()
+	}
+	wg.Wait()
+
+	snapshot, ok := ss.get()
+	assert.True(t, ok)
+	assert.False(t, snapshot.GetTimestamp().Before(start))
+	assert.Equal(t, uint64(10), snapshot.GetSequence())
+}
@@ -0,0 +1,77 @@
+package scraper
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+	"time"
+)
+
+// upstreamFingerprint. identity of a waze response: data window & set of jam uuids
+type upstreamFingerprint struct {
+	startMillis int64
+	endMillis   int64
+	jamsHash    uint64
+}
+
+func newUpstreamFingerprint(data wazeResponse) upstreamFingerprint {
+	uuids := make([]int64, 0, len(data.Jams))
+	for _, jam := range data.Jams {
+		uuids = append(uuids, jam.UUID)
+	}
+	slices.Sort(uuids)
+
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, uuid := range uuids {
+		binary.LittleEndian.PutUint64(buf, uint64(uuid))
+		h.Write(buf)
+	}
+	return upstreamFingerprint{
+		startMillis: data.StartTimeMillis,
+		endMillis:   data.EndTimeMillis,
+		jamsHash:    h.Sum64(),
+	}
+}
+
+// staleDetector. detects waze responses that repeat the previous response (same data window and same jams), waze
+// sometimes keeps serving a frozen snapshot
+type staleDetector struct {
+	last    upstreamFingerprint
+	hasLast bool
+	repeats int // consecutive stale responses
+}
+
+func newStaleDetector() *staleDetector {
+	return &staleDetector{}
+}
+
+// check. true if data repeats the previous response. responses without data window are never stale, an unchanged jam
+// set alone (e.g. no jams at night) is not a frozen snapshot
+func (d *staleDetector) check(data wazeResponse) bool {
+	if data.EndTimeMillis <= 0 {
+		d.hasLast, d.repeats = false, 0
+		return false
+	}
+	fingerprint := newUpstreamFingerprint(data)
+	stale := d.hasLast && fingerprint == d.last
+	d.last, d.hasLast = fingerprint, true
+	if stale {
+		d.repeats++
+	} else {
+		d.repeats = 0
+	}
+	return stale
+}
+
+func (d *staleDetector) getRepeats() int {
+	return d.repeats
+}
+
+// dataTimestamp. timestamp of the scrape outputs: the end of the waze data window, fetchedAt if waze didn't send it
+func dataTimestamp(data wazeResponse, fetchedAt time.Time) time.Time {
+	if data.EndTimeMillis > 0 {
+		return time.UnixMilli(data.EndTimeMillis)
+	}
+	return fetchedAt
+}
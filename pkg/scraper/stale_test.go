@@ -0,0 +1,38 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleDetector(t *testing.T) {
+	detector := newStaleDetector()
+	first := wazeResponse{StartTimeMillis: 100, EndTimeMillis: 200, Jams: []wazeJam{{UUID: 1}, {UUID: 2}}}
+	assert.False(t, detector.check(first))
+
+	// same window & jams in another order
+	assert.True(t, detector.check(wazeResponse{StartTimeMillis: 100, EndTimeMillis: 200,
+		Jams: []wazeJam{{UUID: 2}, {UUID: 1}}}))
+	assert.True(t, detector.check(first))
+	assert.Equal(t, 2, detector.getRepeats())
+
+	// same window, another jam set
+	assert.False(t, detector.check(wazeResponse{StartTimeMillis: 100, EndTimeMillis: 200, Jams: []wazeJam{{UUID: 1}}}))
+	assert.Equal(t, 0, detector.getRepeats())
+
+	// new window
+	assert.False(t, detector.check(wazeResponse{StartTimeMillis: 160, EndTimeMillis: 260, Jams: []wazeJam{{UUID: 1}}}))
+
+	// without data window the response is never stale
+	assert.False(t, detector.check(wazeResponse{}))
+	assert.False(t, detector.check(wazeResponse{}))
+}
+
+func TestDataTimestamp(t *testing.T) {
+	fetchedAt := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	dataTime := fetchedAt.Add(-2 * time.Minute)
+	assert.True(t, dataTime.Equal(dataTimestamp(wazeResponse{EndTimeMillis: dataTime.UnixMilli()}, fetchedAt)))
+	assert.True(t, fetchedAt.Equal(dataTimestamp(wazeResponse{}, fetchedAt)))
+}
@@ -0,0 +1,183 @@
+package scraper
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// Storage. append only storage of the scrape results, every scrape is written as one snapshot
+type Storage interface {
+	Write(snapshot scrapeSnapshot) error
+	Close() error
+}
+
+// scrapeSnapshot. matched result of one scrape
+type scrapeSnapshot struct {
+	timestamp      time.Time
+	affectedWays   map[wayDirectionKey]osmwayTrafficData
+	wayRanges      map[wayDirectionKey][]datastructure.WayRange
+	alerts         []alertData
+	irregularities []wazeIrregularity
+	jams           []wazeJam
+	diagnostics    scrapeDiagnostics
+	stale          bool   // repeats the previous waze response
+	missing        bool   // failed or skipped scrape
+	reason         string // why the scrape is missing
+}
+
+// newMissingSnapshot. snapshot of a failed or skipped scrape, written so that gaps in the time series are explicit
+func newMissingSnapshot(timestamp time.Time, reason error) scrapeSnapshot {
+	return scrapeSnapshot{
+		timestamp:      timestamp,
+		affectedWays:   make(map[wayDirectionKey]osmwayTrafficData),
+		wayRanges:      make(map[wayDirectionKey][]datastructure.WayRange),
+		alerts:         make([]alertData, 0),
+		irregularities: make([]wazeIrregularity, 0),
+		jams:           make([]wazeJam, 0),
+		missing:        true,
+		reason:         reason.Error(),
+	}
+}
+
+// newScrapeSnapshot. map match the jams & alerts of the waze response fetched at fetchedAt, the snapshot timestamp is the
+// end of the waze data window (fetchedAt if waze didn't send it)
+func (sc *Scraper) newScrapeSnapshot(data wazeResponse, fetchedAt time.Time) scrapeSnapshot {
+	affectedWays, stats := sc.matchJams(data)
+	wayRanges := make(map[wayDirectionKey][]datastructure.WayRange, len(affectedWays))
+	for key, trafficData := range affectedWays {
+		wayRanges[key] = sc.GetWayRanges(key, trafficData)
+	}
+	return scrapeSnapshot{
+		timestamp:      dataTimestamp(data, fetchedAt),
+		affectedWays:   affectedWays,
+		wayRanges:      wayRanges,
+		alerts:         sc.GetAffectedAlerts(data),
+		irregularities: data.Irregularities,
+		jams:           data.Jams,
+		diagnostics:    newScrapeDiagnostics(data, stats, len(affectedWays), fetchedAt),
+	}
+}
+
+func (s scrapeSnapshot) getTimestamp() time.Time {
+	return s.timestamp
+}
+
+func (s scrapeSnapshot) getAffectedWays() map[wayDirectionKey]osmwayTrafficData {
+	return s.affectedWays
+}
+
+func (s scrapeSnapshot) getWayRanges() map[wayDirectionKey][]datastructure.WayRange {
+	return s.wayRanges
+}
+
+func (s scrapeSnapshot) getAlerts() []alertData {
+	return s.alerts
+}
+
+func (s scrapeSnapshot) getIrregularities() []wazeIrregularity {
+	return s.irregularities
+}
+
+func (s scrapeSnapshot) getJams() []wazeJam {
+	return s.jams
+}
+
+func (s scrapeSnapshot) getDiagnostics() scrapeDiagnostics {
+	return s.diagnostics
+}
+
+// flagStale. mark the snapshot as a repeated waze response
+func (s scrapeSnapshot) flagStale() scrapeSnapshot {
+	s.stale = true
+	return s
+}
+
+func (s scrapeSnapshot) isStale() bool {
+	return s.stale
+}
+
+// getSource. source of the traffic rows of the snapshot
+func (s scrapeSnapshot) getSource() string {
+	switch {
+	case s.missing:
+		return SOURCE_MISSING
+	case s.stale:
+		return SOURCE_STALE
+	default:
+		return SOURCE_WAZE
+	}
+}
+
+func (s scrapeSnapshot) isMissing() bool {
+	return s.missing
+}
+
+func (s scrapeSnapshot) getReason() string {
+	return s.reason
+}
+
+// sortedKeys. affected (osm way, travel direction) sorted by osm way id and direction
+func (s scrapeSnapshot) sortedKeys() []wayDirectionKey {
+	keys := make([]wayDirectionKey, 0, len(s.affectedWays))
+	for key := range s.affectedWays {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].getOsmWayId() != keys[j].getOsmWayId() {
+			return keys[i].getOsmWayId() < keys[j].getOsmWayId()
+		}
+		return keys[i].getDirection() < keys[j].getDirection()
+	})
+	return keys
+}
+
+// trafficRecords. long format rows of the snapshot, one row per affected (osm way, travel direction).
+// a missing snapshot has one row with source SOURCE_MISSING and without osm way, the rows of a stale snapshot have source
+// SOURCE_STALE
+func (s scrapeSnapshot) trafficRecords() []trafficRecord {
+	if s.missing {
+		return []trafficRecord{newTrafficRecord(s.timestamp, 0, datastructure.FORWARD, 0, SOURCE_MISSING)}
+	}
+	records := make([]trafficRecord, 0, len(s.affectedWays))
+	for _, key := range s.sortedKeys() {
+		records = append(records, newTrafficRecord(s.timestamp, key.getOsmWayId(), key.getDirection(),
+			s.affectedWays[key].getSpeed(), s.getSource()))
+	}
+	return records
+}
+
+// trafficRecord. one row of the long format traffic time series
+type trafficRecord struct {
+	timestamp time.Time
+	osmWayId  int64
+	direction datastructure.Direction
+	speed     float64
+	source    string
+}
+
+func newTrafficRecord(timestamp time.Time, osmWayId int64, direction datastructure.Direction, speed float64,
+	source string) trafficRecord {
+	return trafficRecord{timestamp, osmWayId, direction, speed, source}
+}
+
+func (r trafficRecord) getTimestamp() time.Time {
+	return r.timestamp
+}
+
+func (r trafficRecord) getKey() wayDirectionKey {
+	return newWayDirectionKey(r.osmWayId, r.direction)
+}
+
+func (r trafficRecord) getSpeed() float64 {
+	return r.speed
+}
+
+func (r trafficRecord) getSource() string {
+	return r.source
+}
+
+func (r trafficRecord) isMissing() bool {
+	return r.source == SOURCE_MISSING
+}
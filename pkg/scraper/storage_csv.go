@@ -0,0 +1,466 @@
+package scraper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+var trafficCsvHeader = []string{"timestamp", "osm_way_id", "direction", "speed", "source"}
+
+var metadataCsvHeader = []string{"osm_way_id", "direction", "street", "city", "end_node", "osm_way_street_name"}
+
+// CSVStorage. append only csv storage. the traffic speed is written in long format (one row per scrape timestamp,
+// osm way & travel direction), use ConvertTrafficCSVToWide to get the wide matrix (one column per way). the alerts are
+// also written as json lines, the irregularities only as json lines
+type CSVStorage struct {
+	trafficCsvFilePath         string
+	metadataCsvFilePath        string
+	alertsCsvFilePath          string
+	alertsJsonFilePath         string
+	irregularitiesJsonFilePath string
+	rangesCsvFilePath          string
+	diagnosticsCsvFilePath     string
+	// keys already in the files, read once when the storage is opened
+	seenMetadata       map[wayDirectionKey]struct{}
+	seenAlerts         map[string]struct{}
+	seenIrregularities map[irregularityKey]struct{}
+}
+
+func NewCSVStorage(trafficCsvFilePath, metadataCsvFilePath, alertsCsvFilePath, alertsJsonFilePath,
+	irregularitiesJsonFilePath, rangesCsvFilePath, diagnosticsCsvFilePath string) (*CSVStorage, error) {
+	seenMetadata, err := readMetadataKeysFromCSV(metadataCsvFilePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read %s: %s", metadataCsvFilePath, err.Error()))
+	}
+	seenAlerts, err := readAlertUUIDsFromCSV(alertsCsvFilePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read %s: %s", alertsCsvFilePath, err.Error()))
+	}
+	seenIrregularities, err := readIrregularityKeys(irregularitiesJsonFilePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read %s: %s", irregularitiesJsonFilePath, err.Error()))
+	}
+	return &CSVStorage{
+		trafficCsvFilePath:         trafficCsvFilePath,
+		metadataCsvFilePath:        metadataCsvFilePath,
+		alertsCsvFilePath:          alertsCsvFilePath,
+		alertsJsonFilePath:         alertsJsonFilePath,
+		irregularitiesJsonFilePath: irregularitiesJsonFilePath,
+		rangesCsvFilePath:          rangesCsvFilePath,
+		diagnosticsCsvFilePath:     diagnosticsCsvFilePath,
+		seenMetadata:               seenMetadata,
+		seenAlerts:                 seenAlerts,
+		seenIrregularities:         seenIrregularities,
+	}, nil
+}
+
+func (s *CSVStorage) Write(snapshot scrapeSnapshot) error {
+	// traffic speed data
+	err := appendTrafficRecordsToCSV(snapshot.trafficRecords(), s.trafficCsvFilePath)
+	if err != nil {
+		return err
+	}
+	// affected & unaffected parts of the ways
+	err = writeWayRangesToCSV(snapshot, s.rangesCsvFilePath)
+	if err != nil {
+		return err
+	}
+	// metadata
+	err = writeMetadataToCSV(snapshot.getAffectedWays(), s.metadataCsvFilePath, s.seenMetadata)
+	if err != nil {
+		return err
+	}
+	// alerts
+	err = writeAlertsToCSV(snapshot.getAlerts(), s.alertsCsvFilePath, s.alertsJsonFilePath, s.seenAlerts)
+	if err != nil {
+		return err
+	}
+	err = writeIrregularitiesToJSON(snapshot.getIrregularities(), s.irregularitiesJsonFilePath, s.seenIrregularities)
+	if err != nil {
+		return err
+	}
+	// scrape quality, a missing or stale scrape is already recorded in the traffic csv (source column)
+	if snapshot.isMissing() || snapshot.isStale() {
+		return nil
+	}
+	return appendDiagnosticsToCSV(snapshot.getTimestamp(), snapshot.getDiagnostics(), s.diagnosticsCsvFilePath)
+}
+
+func (s *CSVStorage) Close() error {
+	return nil
+}
+
+// appendTrafficRecordsToCSV. append the long format rows to the traffic csv file, the existing rows are never rewritten
+func appendTrafficRecordsToCSV(records []trafficRecord, csvPath string) error {
+	fileExists := false
+	if _, err := os.Stat(csvPath); err == nil {
+		fileExists = true
+	}
+
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if !fileExists {
+		if err := w.Write(trafficCsvHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		if record.isMissing() {
+			if err := w.Write([]string{record.getTimestamp().Format(time.RFC3339), "", "", "", SOURCE_MISSING}); err != nil {
+				return err
+			}
+			continue
+		}
+		rec := []string{
+			record.getTimestamp().Format(time.RFC3339),
+			strconv.FormatInt(record.osmWayId, 10),
+			record.direction.String(),
+			fmt.Sprintf("%.2f", record.getSpeed()),
+			record.getSource(),
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// readTrafficRecordsFromCSV. read all rows of a traffic csv file (long or wide format)
+func readTrafficRecordsFromCSV(csvPath string) ([]trafficRecord, error) {
+	records := make([]trafficRecord, 0)
+	err := forEachTrafficCSVRecord(csvPath, func(record trafficRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// forEachTrafficCSVRecord. stream the rows of a traffic csv file. long format files have one record per row, wide format files
+// (timestamp column + one column per osm way & direction) have one record per non empty cell with source SOURCE_CSV
+func forEachTrafficCSVRecord(csvPath string, fn func(record trafficRecord) error) error {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return err
+	}
+	if len(header) == 0 || header[0] != "timestamp" {
+		return errors.New(fmt.Sprintf("%s is not a traffic csv file", csvPath))
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := parseTrafficCSVRow(header, rec, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTrafficCSVRow. call fn for the records of one row of a traffic csv file with the header
+func parseTrafficCSVRow(header, rec []string, fn func(record trafficRecord) error) error {
+	timestamp, err := time.Parse(time.RFC3339, rec[0])
+	if err != nil {
+		return err
+	}
+
+	longFormat := len(header) == len(trafficCsvHeader) && header[1] == trafficCsvHeader[1]
+	if longFormat && len(rec) != len(header) {
+		// e.g. the last row of a file that was cut off while the scraper was writing it
+		return errors.New(fmt.Sprintf("traffic csv row at %s has %d fields, expected %d", rec[0], len(rec), len(header)))
+	}
+	if longFormat && rec[4] == SOURCE_MISSING {
+		return fn(newTrafficRecord(timestamp, 0, datastructure.FORWARD, 0, SOURCE_MISSING))
+	}
+	if longFormat {
+		osmWayId, err := strconv.ParseInt(rec[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		direction := datastructure.FORWARD
+		if rec[2] == datastructure.BACKWARD.String() {
+			direction = datastructure.BACKWARD
+		}
+		speed, err := strconv.ParseFloat(rec[3], 64)
+		if err != nil {
+			return err
+		}
+		return fn(newTrafficRecord(timestamp, osmWayId, direction, speed, rec[4]))
+	}
+
+	for i := 1; i < len(rec) && i < len(header); i++ {
+		if rec[i] == "" {
+			continue
+		}
+		speed, err := strconv.ParseFloat(rec[i], 64)
+		if err != nil {
+			return err
+		}
+		key := parseWayColumn(header[i])
+		if err := fn(newTrafficRecord(timestamp, key.getOsmWayId(), key.getDirection(), speed, SOURCE_CSV)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trafficRecordsToWide. pivot the long format rows into the wide matrix: one row per timestamp (in the record order),
+// one column per (osm way, travel direction) in the order of first appearance. cells without a jam get the default speed
+// of the way (empty if the default speed is unknown), all cells of missing scrapes are empty
+func trafficRecordsToWide(records []trafficRecord, defaultSpeed map[int64]float64) [][]string {
+	headers := []string{"timestamp"}
+	columns := make(map[wayDirectionKey]int)
+	timestamps := make([]time.Time, 0)
+	rows := make(map[time.Time]map[wayDirectionKey]float64)
+	missing := make(map[time.Time]bool)
+	for _, record := range records {
+		if record.isMissing() {
+			if _, ok := rows[record.getTimestamp()]; !ok {
+				timestamps = append(timestamps, record.getTimestamp())
+				rows[record.getTimestamp()] = make(map[wayDirectionKey]float64)
+			}
+			missing[record.getTimestamp()] = true
+			continue
+		}
+		key := record.getKey()
+		if _, ok := columns[key]; !ok {
+			columns[key] = len(headers)
+			headers = append(headers, wayColumnName(key))
+		}
+		if _, ok := rows[record.getTimestamp()]; !ok {
+			timestamps = append(timestamps, record.getTimestamp())
+			rows[record.getTimestamp()] = make(map[wayDirectionKey]float64)
+		}
+		rows[record.getTimestamp()][key] = record.getSpeed()
+	}
+
+	matrix := make([][]string, 0, len(timestamps)+1)
+	matrix = append(matrix, headers)
+	for _, timestamp := range timestamps {
+		row := make([]string, len(headers))
+		row[0] = timestamp.Format(time.RFC3339)
+		if missing[timestamp] {
+			// gap in the time series, keep the cells empty
+			matrix = append(matrix, row)
+			continue
+		}
+		for i, h := range headers[1:] {
+			key := parseWayColumn(h)
+			if speed, ok := rows[timestamp][key]; ok {
+				row[i+1] = fmt.Sprintf("%.2f", speed)
+			} else if speed, ok := defaultSpeed[key.getOsmWayId()]; ok {
+				row[i+1] = fmt.Sprintf("%.2f", speed)
+			}
+		}
+		matrix = append(matrix, row)
+	}
+	return matrix
+}
+
+// ConvertTrafficCSVToWide. convert the long format traffic csv file into the wide traffic csv file
+// (timestamp column + one speed column per osm way & travel direction)
+func ConvertTrafficCSVToWide(longCsvFilePath, wideCsvFilePath string, defaultSpeed map[int64]float64) error {
+	records, err := readTrafficRecordsFromCSV(longCsvFilePath)
+	if err != nil {
+		return err
+	}
+
+	tmpFileName := fmt.Sprintf("%s.%s.csv", wideCsvFilePath, time.Now().Format("20060102_150405"))
+	f, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(trafficRecordsToWide(records, defaultSpeed)); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, wideCsvFilePath)
+}
+
+// wayColumnName. traffic csv column name of an (osm way, travel direction), e.g. 123456_forward
+func wayColumnName(key wayDirectionKey) string {
+	return fmt.Sprintf("%d_%s", key.getOsmWayId(), key.getDirection())
+}
+
+// parseWayColumn. inverse of wayColumnName, columns without direction suffix are forward
+func parseWayColumn(column string) wayDirectionKey {
+	idStr, directionStr, _ := strings.Cut(column, "_")
+	osmWayId, _ := strconv.ParseInt(idStr, 10, 64)
+	direction := datastructure.FORWARD
+	if directionStr == datastructure.BACKWARD.String() {
+		direction = datastructure.BACKWARD
+	}
+	return newWayDirectionKey(osmWayId, direction)
+}
+
+// readMetadataKeysFromCSV. (osm way, travel direction) of the rows of the metadata csv file. a metadata csv file written
+// before the direction column existed (osm_way_id, street, city, end_node, osm_way_street_name) is rewritten with the
+// direction column, its ways are forward
+func readMetadataKeysFromCSV(csvPath string) (map[wayDirectionKey]struct{}, error) {
+	existing := make(map[wayDirectionKey]struct{})
+	if info, err := os.Stat(csvPath); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return existing, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := readWayMetadataCSV(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		direction := datastructure.FORWARD
+		if row.Direction == datastructure.BACKWARD.String() {
+			direction = datastructure.BACKWARD
+		}
+		existing[newWayDirectionKey(row.OsmWayId, direction)] = struct{}{}
+	}
+
+	hasDirection, err := csvHasColumn(csvPath, "direction")
+	if err != nil {
+		return nil, err
+	}
+	if !hasDirection {
+		if err := rewriteMetadataCSV(rows, csvPath); err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
+
+// csvHasColumn. whether the header of the csv file has the column
+func csvHasColumn(csvPath, column string) (bool, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return false, err
+	}
+	for _, h := range header {
+		if h == column {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rewriteMetadataCSV. replace the metadata csv file with the rows in the current column layout
+func rewriteMetadataCSV(rows []parquetWayMetadataRow, csvPath string) error {
+	tmpFileName := csvPath + ".tmp"
+	f, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(metadataCsvHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		rec := []string{strconv.FormatInt(row.OsmWayId, 10), row.Direction, row.Street, row.City, row.EndNode,
+			row.OsmWayStreetName}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, csvPath)
+}
+
+// writeMetadataToCSV. append the metadata of the (osm way, travel direction) that are not in seen to the metadata csv file,
+// seen is updated
+func writeMetadataToCSV(affectedWays map[wayDirectionKey]osmwayTrafficData, csvPath string,
+	seen map[wayDirectionKey]struct{}) error {
+	// an empty file (crash before the header was flushed) gets the header
+	fileExists := false
+	if info, err := os.Stat(csvPath); err == nil && info.Size() > 0 {
+		fileExists = true
+	}
+
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if !fileExists {
+		if err := w.Write(metadataCsvHeader); err != nil {
+			return err
+		}
+	}
+
+	written := make([]wayDirectionKey, 0)
+	for key, info := range affectedWays {
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		rec := []string{
+			strconv.FormatInt(key.getOsmWayId(), 10),
+			key.getDirection().String(),
+			info.getStreet(),
+			info.getCity(),
+			info.getEndNode(),
+			info.getOsmStreet(),
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		seen[key] = struct{}{}
+		written = append(written, key)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		// not on disk, write them again with the next scrape
+		for _, key := range written {
+			delete(seen, key)
+		}
+		return err
+	}
+	return nil
+}
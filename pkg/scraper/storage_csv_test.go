@@ -0,0 +1,160 @@
+package scraper
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertTrafficCSVToWide(t *testing.T) {
+	dir := t.TempDir()
+	longPath := filepath.Join(dir, "waze_traffic_long_test.csv")
+	widePath := filepath.Join(dir, "waze_traffic_test.csv")
+
+	first := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	second := first.Add(20 * time.Second)
+	err := appendTrafficRecordsToCSV([]trafficRecord{
+		newTrafficRecord(first, 1, datastructure.FORWARD, 12, SOURCE_WAZE),
+		newTrafficRecord(first, 2, datastructure.BACKWARD, 8.5, SOURCE_WAZE),
+	}, longPath)
+	assert.Nil(t, err)
+	err = appendTrafficRecordsToCSV([]trafficRecord{
+		newTrafficRecord(second, 1, datastructure.FORWARD, 15, SOURCE_WAZE),
+		newTrafficRecord(second, 3, datastructure.FORWARD, 20, SOURCE_WAZE),
+	}, longPath)
+	assert.Nil(t, err)
+
+	records, err := readTrafficRecordsFromCSV(longPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(records))
+
+	err = ConvertTrafficCSVToWide(longPath, widePath, map[int64]float64{1: 40, 2: 30})
+	assert.Nil(t, err)
+
+	f, err := os.Open(widePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	matrix, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+
+	assert.Equal(t, [][]string{
+		{"timestamp", "1_forward", "2_backward", "3_forward"},
+		{first.Format(time.RFC3339), "12.00", "8.50", ""},
+		{second.Format(time.RFC3339), "15.00", "30.00", "20.00"},
+	}, matrix)
+}
+
+func TestConvertTrafficCSVToWideMissing(t *testing.T) {
+	dir := t.TempDir()
+	longPath := filepath.Join(dir, "waze_traffic_long_test.csv")
+	widePath := filepath.Join(dir, "waze_traffic_test.csv")
+
+	first := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	second := first.Add(20 * time.Second)
+	err := appendTrafficRecordsToCSV(newMissingSnapshot(first, errCircuitOpen).trafficRecords(), longPath)
+	assert.Nil(t, err)
+	err = appendTrafficRecordsToCSV([]trafficRecord{
+		newTrafficRecord(second, 1, datastructure.FORWARD, 15, SOURCE_WAZE),
+	}, longPath)
+	assert.Nil(t, err)
+
+	records, err := readTrafficRecordsFromCSV(longPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.True(t, records[0].isMissing())
+
+	err = ConvertTrafficCSVToWide(longPath, widePath, map[int64]float64{1: 40})
+	assert.Nil(t, err)
+
+	f, err := os.Open(widePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	matrix, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+
+	assert.Equal(t, [][]string{
+		{"timestamp", "1_forward"},
+		{first.Format(time.RFC3339), ""},
+		{second.Format(time.RFC3339), "15.00"},
+	}, matrix)
+}
+
+func TestWriteMetadataToCSVOldHeader(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "waze_metadata_test.csv")
+	// metadata csv file written before the direction column existed
+	assert.Nil(t, os.WriteFile(csvPath, []byte("osm_way_id,street,city,end_node,osm_way_street_name\n"+
+		"1,Jl. Malioboro,Yogyakarta,,Jalan Malioboro\n"), 0644))
+
+	forward := newWayDirectionKey(1, datastructure.FORWARD)
+	backward := newWayDirectionKey(1, datastructure.BACKWARD)
+	affectedWays := map[wayDirectionKey]osmwayTrafficData{
+		forward:  NewOsmWayTrafficData(1, datastructure.FORWARD, 12, "Jl. Malioboro", "Yogyakarta", "", "Jalan Malioboro", nil),
+		backward: NewOsmWayTrafficData(1, datastructure.BACKWARD, 8, "Jl. Malioboro", "Yogyakarta", "", "Jalan Malioboro", nil),
+	}
+	seen, err := readMetadataKeysFromCSV(csvPath)
+	assert.Nil(t, err)
+	assert.Nil(t, writeMetadataToCSV(affectedWays, csvPath, seen))
+	assert.Nil(t, writeMetadataToCSV(affectedWays, csvPath, seen))
+	// after a restart
+	seen, err = readMetadataKeysFromCSV(csvPath)
+	assert.Nil(t, err)
+	assert.Nil(t, writeMetadataToCSV(affectedWays, csvPath, seen))
+
+	rows, err := os.ReadFile(csvPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "osm_way_id,direction,street,city,end_node,osm_way_street_name\n"+
+		"1,forward,Jl. Malioboro,Yogyakarta,,Jalan Malioboro\n"+
+		"1,backward,Jl. Malioboro,Yogyakarta,,Jalan Malioboro\n", string(rows))
+
+	// an empty file gets the header
+	emptyPath := filepath.Join(dir, "waze_metadata_empty_test.csv")
+	assert.Nil(t, os.WriteFile(emptyPath, nil, 0644))
+	seen, err = readMetadataKeysFromCSV(emptyPath)
+	assert.Nil(t, err)
+	assert.Nil(t, writeMetadataToCSV(map[wayDirectionKey]osmwayTrafficData{backward: affectedWays[backward]}, emptyPath,
+		seen))
+	metadataRows, err := readWayMetadataCSV(emptyPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(metadataRows))
+	assert.Equal(t, "backward", metadataRows[0].Direction)
+}
+
+func TestWriteMetadataToCSV(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "waze_metadata_test.csv")
+	key := newWayDirectionKey(1, datastructure.BACKWARD)
+	err := writeMetadataToCSV(map[wayDirectionKey]osmwayTrafficData{
+		key: NewOsmWayTrafficData(1, datastructure.BACKWARD, 12, "Jl. Malioboro", "Yogyakarta", "Tugu", "Jalan Malioboro", nil),
+	}, csvPath, make(map[wayDirectionKey]struct{}))
+	assert.Nil(t, err)
+
+	rows, err := os.ReadFile(csvPath)
+	assert.Nil(t, err)
+	// the waze street and the osm way name are separate columns
+	assert.Equal(t, "osm_way_id,direction,street,city,end_node,osm_way_street_name\n"+
+		"1,backward,Jl. Malioboro,Yogyakarta,Tugu,Jalan Malioboro\n", string(rows))
+}
+
+func TestReadTrafficRecordsFromCSVTruncatedRow(t *testing.T) {
+	longPath := filepath.Join(t.TempDir(), "waze_traffic_long_test.csv")
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	err := appendTrafficRecordsToCSV([]trafficRecord{
+		newTrafficRecord(timestamp, 1, datastructure.FORWARD, 12, SOURCE_WAZE),
+	}, longPath)
+	assert.Nil(t, err)
+
+	// the scraper was killed while writing the last row
+	f, err := os.OpenFile(longPath, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(timestamp.Add(20*time.Second).Format(time.RFC3339) + ",2,forw")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	_, err = readTrafficRecordsFromCSV(longPath)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,418 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+)
+
+// PartitionInterval. time span of one parquet partition
+type PartitionInterval string
+
+const (
+	PARTITION_DAY  PartitionInterval = "day"
+	PARTITION_HOUR PartitionInterval = "hour"
+)
+
+const (
+	// a parquet file is readable only after its footer is written, the open file of a table is finished after this many
+	// rows or this long (also within a partition), which bounds the rows lost or pending if the scraper is killed
+	PARQUET_FILE_MAX_ROWS = 500_000
+	PARQUET_FILE_MAX_AGE  = 10 * time.Minute
+)
+
+func ParsePartitionInterval(interval string) (PartitionInterval, error) {
+	switch PartitionInterval(interval) {
+	case PARTITION_DAY, PARTITION_HOUR:
+		return PartitionInterval(interval), nil
+	default:
+		return "", errors.New(fmt.Sprintf("unknown partition interval %q, expected day or hour", interval))
+	}
+}
+
+// partitionPath. hive style partition directory of the timestamp (utc), e.g. date=2024-01-01/hour=07
+func (p PartitionInterval) partitionPath(timestamp time.Time) string {
+	timestamp = timestamp.UTC()
+	if p == PARTITION_HOUR {
+		return filepath.Join("date="+timestamp.Format("2006-01-02"), "hour="+timestamp.Format("15"))
+	}
+	return "date=" + timestamp.Format("2006-01-02")
+}
+
+type parquetTrafficRow struct {
+	Timestamp time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	OsmWayId  int64     `parquet:"osm_way_id"`
+	Direction string    `parquet:"direction,dict"`
+	Speed     float64   `parquet:"speed"`
+	Source    string    `parquet:"source,dict"`
+}
+
+type parquetJamRow struct {
+	Timestamp      time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	UUID           int64     `parquet:"uuid"`
+	Type           string    `parquet:"type,dict"`
+	Street         string    `parquet:"street,dict"`
+	City           string    `parquet:"city,dict"`
+	EndNode        string    `parquet:"end_node,dict"`
+	SpeedKMH       float64   `parquet:"speed_kmh"`
+	Length         int64     `parquet:"length"`
+	Delay          int64     `parquet:"delay"`
+	Level          int32     `parquet:"level"`
+	Severity       int32     `parquet:"severity"`
+	RoadType       int32     `parquet:"road_type"`
+	BlockType      string    `parquet:"block_type,dict"`
+	CauseAlertType string    `parquet:"cause_alert_type,dict"`
+	PubMillis      int64     `parquet:"pub_millis"`
+	UpdateMillis   int64     `parquet:"update_millis"`
+}
+
+// parquetScrapeRow. diagnostics of a successful (not stale) scrape, DataTimeMillis & DataAgeS are null if waze didn't send the data window
+type parquetScrapeRow struct {
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Jams              int32     `parquet:"jams"`
+	JamsSkipped       int32     `parquet:"jams_skipped"`
+	LinePoints        int32     `parquet:"line_points"`
+	UnmatchedPoints   int32     `parquet:"unmatched_points"`
+	MeanSnapDistanceM float64   `parquet:"mean_snap_distance_m"`
+	AffectedWays      int32     `parquet:"affected_ways"`
+	DataTimeMillis    *int64    `parquet:"data_time_millis,optional"` // EndTimeMillis
+	DataAgeS          *float64  `parquet:"data_age_s,optional"`
+}
+
+// parquetIrregularityRow. one row per update of a waze irregularity, Timestamp is the scrape that saw the update first.
+// Line is the json array of [lon, lat] points
+type parquetIrregularityRow struct {
+	Timestamp       time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ID              int64     `parquet:"id"`
+	UpdateMillis    int64     `parquet:"update_millis"`
+	Type            string    `parquet:"type,dict"`
+	Street          string    `parquet:"street,dict"`
+	City            string    `parquet:"city,dict"`
+	StartNode       string    `parquet:"start_node,dict"`
+	EndNode         string    `parquet:"end_node,dict"`
+	Speed           float64   `parquet:"speed"`
+	RegularSpeed    float64   `parquet:"regular_speed"`
+	DelaySeconds    int64     `parquet:"delay_seconds"`
+	Length          int64     `parquet:"length"`
+	Trend           int32     `parquet:"trend"`
+	Severity        int32     `parquet:"severity"`
+	JamLevel        int32     `parquet:"jam_level"`
+	DriversCount    int32     `parquet:"drivers_count"`
+	AlertsCount     int32     `parquet:"alerts_count"`
+	Highway         bool      `parquet:"highway"`
+	CauseType       string    `parquet:"cause_type,dict"`
+	DetectionMillis int64     `parquet:"detection_millis"`
+	Line            string    `parquet:"line"`
+}
+
+type parquetWayMetadataRow struct {
+	OsmWayId         int64  `parquet:"osm_way_id"`
+	Direction        string `parquet:"direction,dict"`
+	Street           string `parquet:"street,dict"`
+	City             string `parquet:"city,dict"`
+	EndNode          string `parquet:"end_node,dict"`
+	OsmWayStreetName string `parquet:"osm_way_street_name,dict"`
+}
+
+// parquetPartitionWriter. parquet writer of one table that starts a new file whenever the partition (day or hour) changes
+// or the file is full (maxRows rows or older than maxAge). the file is written as part-*.parquet.tmp and renamed to
+// part-*.parquet when it is complete, so readers never see a file without parquet footer
+type parquetPartitionWriter[T any] struct {
+	dir       string
+	interval  PartitionInterval
+	maxRows   int
+	maxAge    time.Duration
+	partition string
+	filePath  string
+	file      *os.File
+	writer    *parquet.GenericWriter[T]
+	rows      int
+	opened    time.Time
+}
+
+func newParquetPartitionWriter[T any](dir string, interval PartitionInterval) *parquetPartitionWriter[T] {
+	return &parquetPartitionWriter[T]{
+		dir:      dir,
+		interval: interval,
+		maxRows:  PARQUET_FILE_MAX_ROWS,
+		maxAge:   PARQUET_FILE_MAX_AGE,
+	}
+}
+
+func (w *parquetPartitionWriter[T]) write(timestamp time.Time, rows []T) error {
+	partition := w.interval.partitionPath(timestamp)
+	if w.writer != nil && (partition != w.partition || w.rows >= w.maxRows || time.Since(w.opened) >= w.maxAge) {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+
+	if w.writer == nil {
+		partitionDir := filepath.Join(w.dir, partition)
+		if err := os.MkdirAll(partitionDir, 0755); err != nil {
+			return err
+		}
+		w.filePath = filepath.Join(partitionDir, fmt.Sprintf("part-%s.parquet", time.Now().UTC().Format("20060102T150405.000000000")))
+		f, err := os.Create(w.filePath + ".tmp")
+		if err != nil {
+			return err
+		}
+		w.file = f
+		w.partition = partition
+		w.writer = parquet.NewGenericWriter[T](f, parquet.Compression(&parquet.Zstd))
+		w.rows, w.opened = 0, time.Now()
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+	if _, err := w.writer.Write(rows); err != nil {
+		return err
+	}
+	w.rows += len(rows)
+	return w.writer.Flush()
+}
+
+func (w *parquetPartitionWriter[T]) close() error {
+	if w.writer == nil {
+		return nil
+	}
+	writer, file, filePath := w.writer, w.file, w.filePath
+	w.writer, w.file, w.filePath, w.partition = nil, nil, "", ""
+
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+// ParquetStorage. columnar storage of the per scrape way speeds (traffic table), raw jams (jams table), way metadata
+// (way_metadata table), irregularity updates (irregularities table) and scrape diagnostics (scrapes table), every table is
+// partitioned by day or hour: <dir>/<table>/date=2024-01-01[/hour=07]/part-*.parquet. the metadata & irregularities
+// already written are only remembered until a restart, so they can be written again after one
+type ParquetStorage struct {
+	traffic            *parquetPartitionWriter[parquetTrafficRow]
+	jams               *parquetPartitionWriter[parquetJamRow]
+	metadata           *parquetPartitionWriter[parquetWayMetadataRow]
+	irregularities     *parquetPartitionWriter[parquetIrregularityRow]
+	scrapes            *parquetPartitionWriter[parquetScrapeRow]
+	seenMetadata       map[wayDirectionKey]struct{}
+	seenIrregularities map[irregularityKey]struct{}
+}
+
+// NewParquetStorage. the part-*.parquet.tmp files left by a killed scraper are finished first: a file with its parquet
+// footer (killed before the rename) is renamed to part-*.parquet, a file without footer can't be read and is renamed to
+// part-*.parquet.corrupt
+func NewParquetStorage(dir string, interval PartitionInterval, log *zap.Logger) (*ParquetStorage, error) {
+	if err := recoverParquetFiles(dir, log); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to recover the parquet files of %s: %s", dir, err.Error()))
+	}
+	return &ParquetStorage{
+		traffic:            newParquetPartitionWriter[parquetTrafficRow](filepath.Join(dir, "traffic"), interval),
+		jams:               newParquetPartitionWriter[parquetJamRow](filepath.Join(dir, "jams"), interval),
+		metadata:           newParquetPartitionWriter[parquetWayMetadataRow](filepath.Join(dir, "way_metadata"), interval),
+		irregularities:     newParquetPartitionWriter[parquetIrregularityRow](filepath.Join(dir, "irregularities"), interval),
+		scrapes:            newParquetPartitionWriter[parquetScrapeRow](filepath.Join(dir, "scrapes"), interval),
+		seenMetadata:       make(map[wayDirectionKey]struct{}),
+		seenIrregularities: make(map[irregularityKey]struct{}),
+	}, nil
+}
+
+// recoverParquetFiles. finish the unfinished parquet files of every table & partition of dir, see NewParquetStorage
+func recoverParquetFiles(dir string, log *zap.Logger) error {
+	tmpFiles := make([]string, 0)
+	for _, pattern := range []string{"date=*/part-*.parquet.tmp", "date=*/hour=*/part-*.parquet.tmp"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*", pattern))
+		if err != nil {
+			return err
+		}
+		tmpFiles = append(tmpFiles, files...)
+	}
+
+	for _, tmpFile := range tmpFiles {
+		filePath := strings.TrimSuffix(tmpFile, ".tmp")
+		if err := checkParquetFile(tmpFile); err != nil {
+			log.Sugar().Warnf("quarantined %s, the scraper was killed before the parquet footer was written: %s",
+				tmpFile, err.Error())
+			filePath += ".corrupt"
+		} else {
+			log.Sugar().Infof("finished %s, the scraper was killed before renaming it", tmpFile)
+		}
+		if err := os.Rename(tmpFile, filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParquetFile. error if the file has no valid parquet footer
+func checkParquetFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = parquet.OpenFile(f, info.Size())
+	return err
+}
+
+func (s *ParquetStorage) Write(snapshot scrapeSnapshot) error {
+	timestamp := snapshot.getTimestamp()
+
+	trafficRows := make([]parquetTrafficRow, 0, len(snapshot.getAffectedWays()))
+	for _, record := range snapshot.trafficRecords() {
+		trafficRows = append(trafficRows, newParquetTrafficRow(record))
+	}
+	if err := s.traffic.write(timestamp, trafficRows); err != nil {
+		return err
+	}
+
+	jamRows := make([]parquetJamRow, 0, len(snapshot.getJams()))
+	for _, jam := range snapshot.getJams() {
+		jamRows = append(jamRows, parquetJamRow{
+			Timestamp:      timestamp,
+			UUID:           jam.UUID,
+			Type:           jam.Type,
+			Street:         jam.Street,
+			City:           jam.City,
+			EndNode:        jam.EndNode,
+			SpeedKMH:       jam.SpeedKMH,
+			Length:         int64(jam.Length),
+			Delay:          int64(jam.Delay),
+			Level:          int32(jam.Level),
+			Severity:       int32(jam.Severity),
+			RoadType:       int32(jam.RoadType),
+			BlockType:      jam.BlockType,
+			CauseAlertType: jam.CauseAlert.Type,
+			PubMillis:      jam.PubMillis,
+			UpdateMillis:   jam.UpdateMillis,
+		})
+	}
+	if err := s.jams.write(timestamp, jamRows); err != nil {
+		return err
+	}
+
+	if !snapshot.isMissing() && !snapshot.isStale() {
+		scrapeRow := newParquetScrapeRow(timestamp, snapshot.getDiagnostics())
+		if err := s.scrapes.write(timestamp, []parquetScrapeRow{scrapeRow}); err != nil {
+			return err
+		}
+	}
+
+	irregularityRows := make([]parquetIrregularityRow, 0)
+	for _, irregularity := range snapshot.getIrregularities() {
+		key := irregularityKey{id: irregularity.ID, updateTime: irregularity.UpdateDateMillis}
+		if _, ok := s.seenIrregularities[key]; ok {
+			continue
+		}
+		row, err := newParquetIrregularityRow(timestamp, irregularity)
+		if err != nil {
+			return err
+		}
+		s.seenIrregularities[key] = struct{}{}
+		irregularityRows = append(irregularityRows, row)
+	}
+	if len(irregularityRows) > 0 {
+		if err := s.irregularities.write(timestamp, irregularityRows); err != nil {
+			return err
+		}
+	}
+
+	metadataRows := make([]parquetWayMetadataRow, 0)
+	for _, key := range snapshot.sortedKeys() {
+		if _, ok := s.seenMetadata[key]; ok {
+			continue
+		}
+		s.seenMetadata[key] = struct{}{}
+		info := snapshot.getAffectedWays()[key]
+		metadataRows = append(metadataRows, parquetWayMetadataRow{
+			OsmWayId:         key.getOsmWayId(),
+			Direction:        key.getDirection().String(),
+			Street:           info.getStreet(),
+			City:             info.getCity(),
+			EndNode:          info.getEndNode(),
+			OsmWayStreetName: info.getOsmStreet(),
+		})
+	}
+	if len(metadataRows) == 0 {
+		return nil
+	}
+	return s.metadata.write(timestamp, metadataRows)
+}
+
+// Close. finish the parquet files of the current partitions
+func (s *ParquetStorage) Close() error {
+	return errors.Join(s.traffic.close(), s.jams.close(), s.metadata.close(), s.irregularities.close(), s.scrapes.close())
+}
+
+func newParquetTrafficRow(record trafficRecord) parquetTrafficRow {
+	return parquetTrafficRow{
+		Timestamp: record.getTimestamp(),
+		OsmWayId:  record.osmWayId,
+		Direction: record.direction.String(),
+		Speed:     record.getSpeed(),
+		Source:    record.getSource(),
+	}
+}
+
+func newParquetIrregularityRow(timestamp time.Time, irregularity wazeIrregularity) (parquetIrregularityRow, error) {
+	line, err := json.Marshal(newIrregularityRecord(irregularity).Line)
+	if err != nil {
+		return parquetIrregularityRow{}, err
+	}
+	return parquetIrregularityRow{
+		Timestamp:       timestamp,
+		ID:              irregularity.ID,
+		UpdateMillis:    irregularity.UpdateDateMillis,
+		Type:            irregularity.Type,
+		Street:          irregularity.Street,
+		City:            irregularity.City,
+		StartNode:       irregularity.StartNode,
+		EndNode:         irregularity.EndNode,
+		Speed:           irregularity.Speed,
+		RegularSpeed:    irregularity.RegularSpeed,
+		DelaySeconds:    int64(irregularity.DelaySeconds),
+		Length:          int64(irregularity.Length),
+		Trend:           int32(irregularity.Trend),
+		Severity:        int32(irregularity.Severity),
+		JamLevel:        int32(irregularity.JamLevel),
+		DriversCount:    int32(irregularity.DriversCount),
+		AlertsCount:     int32(irregularity.AlertsCount),
+		Highway:         irregularity.Highway,
+		CauseType:       irregularity.CauseType,
+		DetectionMillis: irregularity.DetectionDateMillis,
+		Line:            string(line),
+	}, nil
+}
+
+func newParquetScrapeRow(timestamp time.Time, d scrapeDiagnostics) parquetScrapeRow {
+	row := parquetScrapeRow{
+		Timestamp:         timestamp,
+		Jams:              int32(d.getJams()),
+		JamsSkipped:       int32(d.getJamsSkipped()),
+		LinePoints:        int32(d.getLinePoints()),
+		UnmatchedPoints:   int32(d.getUnmatchedPoints()),
+		MeanSnapDistanceM: d.getMeanSnapDistance(),
+		AffectedWays:      int32(d.getAffectedWays()),
+	}
+	if dataTime, ok := d.getDataTime(); ok {
+		dataTimeMillis, dataAge := dataTime.UnixMilli(), d.getDataAge().Seconds()
+		row.DataTimeMillis, row.DataAgeS = &dataTimeMillis, &dataAge
+	}
+	return row
+}
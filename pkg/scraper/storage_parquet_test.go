@@ -0,0 +1,144 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestParquetStorageRollsPartitions(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewParquetStorage(dir, PARTITION_HOUR, zap.NewNop())
+	assert.Nil(t, err)
+
+	timestamp := time.Date(2024, 1, 1, 7, 59, 40, 0, time.UTC)
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp)))
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(20*time.Second))))
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(40*time.Second))))
+	assert.Nil(t, storage.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "traffic", "date=2024-01-01", "hour=*", "*.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files))
+
+	assert.Equal(t, "hour=08", filepath.Base(filepath.Dir(files[1])))
+	rows, err := parquet.ReadFile[parquetTrafficRow](files[1])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, int64(1), rows[0].OsmWayId)
+	assert.Equal(t, "backward", rows[0].Direction)
+	assert.True(t, timestamp.Add(20*time.Second).Equal(rows[0].Timestamp))
+
+	scrapeFiles, err := filepath.Glob(filepath.Join(dir, "scrapes", "date=2024-01-01", "hour=*", "*.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(scrapeFiles))
+	scrapeRows, err := parquet.ReadFile[parquetScrapeRow](scrapeFiles[1])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(scrapeRows))
+	assert.Equal(t, 3.5, scrapeRows[0].MeanSnapDistanceM)
+	assert.Equal(t, 60.0, *scrapeRows[0].DataAgeS)
+
+	// the irregularity is written once, by the scrape that saw its update first
+	irregularityFiles, err := filepath.Glob(filepath.Join(dir, "irregularities", "date=2024-01-01", "hour=*", "*.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(irregularityFiles))
+	irregularityRows, err := parquet.ReadFile[parquetIrregularityRow](irregularityFiles[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(irregularityRows))
+	assert.Equal(t, int64(7), irregularityRows[0].ID)
+	assert.True(t, timestamp.Equal(irregularityRows[0].Timestamp))
+	assert.Equal(t, "[[110.36,-7.79],[110.37,-7.79]]", irregularityRows[0].Line)
+}
+
+func TestParquetStorageRollsFiles(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewParquetStorage(dir, PARTITION_DAY, zap.NewNop())
+	assert.Nil(t, err)
+	storage.traffic.maxRows = 2
+
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(time.Duration(i)*20*time.Second))))
+	}
+
+	// the full file is readable while the partition is still being written
+	files, err := filepath.Glob(filepath.Join(dir, "traffic", "date=2024-01-01", "*.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	rows, err := parquet.ReadFile[parquetTrafficRow](files[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+	tmpFiles, err := filepath.Glob(filepath.Join(dir, "traffic", "date=2024-01-01", "*.parquet.tmp"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tmpFiles))
+	assert.Nil(t, storage.Close())
+}
+
+func TestNewParquetStorageRecoversFiles(t *testing.T) {
+	dir := t.TempDir()
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	partitionDir := filepath.Join(dir, "traffic", "date=2024-01-01")
+	assert.Nil(t, os.MkdirAll(partitionDir, 0755))
+	// killed after the footer was written but before the rename
+	finished := filepath.Join(partitionDir, "part-20240101T070000.000000000.parquet")
+	assert.Nil(t, parquet.WriteFile(finished+".tmp", []parquetTrafficRow{{Timestamp: timestamp, OsmWayId: 1}}))
+
+	// killed while writing, the flushed rows have no footer
+	storage, err := NewParquetStorage(dir, PARTITION_DAY, zap.NewNop())
+	assert.Nil(t, err)
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp)))
+	unfinished := storage.traffic.filePath
+
+	_, err = NewParquetStorage(dir, PARTITION_DAY, zap.NewNop())
+	assert.Nil(t, err)
+	rows, err := parquet.ReadFile[parquetTrafficRow](finished)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rows))
+	_, err = os.Stat(unfinished + ".corrupt")
+	assert.Nil(t, err)
+	tmpFiles, err := filepath.Glob(filepath.Join(dir, "*", "date=2024-01-01", "*.tmp"))
+	assert.Nil(t, err)
+	assert.Empty(t, tmpFiles)
+}
+
+func TestExportCSVToParquet(t *testing.T) {
+	dir := t.TempDir()
+	trafficPath := filepath.Join(dir, "waze_traffic_test.csv")
+	metadataPath := filepath.Join(dir, "waze_metadata_test.csv")
+	assert.Nil(t, os.WriteFile(trafficPath, []byte("timestamp,1_forward,2_backward,3\n"+
+		"2024-01-01T23:59:50Z,12.00,8.50,\n"+
+		"2024-01-02T00:00:10Z,15.00,30.00,20.00\n"), 0644))
+	assert.Nil(t, os.WriteFile(metadataPath, []byte("osm_way_id,street,city,end_node,osm_way_street_name\n"+
+		"1,Jl. Malioboro,Yogyakarta,,Jalan Malioboro\n"), 0644))
+
+	out := filepath.Join(dir, "parquet")
+	assert.Nil(t, ExportCSVToParquet(trafficPath, metadataPath, out, PARTITION_DAY))
+
+	firstDay, err := filepath.Glob(filepath.Join(out, "traffic", "date=2024-01-01", "*.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(firstDay))
+	rows, err := parquet.ReadFile[parquetTrafficRow](firstDay[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, SOURCE_CSV, rows[0].Source)
+
+	secondDay, err := filepath.Glob(filepath.Join(out, "traffic", "date=2024-01-02", "*.parquet"))
+	assert.Nil(t, err)
+	rows, err = parquet.ReadFile[parquetTrafficRow](secondDay[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, int64(3), rows[2].OsmWayId)
+	assert.Equal(t, "forward", rows[2].Direction)
+
+	metadataFiles, err := filepath.Glob(filepath.Join(out, "way_metadata", "*.parquet"))
+	assert.Nil(t, err)
+	metadata, err := parquet.ReadFile[parquetWayMetadataRow](metadataFiles[0])
+	assert.Nil(t, err)
+	assert.Equal(t, []parquetWayMetadataRow{{OsmWayId: 1, Direction: "forward", Street: "Jl. Malioboro",
+		City: "Yogyakarta", OsmWayStreetName: "Jalan Malioboro"}}, metadata)
+}
@@ -0,0 +1,298 @@
+package scraper
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema. timestamps are unix seconds, e.g. select datetime(timestamp, 'unixepoch') from traffic
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS scrapes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		jams INTEGER NOT NULL,
+		alerts INTEGER NOT NULL,
+		affected_ways INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'ok',
+		error TEXT,
+		jams_skipped INTEGER,
+		line_points INTEGER,
+		unmatched_points INTEGER,
+		mean_snap_distance_m REAL,
+		data_time INTEGER,
+		data_age_s REAL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_scrapes_timestamp ON scrapes (timestamp)`,
+	`CREATE TABLE IF NOT EXISTS traffic (
+		scrape_id INTEGER NOT NULL REFERENCES scrapes (id),
+		timestamp INTEGER NOT NULL,
+		osm_way_id INTEGER NOT NULL,
+		direction TEXT NOT NULL,
+		speed REAL NOT NULL,
+		source TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_traffic_timestamp ON traffic (timestamp)`,
+	`CREATE INDEX IF NOT EXISTS idx_traffic_way_timestamp ON traffic (osm_way_id, timestamp)`,
+	`CREATE TABLE IF NOT EXISTS way_ranges (
+		scrape_id INTEGER NOT NULL REFERENCES scrapes (id),
+		timestamp INTEGER NOT NULL,
+		osm_way_id INTEGER NOT NULL,
+		direction TEXT NOT NULL,
+		start_m REAL NOT NULL,
+		end_m REAL NOT NULL,
+		affected INTEGER NOT NULL,
+		speed REAL NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_way_ranges_timestamp ON way_ranges (timestamp)`,
+	`CREATE INDEX IF NOT EXISTS idx_way_ranges_way_timestamp ON way_ranges (osm_way_id, timestamp)`,
+	`CREATE TABLE IF NOT EXISTS way_metadata (
+		osm_way_id INTEGER NOT NULL,
+		direction TEXT NOT NULL,
+		street TEXT,
+		city TEXT,
+		end_node TEXT,
+		osm_way_street_name TEXT,
+		PRIMARY KEY (osm_way_id, direction)
+	)`,
+	`CREATE TABLE IF NOT EXISTS jams (
+		scrape_id INTEGER NOT NULL REFERENCES scrapes (id),
+		timestamp INTEGER NOT NULL,
+		uuid INTEGER NOT NULL,
+		type TEXT,
+		street TEXT,
+		city TEXT,
+		end_node TEXT,
+		speed_kmh REAL,
+		length INTEGER,
+		delay INTEGER,
+		level INTEGER,
+		severity INTEGER,
+		road_type INTEGER,
+		block_type TEXT,
+		cause_alert_type TEXT,
+		pub_millis INTEGER,
+		update_millis INTEGER,
+		line TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_jams_timestamp ON jams (timestamp)`,
+	`CREATE INDEX IF NOT EXISTS idx_jams_uuid ON jams (uuid)`,
+	`CREATE TABLE IF NOT EXISTS alerts (
+		uuid TEXT PRIMARY KEY,
+		type TEXT,
+		subtype TEXT,
+		street TEXT,
+		city TEXT,
+		lon REAL,
+		lat REAL,
+		reliability INTEGER,
+		confidence INTEGER,
+		pub_millis INTEGER,
+		osm_way_id INTEGER,
+		osm_way_street_name TEXT,
+		snap_distance_m REAL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_alerts_pub_millis ON alerts (pub_millis)`,
+	`CREATE INDEX IF NOT EXISTS idx_alerts_way ON alerts (osm_way_id)`,
+	`CREATE TABLE IF NOT EXISTS irregularities (
+		id INTEGER NOT NULL,
+		update_millis INTEGER NOT NULL,
+		type TEXT,
+		street TEXT,
+		city TEXT,
+		start_node TEXT,
+		end_node TEXT,
+		speed REAL,
+		regular_speed REAL,
+		delay_seconds INTEGER,
+		length INTEGER,
+		trend INTEGER,
+		severity INTEGER,
+		jam_level INTEGER,
+		drivers_count INTEGER,
+		alerts_count INTEGER,
+		highway INTEGER,
+		cause_type TEXT,
+		detection_millis INTEGER,
+		line TEXT,
+		PRIMARY KEY (id, update_millis)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_irregularities_update_millis ON irregularities (update_millis)`,
+}
+
+// sqliteMigrations. columns added after the first release of the schema, "duplicate column" errors are ignored
+var sqliteMigrations = []string{
+	`ALTER TABLE scrapes ADD COLUMN status TEXT NOT NULL DEFAULT 'ok'`,
+	`ALTER TABLE scrapes ADD COLUMN error TEXT`,
+	`ALTER TABLE scrapes ADD COLUMN jams_skipped INTEGER`,
+	`ALTER TABLE scrapes ADD COLUMN line_points INTEGER`,
+	`ALTER TABLE scrapes ADD COLUMN unmatched_points INTEGER`,
+	`ALTER TABLE scrapes ADD COLUMN mean_snap_distance_m REAL`,
+	`ALTER TABLE scrapes ADD COLUMN data_time INTEGER`,
+	`ALTER TABLE scrapes ADD COLUMN data_age_s REAL`,
+}
+
+// SQLiteStorage. writes every scrape (affected ways, way ranges, metadata, raw jams, alerts & irregularities) into a local
+// sqlite database
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", dbPath))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to open sqlite database %s: %s", dbPath, err.Error()))
+	}
+	// a single writer, sqlite doesn't support concurrent writes
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, errors.New(fmt.Sprintf("failed to create sqlite schema: %s", err.Error()))
+		}
+	}
+	for _, stmt := range sqliteMigrations {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, errors.New(fmt.Sprintf("failed to migrate sqlite schema: %s", err.Error()))
+		}
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// Write. write the snapshot in one transaction
+func (s *SQLiteStorage) Write(snapshot scrapeSnapshot) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	timestamp := snapshot.getTimestamp().Unix()
+	if snapshot.isMissing() {
+		// the gap is recorded in the scrapes table only
+		_, err := tx.Exec(`INSERT INTO scrapes (timestamp, jams, alerts, affected_ways, status, error) VALUES (?, 0, 0, 0, ?, ?)`,
+			timestamp, SOURCE_MISSING, snapshot.getReason())
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	diagnostics := snapshot.getDiagnostics()
+	var dataTime, dataAge any
+	if t, ok := diagnostics.getDataTime(); ok {
+		dataTime = t.Unix()
+		dataAge = diagnostics.getDataAge().Seconds()
+	}
+	status := "ok"
+	if snapshot.isStale() {
+		status = SOURCE_STALE
+	}
+	res, err := tx.Exec(`INSERT INTO scrapes (timestamp, jams, alerts, affected_ways, status, jams_skipped, line_points,
+		unmatched_points, mean_snap_distance_m, data_time, data_age_s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		timestamp, len(snapshot.getJams()), len(snapshot.getAlerts()), len(snapshot.getAffectedWays()), status,
+		diagnostics.getJamsSkipped(), diagnostics.getLinePoints(), diagnostics.getUnmatchedPoints(),
+		diagnostics.getMeanSnapDistance(), dataTime, dataAge)
+	if err != nil {
+		return err
+	}
+	scrapeId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range snapshot.trafficRecords() {
+		_, err := tx.Exec(`INSERT INTO traffic (scrape_id, timestamp, osm_way_id, direction, speed, source)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			scrapeId, timestamp, record.osmWayId, record.direction.String(), record.getSpeed(), record.getSource())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range snapshot.sortedKeys() {
+		for _, wr := range snapshot.getWayRanges()[key] {
+			_, err := tx.Exec(`INSERT INTO way_ranges (scrape_id, timestamp, osm_way_id, direction, start_m, end_m, affected, speed)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				scrapeId, timestamp, key.getOsmWayId(), key.getDirection().String(), wr.GetStart(), wr.GetEnd(),
+				wr.IsAffected(), wr.GetSpeed())
+			if err != nil {
+				return err
+			}
+		}
+
+		info := snapshot.getAffectedWays()[key]
+		_, err := tx.Exec(`INSERT OR IGNORE INTO way_metadata (osm_way_id, direction, street, city, end_node, osm_way_street_name)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			key.getOsmWayId(), key.getDirection().String(), info.getStreet(), info.getCity(), info.getEndNode(),
+			info.getOsmStreet())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, jam := range snapshot.getJams() {
+		line, err := json.Marshal(jam.Line)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO jams (scrape_id, timestamp, uuid, type, street, city, end_node, speed_kmh, length,
+			delay, level, severity, road_type, block_type, cause_alert_type, pub_millis, update_millis, line)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			scrapeId, timestamp, jam.UUID, jam.Type, jam.Street, jam.City, jam.EndNode, jam.SpeedKMH, jam.Length,
+			jam.Delay, jam.Level, jam.Severity, jam.RoadType, jam.BlockType, jam.CauseAlert.Type, jam.PubMillis,
+			jam.UpdateMillis, string(line))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, alert := range snapshot.getAlerts() {
+		var osmWayId, snapDistance any
+		if alert.getOsmWayId() != -1 {
+			osmWayId = alert.getOsmWayId()
+			snapDistance = alert.getSnapDistance() * 1000
+		}
+		lon, lat := alert.getLonLat()
+		_, err := tx.Exec(`INSERT OR IGNORE INTO alerts (uuid, type, subtype, street, city, lon, lat, reliability,
+			confidence, pub_millis, osm_way_id, osm_way_street_name, snap_distance_m)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			alert.getUUID(), alert.getType(), alert.getSubtype(), alert.getStreet(), alert.getCity(), lon, lat,
+			alert.getReliability(), alert.getConfidence(), alert.getPubMillis(), osmWayId, alert.getOsmStreet(),
+			snapDistance)
+		if err != nil {
+			return err
+		}
+	}
+
+	// every update of an irregularity is a row, the (id, update time) primary key skips the updates already written
+	for _, irregularity := range snapshot.getIrregularities() {
+		line, err := json.Marshal(newIrregularityRecord(irregularity).Line)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR IGNORE INTO irregularities (id, update_millis, type, street, city, start_node, end_node,
+			speed, regular_speed, delay_seconds, length, trend, severity, jam_level, drivers_count, alerts_count, highway,
+			cause_type, detection_millis, line) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			irregularity.ID, irregularity.UpdateDateMillis, irregularity.Type, irregularity.Street, irregularity.City,
+			irregularity.StartNode, irregularity.EndNode, irregularity.Speed, irregularity.RegularSpeed,
+			irregularity.DelaySeconds, irregularity.Length, irregularity.Trend, irregularity.Severity, irregularity.JamLevel,
+			irregularity.DriversCount, irregularity.AlertsCount, irregularity.Highway, irregularity.CauseType,
+			irregularity.DetectionDateMillis, string(line))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,81 @@
+package scraper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSnapshot(timestamp time.Time) scrapeSnapshot {
+	key := newWayDirectionKey(1, datastructure.BACKWARD)
+	jamRange := datastructure.NewWayRange(100, 300, 12, true)
+	return scrapeSnapshot{
+		timestamp: timestamp,
+		affectedWays: map[wayDirectionKey]osmwayTrafficData{
+			key: NewOsmWayTrafficData(1, datastructure.BACKWARD, 12, "Jl. Malioboro", "Yogyakarta", "", "Jalan Malioboro",
+				[]datastructure.WayRange{jamRange}),
+		},
+		wayRanges: map[wayDirectionKey][]datastructure.WayRange{
+			key: datastructure.SplitWayRanges([]datastructure.WayRange{jamRange}, 500, 40),
+		},
+		alerts: []alertData{
+			NewAlertData(wazeAlert{UUID: "a-1", Type: "ACCIDENT"}, 1, "Jalan Malioboro", 0.004),
+		},
+		irregularities: []wazeIrregularity{{ID: 7, Type: "LARGE", Speed: 8, RegularSpeed: 35, UpdateDateMillis: 1704092400000,
+			Line: []wazePoint{{110.36, -7.79}, {110.37, -7.79}}}},
+		jams: []wazeJam{{UUID: 10, SpeedKMH: 12, Line: []wazePoint{{110.36, -7.79}, {110.37, -7.79}}}},
+		diagnostics: scrapeDiagnostics{jams: 1, linePoints: 2, meanSnapDistance: 3.5, affectedWays: 1,
+			dataTime: timestamp.Add(-time.Minute), dataAge: time.Minute},
+	}
+}
+
+func TestSQLiteStorageWrite(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "waze_traffic_test.db"))
+	assert.Nil(t, err)
+	defer storage.Close()
+
+	timestamp := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp)))
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(20*time.Second))))
+
+	var speed float64
+	var direction string
+	err = storage.db.QueryRow(`SELECT speed, direction FROM traffic WHERE osm_way_id = 1 AND timestamp = ?`,
+		timestamp.Unix()).Scan(&speed, &direction)
+	assert.Nil(t, err)
+	assert.Equal(t, 12.0, speed)
+	assert.Equal(t, "backward", direction)
+
+	var unmatchedPoints int
+	var snapDistance, dataAge float64
+	err = storage.db.QueryRow(`SELECT unmatched_points, mean_snap_distance_m, data_age_s FROM scrapes WHERE timestamp = ?`,
+		timestamp.Unix()).Scan(&unmatchedPoints, &snapDistance, &dataAge)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, unmatchedPoints)
+	assert.Equal(t, 3.5, snapDistance)
+	assert.Equal(t, 60.0, dataAge)
+
+	// a stale scrape is flagged in the scrapes table & the traffic source
+	assert.Nil(t, storage.Write(newTestSnapshot(timestamp.Add(20*time.Second)).flagStale()))
+	var status, source string
+	err = storage.db.QueryRow(`SELECT s.status, t.source FROM scrapes s JOIN traffic t ON t.scrape_id = s.id
+		WHERE s.id = (SELECT MAX(id) FROM scrapes)`).Scan(&status, &source)
+	assert.Nil(t, err)
+	assert.Equal(t, SOURCE_STALE, status)
+	assert.Equal(t, SOURCE_STALE, source)
+
+	var line string
+	assert.Nil(t, storage.db.QueryRow(`SELECT line FROM irregularities WHERE id = 7`).Scan(&line))
+	assert.Equal(t, "[[110.36,-7.79],[110.37,-7.79]]", line)
+
+	counts := map[string]int{"scrapes": 3, "traffic": 3, "way_ranges": 9, "way_metadata": 1, "jams": 3, "alerts": 1,
+		"irregularities": 1}
+	for table, expected := range counts {
+		var count int
+		assert.Nil(t, storage.db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&count))
+		assert.Equal(t, expected, count, table)
+	}
+}
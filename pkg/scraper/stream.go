@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"sync"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// Subscription. traffic diffs of every published snapshot for one subscriber. a subscriber that lets its buffer fill
+// up is dropped (Diffs is closed and Lagged is true) instead of blocking the scrape loop, it has to subscribe again
+type Subscription struct {
+	diffs  chan datastructure.TrafficDiff
+	keep   func(wayId int64) bool
+	lagged bool // written before diffs is closed
+	stream *diffBroadcaster
+}
+
+// Diffs. closed when the subscription is closed or dropped
+func (s *Subscription) Diffs() <-chan datastructure.TrafficDiff {
+	return s.diffs
+}
+
+// Lagged. true if the subscription was dropped because the subscriber fell behind, only valid once Diffs is closed
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// Close. unsubscribe, safe to call after the subscription was dropped
+func (s *Subscription) Close() {
+	s.stream.unsubscribe(s)
+}
+
+func (s *Subscription) filter(diff datastructure.TrafficDiff) datastructure.TrafficDiff {
+	if s.keep == nil {
+		return diff
+	}
+	return diff.Filter(s.keep)
+}
+
+// diffBroadcaster. sends the diff of every published snapshot to the subscribers
+type diffBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	snapshot    datastructure.TrafficSnapshot // last broadcast snapshot
+	closures    []datastructure.RoadClosure   // active closures of the last broadcast snapshot
+	hasSnapshot bool
+	metrics     scraperMetrics
+}
+
+func newDiffBroadcaster(metrics scraperMetrics) *diffBroadcaster {
+	return &diffBroadcaster{
+		subscribers: make(map[*Subscription]struct{}),
+		metrics:     metrics,
+	}
+}
+
+// subscribe. the first diff of the subscription is from an empty state to the last broadcast snapshot, so applying
+// every diff in order gives the latest traffic without a gap or an overlap with the broadcasts
+func (b *diffBroadcaster) subscribe(buffer int, keep func(wayId int64) bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &Subscription{
+		diffs:  make(chan datastructure.TrafficDiff, max(buffer, 1)),
+		keep:   keep,
+		stream: b,
+	}
+	if b.hasSnapshot {
+		s.diffs <- s.filter(datastructure.NewTrafficDiff(datastructure.TrafficSnapshot{}, b.snapshot, nil, b.closures))
+	}
+	b.subscribers[s] = struct{}{}
+	b.metrics.observeStreamSubscribers(len(b.subscribers))
+	return s
+}
+
+func (b *diffBroadcaster) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.diffs)
+		b.metrics.observeStreamSubscribers(len(b.subscribers))
+	}
+}
+
+// broadcast. send the diff from the last broadcast snapshot to snapshot to every subscriber without blocking,
+// subscribers with a full buffer are dropped
+func (b *diffBroadcaster) broadcast(snapshot datastructure.TrafficSnapshot, closures []datastructure.RoadClosure) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	diff := datastructure.NewTrafficDiff(b.snapshot, snapshot, b.closures, closures)
+	b.snapshot, b.closures, b.hasSnapshot = snapshot, closures, true
+
+	for s := range b.subscribers {
+		select {
+		case s.diffs <- s.filter(diff):
+		default:
+			s.lagged = true
+			delete(b.subscribers, s)
+			close(s.diffs)
+			b.metrics.observeStreamDrop()
+		}
+	}
+	b.metrics.observeStreamSubscribers(len(b.subscribers))
+}
+
+// SubscribeTraffic. subscribe to the traffic diffs of the published snapshots, starting with the diff from an empty
+// state to the latest snapshot. keep (nil for every way) restricts the diffs to some osm ways, buffer is the number of
+// diffs queued for a slow subscriber before it is dropped
+func (sc *Scraper) SubscribeTraffic(buffer int, keep func(wayId int64) bool) *Subscription {
+	return sc.stream.subscribe(buffer, keep)
+}
@@ -0,0 +1,59 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffBroadcaster(t *testing.T) {
+	b := newDiffBroadcaster(newScraperMetrics("test"))
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	snapshot := func(sequence uint64, speed float64) datastructure.TrafficSnapshot {
+		return datastructure.NewTrafficSnapshot(start.Add(time.Duration(sequence)*time.Minute), []datastructure.WayTraffic{
+			datastructure.NewWayTraffic(datastructure.NewWay(1, nil), datastructure.FORWARD, speed, 50, "", "", "", nil),
+			datastructure.NewWayTraffic(datastructure.NewWay(2, nil), datastructure.FORWARD, 20, 50, "", "", "", nil),
+		}).WithSequence(sequence)
+	}
+
+	// subscribed before the first snapshot: no initial diff
+	early := b.subscribe(1, nil)
+	b.broadcast(snapshot(1, 10), nil)
+	diff := <-early.Diffs()
+	assert.Equal(t, uint64(1), diff.GetSequence())
+	assert.Len(t, diff.GetChanged(), 2)
+
+	// subscribed later: the initial diff is the full last snapshot, filtered
+	late := b.subscribe(2, func(wayId int64) bool { return wayId == 1 })
+	diff = <-late.Diffs()
+	assert.Equal(t, uint64(1), diff.GetSequence())
+	assert.Len(t, diff.GetChanged(), 1)
+
+	b.broadcast(snapshot(2, 15), nil)
+	diff = <-late.Diffs()
+	assert.Equal(t, uint64(2), diff.GetSequence())
+	assert.Len(t, diff.GetChanged(), 1)
+	diff = <-early.Diffs()
+	assert.Len(t, diff.GetChanged(), 1)
+
+	// early doesn't read: its buffer (1) is full at the next broadcast and it is dropped
+	b.broadcast(snapshot(3, 20), nil)
+	b.broadcast(snapshot(4, 25), nil)
+	<-early.Diffs()
+	_, ok := <-early.Diffs()
+	assert.False(t, ok)
+	assert.True(t, early.Lagged())
+	early.Close()
+
+	// late (buffer 2) got both diffs and is still subscribed
+	assert.Len(t, late.Diffs(), 2)
+	late.Close()
+	<-late.Diffs()
+	<-late.Diffs()
+	_, ok = <-late.Diffs()
+	assert.False(t, ok)
+	assert.False(t, late.Lagged())
+	assert.Empty(t, b.subscribers)
+}
@@ -0,0 +1,136 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errCircuitOpen = errors.New("circuit breaker open, waiting for the cool-down")
+
+// circuitBreaker. opens after threshold consecutive failed scrapes, while open no request is sent to waze until the
+// cool-down ends. the first scrape after the cool-down is a probe, if it fails the breaker opens again with a doubled
+// cool-down (up to maxCooldown)
+type circuitBreaker struct {
+	threshold           int
+	cooldown            time.Duration
+	maxCooldown         time.Duration
+	currentCooldown     time.Duration
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown, maxCooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:       threshold,
+		cooldown:        cooldown,
+		maxCooldown:     maxCooldown,
+		currentCooldown: cooldown,
+	}
+}
+
+// allow. false while the breaker is open
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	return !now.Before(cb.openUntil)
+}
+
+func (cb *circuitBreaker) success() {
+	cb.consecutiveFailures = 0
+	cb.currentCooldown = cb.cooldown
+	cb.openUntil = time.Time{}
+}
+
+// failure. record a failed scrape, returns true if the breaker opens
+func (cb *circuitBreaker) failure(now time.Time) bool {
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures < cb.threshold {
+		return false
+	}
+
+	if cb.consecutiveFailures > cb.threshold {
+		// the probe after the cool-down failed
+		cb.currentCooldown = min(2*cb.currentCooldown, cb.maxCooldown)
+	}
+	cb.openUntil = now.Add(cb.currentCooldown)
+	return true
+}
+
+func (cb *circuitBreaker) getConsecutiveFailures() int {
+	return cb.consecutiveFailures
+}
+
+func (cb *circuitBreaker) getOpenUntil() time.Time {
+	return cb.openUntil
+}
+
+// scrapeOnce. fetch, archive, match and store one scrape. a panic in the matcher or the storage is returned as error
+// so the scrape loop keeps running. ctx only cancels the waze requests, once the responses are received the scrape is
+// archived and written even if ctx is cancelled, so the outputs are never left with a partial scrape. stale is true if
+// waze repeated the previous response, it is skipped or written flagged depending on scraper.stale_data
+func (sc *Scraper) scrapeOnce(ctx context.Context, storage Storage, archive *RawArchive) (stale bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("scrape panicked: %v", r))
+		}
+	}()
+
+	raw, err := sc.fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	if archive != nil {
+		if err := archive.write(raw); err != nil {
+			// the raw archive is only needed for replay, keep writing the outputs
+			sc.log.Sugar().Errorf("failed to archive raw waze response: %s", err.Error())
+		}
+	}
+	data, err := decodeRawScrape(raw)
+	if err != nil {
+		return false, err
+	}
+	if sc.stale.check(data) {
+		sc.log.Warn("waze returned the previous response again", zap.Time("data_time", dataTimestamp(data, raw.Timestamp)),
+			zap.Int("repeats", sc.stale.getRepeats()), zap.String("stale_data", sc.staleData))
+		if sc.staleData == STALE_DATA_SKIP {
+			return true, nil
+		}
+		// the served traffic & the diagnostics history are not updated with the repeated data
+		return true, sc.writeSnapshot(storage, sc.newScrapeSnapshot(data, raw.Timestamp).flagStale())
+	}
+
+	sc.updateClosures(data)
+	snapshot := sc.newScrapeSnapshot(data, raw.Timestamp)
+	sc.checkDiagnostics(snapshot.getDiagnostics())
+	// serve the new traffic even if the storage write fails
+	sc.publish(snapshot)
+	return false, sc.writeSnapshot(storage, snapshot)
+}
+
+func (sc *Scraper) writeSnapshot(storage Storage, snapshot scrapeSnapshot) error {
+	start := time.Now()
+	err := storage.Write(snapshot)
+	sc.metrics.observeStorageWrite(storage, start)
+	return err
+}
+
+// checkDiagnostics. record the diagnostics of the scrape and warn about the diagnostics that deviate sharply from the
+// recent scrapes, e.g. a drop in affected ways with a rising unmatched ratio points to a broken map matching instead of
+// less traffic
+func (sc *Scraper) checkDiagnostics(d scrapeDiagnostics) {
+	sc.metrics.observeDiagnostics(d)
+	fields := []zap.Field{
+		zap.Int("jams", d.getJams()), zap.Int("jams_skipped", d.getJamsSkipped()),
+		zap.Int("line_points", d.getLinePoints()), zap.Int("unmatched_points", d.getUnmatchedPoints()),
+		zap.Float64("mean_snap_distance_m", d.getMeanSnapDistance()), zap.Int("affected_ways", d.getAffectedWays()),
+		zap.Duration("data_age", d.getDataAge()),
+	}
+	sc.log.Debug("scrape diagnostics", fields...)
+	for _, deviation := range sc.diagnostics.add(d) {
+		sc.log.Warn("scrape diagnostic deviates from recent history", append([]zap.Field{
+			zap.String("metric", deviation.metric), zap.Float64("value", deviation.value),
+			zap.Float64("median", deviation.median)}, fields...)...)
+	}
+}
@@ -0,0 +1,37 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker(3, time.Minute, 3*time.Minute)
+	now := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+
+	assert.False(t, cb.failure(now))
+	assert.False(t, cb.failure(now))
+	assert.True(t, cb.allow(now))
+
+	// third consecutive failure opens the breaker
+	assert.True(t, cb.failure(now))
+	assert.False(t, cb.allow(now.Add(30*time.Second)))
+	assert.True(t, cb.allow(now.Add(time.Minute)))
+
+	// failed probe doubles the cool-down
+	probe := now.Add(time.Minute)
+	assert.True(t, cb.failure(probe))
+	assert.Equal(t, probe.Add(2*time.Minute), cb.getOpenUntil())
+
+	// cool-down is capped at maxCooldown
+	probe = probe.Add(2 * time.Minute)
+	assert.True(t, cb.failure(probe))
+	assert.Equal(t, probe.Add(3*time.Minute), cb.getOpenUntil())
+
+	cb.success()
+	assert.Equal(t, 0, cb.getConsecutiveFailures())
+	assert.True(t, cb.allow(probe))
+	assert.False(t, cb.failure(probe))
+}
@@ -0,0 +1,96 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+)
+
+// splitBoundingBox. split bounding box into a grid of sub-tiles, each tile side is at most tileSize degrees.
+// waze truncates jams for large areas, so we request every tile separately
+func splitBoundingBox(bb datastructure.BoundingBox, tileSize float64) []datastructure.BoundingBox {
+	minLon, minLat := bb.GetMin()
+	maxLon, maxLat := bb.GetMax()
+	if tileSize <= 0 {
+		return []datastructure.BoundingBox{bb}
+	}
+
+	cols := int(math.Max(1, math.Ceil((maxLon-minLon)/tileSize)))
+	rows := int(math.Max(1, math.Ceil((maxLat-minLat)/tileSize)))
+	lonStep := (maxLon - minLon) / float64(cols)
+	latStep := (maxLat - minLat) / float64(rows)
+
+	tiles := make([]datastructure.BoundingBox, 0, rows*cols)
+	for i := 0; i < rows; i++ {
+		tileMinLat := minLat + float64(i)*latStep
+		tileMaxLat := tileMinLat + latStep
+		if i == rows-1 {
+			tileMaxLat = maxLat
+		}
+		for j := 0; j < cols; j++ {
+			tileMinLon := minLon + float64(j)*lonStep
+			tileMaxLon := tileMinLon + lonStep
+			if j == cols-1 {
+				tileMaxLon = maxLon
+			}
+			tiles = append(tiles, datastructure.NewBoundingBox(tileMinLon, tileMinLat, tileMaxLon, tileMaxLat))
+		}
+	}
+	return tiles
+}
+
+func buildGeorssURL(baseURL string, tile datastructure.BoundingBox) string {
+	minLon, minLat := tile.GetMin()
+	maxLon, maxLat := tile.GetMax()
+	return fmt.Sprintf("%s?top=%.4f&bottom=%.4f&left=%.4f&right=%.4f&env=row&types=%s",
+		baseURL, maxLat, minLat, minLon, maxLon, WAZE_GEORSS_TYPES)
+}
+
+// mergeWazeResponses. merge responses of all tiles into one response, jams that lie on tile borders
+// are returned by more than one tile, so deduplicate them by jam uuid (alert uuid & irregularity id for alerts & irregularities)
+func mergeWazeResponses(responses []wazeResponse) wazeResponse {
+	merged := wazeResponse{
+		Jams:           make([]wazeJam, 0),
+		Alerts:         make([]wazeAlert, 0),
+		Irregularities: make([]wazeIrregularity, 0),
+	}
+	seenJams := make(map[int64]struct{})
+	seenAlerts := make(map[string]struct{})
+	seenIrregularities := make(map[int64]struct{})
+	for _, resp := range responses {
+		if merged.StartTimeMillis == 0 || (resp.StartTimeMillis != 0 && resp.StartTimeMillis < merged.StartTimeMillis) {
+			merged.StartTimeMillis = resp.StartTimeMillis
+			merged.StartTime = resp.StartTime
+		}
+		if resp.EndTimeMillis > merged.EndTimeMillis {
+			merged.EndTimeMillis = resp.EndTimeMillis
+			merged.EndTime = resp.EndTime
+		}
+
+		for _, jam := range resp.Jams {
+			if _, ok := seenJams[jam.UUID]; ok {
+				continue
+			}
+			seenJams[jam.UUID] = struct{}{}
+			merged.Jams = append(merged.Jams, jam)
+		}
+
+		for _, alert := range resp.Alerts {
+			if _, ok := seenAlerts[alert.UUID]; ok {
+				continue
+			}
+			seenAlerts[alert.UUID] = struct{}{}
+			merged.Alerts = append(merged.Alerts, alert)
+		}
+
+		for _, irregularity := range resp.Irregularities {
+			if _, ok := seenIrregularities[irregularity.ID]; ok {
+				continue
+			}
+			seenIrregularities[irregularity.ID] = struct{}{}
+			merged.Irregularities = append(merged.Irregularities, irregularity)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,47 @@
+package scraper
+
+import (
+	"testing"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBoundingBox(t *testing.T) {
+	bb := datastructure.NewBoundingBox(110.132, -8.2618, 110.9221, -6.888)
+	tiles := splitBoundingBox(bb, 0.25)
+
+	// 0.7901 deg lon -> 4 cols, 1.3738 deg lat -> 6 rows
+	assert.Equal(t, 24, len(tiles))
+
+	area := 0.0
+	for _, tile := range tiles {
+		minLon, minLat := tile.GetMin()
+		maxLon, maxLat := tile.GetMax()
+		assert.LessOrEqual(t, maxLon-minLon, 0.25+1e-9)
+		assert.LessOrEqual(t, maxLat-minLat, 0.25+1e-9)
+		area += (maxLon - minLon) * (maxLat - minLat)
+	}
+	assert.InDelta(t, (110.9221-110.132)*(-6.888+8.2618), area, 1e-9)
+
+	lastMaxLon, lastMaxLat := tiles[len(tiles)-1].GetMax()
+	assert.Equal(t, 110.9221, lastMaxLon)
+	assert.Equal(t, -6.888, lastMaxLat)
+
+	assert.Equal(t, []datastructure.BoundingBox{bb}, splitBoundingBox(bb, 0))
+}
+
+func TestMergeWazeResponses(t *testing.T) {
+	responses := []wazeResponse{
+		{StartTimeMillis: 200, EndTimeMillis: 300, Jams: []wazeJam{{UUID: 1}, {UUID: 2}}},
+		{StartTimeMillis: 100, EndTimeMillis: 250, Jams: []wazeJam{{UUID: 2}, {UUID: 3}}},
+	}
+
+	merged := mergeWazeResponses(responses)
+	assert.Equal(t, int64(100), merged.StartTimeMillis)
+	assert.Equal(t, int64(300), merged.EndTimeMillis)
+	assert.Equal(t, 3, len(merged.Jams))
+	for i, uuid := range []int64{1, 2, 3} {
+		assert.Equal(t, uuid, merged.Jams[i].UUID)
+	}
+}
@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+)
+
+// GetWayRanges. split the affected (osm way, travel direction) into consecutive parts covered by jams (jam speed)
+// and parts not covered by jams (osm default speed). offsets are in meters from the first node of the way
+func (sc *Scraper) GetWayRanges(key wayDirectionKey, trafficData osmwayTrafficData) []datastructure.WayRange {
+	return datastructure.SplitWayRanges(trafficData.getRanges(), sc.wayLength(key.getOsmWayId()),
+		sc.osmWayDefaultSpeed[key.getOsmWayId()])
+}
+
+// wayLength. length of the osm way in meters
+func (sc *Scraper) wayLength(osmWayId int64) float64 {
+	way, ok := sc.wayMap[osmWayId]
+	if !ok {
+		return 0
+	}
+	coords := way.GetCoordinates()
+	polyline := make([][2]float64, len(coords))
+	for i, coord := range coords {
+		polyline[i][0], polyline[i][1] = coord.GetLonLat()
+	}
+	return geo.PolylineLength(polyline) * 1000
+}
+
+// writeWayRangesToCSV. append the affected and unaffected parts of every affected (osm way, travel direction) of the snapshot
+func writeWayRangesToCSV(snapshot scrapeSnapshot, csvPath string) error {
+	fileExists := false
+	if _, err := os.Stat(csvPath); err == nil {
+		fileExists = true
+	}
+
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	if !fileExists {
+		if err := w.Write([]string{"timestamp", "osm_way_id", "direction", "start_m", "end_m", "way_length_m",
+			"affected", "speed"}); err != nil {
+			return err
+		}
+	}
+
+	timestamp := snapshot.getTimestamp().Format(time.RFC3339)
+	for _, key := range snapshot.sortedKeys() {
+		ranges := snapshot.getWayRanges()[key]
+		if len(ranges) == 0 {
+			continue
+		}
+		wayLength := ranges[len(ranges)-1].GetEnd()
+		for _, wr := range ranges {
+			rec := []string{
+				timestamp,
+				strconv.FormatInt(key.getOsmWayId(), 10),
+				key.getDirection().String(),
+				fmt.Sprintf("%.2f", wr.GetStart()),
+				fmt.Sprintf("%.2f", wr.GetEnd()),
+				fmt.Sprintf("%.2f", wayLength),
+				strconv.FormatBool(wr.IsAffected()),
+				fmt.Sprintf("%.2f", wr.GetSpeed()),
+			}
+			if err := w.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -30,34 +30,42 @@ func (rt *Rtree) Build(edges []datastructure.Edge, boundingBoxRadius float64, lo
 			continue
 		}
 
-		fromLon, fromLat := edge.GetFromLonLat()
-		toLon, toLat := edge.GetToLonLat()
-		lowerFromLat, lowerFromLon := geo.GetDestinationPoint(fromLat, fromLon, 225, boundingBoxRadius)
-		upperFromLat, upperFromLon := geo.GetDestinationPoint(fromLat, fromLon, 45, boundingBoxRadius)
+		// index every segment of the edge geometry, so long or curved edges are found near their middle too
+		polyline := edge.GetPolyline()
+		for j := 0; j < len(polyline)-1; j++ {
+			fromLon, fromLat := polyline[j][0], polyline[j][1]
+			toLon, toLat := polyline[j+1][0], polyline[j+1][1]
+			lowerFromLat, lowerFromLon := geo.GetDestinationPoint(fromLat, fromLon, 225, boundingBoxRadius)
+			upperFromLat, upperFromLon := geo.GetDestinationPoint(fromLat, fromLon, 45, boundingBoxRadius)
 
-		lowerToLat, lowerToLon := geo.GetDestinationPoint(toLat, toLon, 225, boundingBoxRadius)
-		upperToLat, upperToLon := geo.GetDestinationPoint(toLat, toLon, 45, boundingBoxRadius)
+			lowerToLat, lowerToLon := geo.GetDestinationPoint(toLat, toLon, 225, boundingBoxRadius)
+			upperToLat, upperToLon := geo.GetDestinationPoint(toLat, toLon, 45, boundingBoxRadius)
 
-		minLat := math.Min(lowerFromLat, lowerToLat)
-		minLon := math.Min(lowerFromLon, lowerToLon)
-		maxLat := math.Max(upperFromLat, upperToLat)
-		maxLon := math.Max(upperFromLon, upperToLon)
-
-		rt.tr.Insert([2]float64{minLon, minLat}, [2]float64{maxLon, maxLat},
-			edge)
+			minLat := math.Min(lowerFromLat, lowerToLat)
+			minLon := math.Min(lowerFromLon, lowerToLon)
+			maxLat := math.Max(upperFromLat, upperToLat)
+			maxLon := math.Max(upperFromLon, upperToLon)
 
+			rt.tr.Insert([2]float64{minLon, minLat}, [2]float64{maxLon, maxLat},
+				edge)
+		}
 	}
 	log.Info("R-tree spatial index built.")
 }
 
-// SearchWithinRadius search for all arc endpoints within radius (in km) from the query point (qLat, qLon)
+// SearchWithinRadius search for all arcs with a segment within radius (in km) from the query point (qLat, qLon)
 func (rt *Rtree) SearchWithinRadius(qLon, qLat, radius float64) []datastructure.Edge {
 	lowerLat, lowerLon := geo.GetDestinationPoint(qLat, qLon, 225, radius)
 	upperLat, upperLon := geo.GetDestinationPoint(qLat, qLon, 45, radius)
 
 	results := make([]datastructure.Edge, 0, 10)
+	seen := make(map[uint32]struct{})
 	rt.tr.Search([2]float64{lowerLon, lowerLat}, [2]float64{upperLon, upperLat},
 		func(min, max [2]float64, data datastructure.Edge) bool {
+			if _, ok := seen[data.GetEdgeId()]; ok {
+				return true
+			}
+			seen[data.GetEdgeId()] = struct{}{}
 			results = append(results, data)
 			if len(results) >= 20 {
 				return false
@@ -66,3 +74,22 @@ func (rt *Rtree) SearchWithinRadius(qLon, qLat, radius float64) []datastructure.
 		})
 	return results
 }
+
+// SearchBoundingBox search for all edges with a segment (buffered by the index buffer) intersecting the bounding box
+func (rt *Rtree) SearchBoundingBox(bb datastructure.BoundingBox) []datastructure.Edge {
+	minLon, minLat := bb.GetMin()
+	maxLon, maxLat := bb.GetMax()
+
+	results := make([]datastructure.Edge, 0, 64)
+	seen := make(map[uint32]struct{})
+	rt.tr.Search([2]float64{minLon, minLat}, [2]float64{maxLon, maxLat},
+		func(min, max [2]float64, data datastructure.Edge) bool {
+			if _, ok := seen[data.GetEdgeId()]; ok {
+				return true
+			}
+			seen[data.GetEdgeId()] = struct{}{}
+			results = append(results, data)
+			return true
+		})
+	return results
+}
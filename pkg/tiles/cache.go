@@ -0,0 +1,70 @@
+package tiles
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/paulmach/orb/maptile"
+	"golang.org/x/sync/singleflight"
+)
+
+// TileCache. encoded tiles of the latest traffic snapshot of a region, every tile is built once per snapshot and the
+// cache is emptied when a newer snapshot is requested
+type TileCache struct {
+	mu       sync.Mutex
+	sequence uint64 // snapshot sequence of the cached tiles
+	tiles    map[maptile.Tile][]byte
+	maxTiles int
+	builds   singleflight.Group
+}
+
+func NewTileCache(maxTiles int) *TileCache {
+	return &TileCache{
+		tiles:    make(map[maptile.Tile][]byte),
+		maxTiles: maxTiles,
+	}
+}
+
+// Get. cached tile of the snapshot with the sequence, otherwise the tile is built (once for concurrent requests of the
+// same tile) and cached. a full cache evicts an arbitrary tile
+func (c *TileCache) Get(sequence uint64, tile maptile.Tile, build func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if sequence > c.sequence {
+		c.sequence = sequence
+		c.tiles = make(map[maptile.Tile][]byte)
+	}
+	if data, ok := c.tiles[tile]; ok && sequence == c.sequence {
+		c.mu.Unlock()
+		return data, nil
+	}
+	c.mu.Unlock()
+
+	key := fmt.Sprintf("%d/%d/%d/%d", sequence, tile.Z, tile.X, tile.Y)
+	data, err, _ := c.builds.Do(key, func() (interface{}, error) {
+		return build()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// a build of an older snapshot is not cached, it finished after a newer snapshot emptied the cache
+	if sequence == c.sequence {
+		if len(c.tiles) >= c.maxTiles {
+			for evicted := range c.tiles {
+				delete(c.tiles, evicted)
+				break
+			}
+		}
+		c.tiles[tile] = data.([]byte)
+	}
+	return data.([]byte), nil
+}
+
+// Len. number of cached tiles
+func (c *TileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tiles)
+}
@@ -0,0 +1,60 @@
+package tiles
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTileCache(t *testing.T) {
+	cache := NewTileCache(2)
+	tile := maptile.New(1, 2, 3)
+	var builds atomic.Int32
+	build := func(data string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			builds.Add(1)
+			return []byte(data), nil
+		}
+	}
+
+	// concurrent requests of a snapshot get the same tile, later requests are served from the cache
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := cache.Get(1, tile, build("a"))
+			assert.NoError(t, err)
+			assert.Equal(t, "a", string(data))
+		}()
+	}
+	wg.Wait()
+	builds.Store(0)
+	data, _ := cache.Get(1, tile, build("a"))
+	assert.Equal(t, "a", string(data))
+	assert.Equal(t, int32(0), builds.Load())
+
+	// a new snapshot empties the cache
+	data, _ = cache.Get(2, tile, build("b"))
+	assert.Equal(t, "b", string(data))
+	assert.Equal(t, int32(1), builds.Load())
+	assert.Equal(t, 1, cache.Len())
+
+	// an older snapshot is built but not cached
+	data, _ = cache.Get(1, tile, build("a"))
+	assert.Equal(t, "a", string(data))
+	data, _ = cache.Get(2, tile, build("c"))
+	assert.Equal(t, "b", string(data))
+
+	// the cache holds at most maxTiles tiles, errors are not cached
+	cache.Get(2, maptile.New(0, 0, 3), build("d"))
+	cache.Get(2, maptile.New(0, 1, 3), build("e"))
+	assert.Equal(t, 2, cache.Len())
+	_, err := cache.Get(2, maptile.New(0, 2, 3), func() ([]byte, error) { return nil, errors.New("failed") })
+	assert.Error(t, err)
+	assert.Equal(t, 2, cache.Len())
+}
@@ -0,0 +1,109 @@
+package tiles
+
+import (
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+const (
+	LAYER_TRAFFIC = "traffic"
+	MAX_ZOOM      = 22
+
+	TILE_BUFFER        = 64  // tile extent units kept around the tile, lines don't end at the tile border
+	SIMPLIFY_TOLERANCE = 1.0 // douglas-peucker tolerance in tile extent units
+	MIN_LINE_LENGTH    = 1.0 // lines shorter than this (tile extent units) after simplifying are dropped
+)
+
+// clipBound. tile extent with the buffer
+var clipBound = orb.Bound{
+	Min: orb.Point{-TILE_BUFFER, -TILE_BUFFER},
+	Max: orb.Point{mvt.DefaultExtent + TILE_BUFFER, mvt.DefaultExtent + TILE_BUFFER},
+}
+
+// TileBoundingBox. lon/lat bounding box of the tile with the buffer, traffic outside of it is clipped away
+func TileBoundingBox(tile maptile.Tile) datastructure.BoundingBox {
+	bound := tile.Bound(float64(TILE_BUFFER) / mvt.DefaultExtent)
+	return datastructure.NewBoundingBox(bound.Min.Lon(), bound.Min.Lat(), bound.Max.Lon(), bound.Max.Lat())
+}
+
+// EncodeTile. mvt tile with one traffic layer, every range of a way traffic (see datastructure.WayRange) is a line (in
+// the travel direction) with the speed of the range, a way traffic without ranges is one line of the whole way. the lines
+// are projected to the tile, clipped to the tile plus buffer & simplified. speeds are in km/h, start_m & end_m are the
+// offsets of the range from the first node of the osm way, highway returns the highway class of an osm way
+func EncodeTile(tile maptile.Tile, traffic []datastructure.WayTraffic, highway func(wayId int64) string) ([]byte, error) {
+	fc := geojson.NewFeatureCollection()
+	for _, wt := range traffic {
+		coords := wt.GetWay().GetCoordinates()
+		if len(coords) < 2 {
+			continue
+		}
+		polyline := make([][2]float64, len(coords))
+		for i, coord := range coords {
+			lon, lat := coord.GetLonLat()
+			polyline[i] = [2]float64{lon, lat}
+		}
+
+		if len(wt.GetRanges()) == 0 {
+			f := newTrafficFeature(polyline, wt, wt.GetSpeed(), highway)
+			if ratio, ok := wt.GetCongestionRatio(); ok {
+				f.Properties["congestion_ratio"] = ratio
+			}
+			fc.Append(f)
+			continue
+		}
+		for _, wr := range wt.GetRanges() {
+			sub := geo.SubPolyline(polyline, wr.GetStart()/1000, wr.GetEnd()/1000)
+			if len(sub) < 2 {
+				continue
+			}
+			f := newTrafficFeature(sub, wt, wr.GetSpeed(), highway)
+			if freeFlowSpeed, ok := wt.GetFreeFlowSpeed(); ok {
+				f.Properties["congestion_ratio"] = min(max(1-wr.GetSpeed()/freeFlowSpeed, 0), 1)
+			}
+			f.Properties["start_m"] = wr.GetStart()
+			f.Properties["end_m"] = wr.GetEnd()
+			f.Properties["affected"] = wr.IsAffected()
+			fc.Append(f)
+		}
+	}
+
+	layer := mvt.NewLayer(LAYER_TRAFFIC, fc)
+	layer.ProjectToTile(tile)
+	layer.Clip(clipBound)
+	layer.Simplify(simplify.DouglasPeucker(SIMPLIFY_TOLERANCE))
+	layer.RemoveEmpty(MIN_LINE_LENGTH, 0)
+	return mvt.Marshal(mvt.Layers{layer})
+}
+
+// newTrafficFeature. line feature of the way traffic with the speed, the polyline is in the osm way node order
+func newTrafficFeature(polyline [][2]float64, wt datastructure.WayTraffic, speed float64,
+	highway func(wayId int64) string) *geojson.Feature {
+	line := make(orb.LineString, len(polyline))
+	for i, p := range polyline {
+		if wt.GetDirection() == datastructure.BACKWARD {
+			line[len(polyline)-1-i] = orb.Point(p)
+		} else {
+			line[i] = orb.Point(p)
+		}
+	}
+
+	f := geojson.NewFeature(line)
+	f.Properties["way_id"] = wt.GetWay().GetID()
+	f.Properties["direction"] = wt.GetDirection().String()
+	f.Properties["speed"] = speed
+	if freeFlowSpeed, ok := wt.GetFreeFlowSpeed(); ok {
+		f.Properties["free_flow_speed"] = freeFlowSpeed
+	}
+	if class := highway(wt.GetWay().GetID()); class != "" {
+		f.Properties["highway"] = class
+	}
+	if street := wt.GetOsmStreet(); street != "" {
+		f.Properties["street_name"] = street
+	}
+	return f
+}
@@ -0,0 +1,97 @@
+package tiles
+
+import (
+	"testing"
+
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/datastructure"
+	"github.com/lintang-b-s/waze-traffic-scraper/pkg/geo"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeTile(t *testing.T) {
+	tile := maptile.At(orb.Point{110.365, -7.78}, 14)
+	center := tile.Center()
+	way := datastructure.NewWay(1, []datastructure.Coordinate{
+		datastructure.NewCoordinate(center.Lon()-0.1, center.Lat()),
+		datastructure.NewCoordinate(center.Lon(), center.Lat()),
+		datastructure.NewCoordinate(center.Lon()+0.1, center.Lat()),
+	})
+	far := datastructure.NewWay(2, []datastructure.Coordinate{
+		datastructure.NewCoordinate(center.Lon()+1, center.Lat()),
+		datastructure.NewCoordinate(center.Lon()+1.1, center.Lat()),
+	})
+	traffic := []datastructure.WayTraffic{
+		datastructure.NewWayTraffic(way, datastructure.FORWARD, 10, 50, "", "", "Jalan Malioboro", nil),
+		datastructure.NewWayTraffic(far, datastructure.FORWARD, 10, 0, "", "", "", nil),
+	}
+	highway := func(wayId int64) string { return "primary" }
+
+	data, err := EncodeTile(tile, traffic, highway)
+	assert.NoError(t, err)
+	layers, err := mvt.Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Len(t, layers, 1)
+	assert.Equal(t, LAYER_TRAFFIC, layers[0].Name)
+
+	// the way crossing the tile is clipped to the tile plus buffer, the far way is dropped
+	features := layers[0].Features
+	assert.Len(t, features, 1)
+	bound := features[0].Geometry.Bound()
+	assert.Equal(t, float64(-TILE_BUFFER), bound.Min.X())
+	assert.Equal(t, float64(mvt.DefaultExtent+TILE_BUFFER), bound.Max.X())
+
+	props := features[0].Properties
+	assert.EqualValues(t, 1, props["way_id"])
+	assert.Equal(t, "forward", props["direction"])
+	assert.InDelta(t, 0.8, props["congestion_ratio"], 1e-9)
+	assert.Equal(t, "primary", props["highway"])
+	assert.Equal(t, "Jalan Malioboro", props["street_name"])
+
+	empty, err := EncodeTile(tile, traffic[1:], highway)
+	assert.NoError(t, err)
+	layers, err = mvt.Unmarshal(empty)
+	assert.NoError(t, err)
+	assert.Empty(t, layers[0].Features)
+}
+
+func TestEncodeTileRanges(t *testing.T) {
+	tile := maptile.At(orb.Point{110.365, -7.78}, 14)
+	center := tile.Center()
+	way := datastructure.NewWay(1, []datastructure.Coordinate{
+		datastructure.NewCoordinate(center.Lon()-0.1, center.Lat()),
+		datastructure.NewCoordinate(center.Lon()+0.1, center.Lat()),
+	})
+	length := geo.PolylineLength([][2]float64{{center.Lon() - 0.1, center.Lat()}, {center.Lon() + 0.1, center.Lat()}}) * 1000
+	// jam on the second half of the way, which starts at the tile center
+	ranges := []datastructure.WayRange{
+		datastructure.NewWayRange(0, length/2, 50, false),
+		datastructure.NewWayRange(length/2, length, 10, true),
+	}
+	traffic := []datastructure.WayTraffic{
+		datastructure.NewWayTraffic(way, datastructure.FORWARD, 10, 50, "", "", "", ranges),
+	}
+
+	data, err := EncodeTile(tile, traffic, func(wayId int64) string { return "" })
+	assert.NoError(t, err)
+	layers, err := mvt.Unmarshal(data)
+	assert.NoError(t, err)
+
+	features := layers[0].Features
+	assert.Len(t, features, 2)
+	assert.Equal(t, false, features[0].Properties["affected"])
+	assert.EqualValues(t, 50, features[0].Properties["speed"])
+	assert.InDelta(t, 0, features[0].Properties["congestion_ratio"], 1e-9)
+
+	jam := features[1]
+	assert.Equal(t, true, jam.Properties["affected"])
+	assert.EqualValues(t, 10, jam.Properties["speed"])
+	assert.InDelta(t, 0.8, jam.Properties["congestion_ratio"], 1e-9)
+	assert.InDelta(t, length/2, jam.Properties["start_m"], 1e-6)
+	assert.InDelta(t, length, jam.Properties["end_m"], 1e-6)
+	bound := jam.Geometry.Bound()
+	assert.InDelta(t, mvt.DefaultExtent/2, bound.Min.X(), 1)
+	assert.Equal(t, float64(mvt.DefaultExtent+TILE_BUFFER), bound.Max.X())
+}